@@ -19,9 +19,129 @@ package v2
 
 import (
 	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// HAEgressGatewayPolicySpec is a CiliumEgressGatewayPolicySpec with the placement rules of
+// the egress IPs
+type HAEgressGatewayPolicySpec struct {
+	ciliumv2.CiliumEgressGatewayPolicySpec `json:",inline"`
+
+	// Affinity constrains the gateway nodes of the policy relative to the gateway nodes of
+	// other HAEgressGatewayPolicies
+	// +kubebuilder:validation:Optional
+	Affinity *HAEgressGatewayPolicyAffinity `json:"affinity,omitempty"`
+
+	// ServiceTemplate customizes the Services holding the egress IPs of the policy, it is
+	// merged over the template of the operator and of its class
+	// +kubebuilder:validation:Optional
+	ServiceTemplate *PolicyServiceTemplate `json:"serviceTemplate,omitempty"`
+}
+
+// PolicyServiceTemplate is the Service template of a policy
+type PolicyServiceTemplate struct {
+	// ClassName selects a service class of the operator configuration, its template is
+	// applied before the one of the policy
+	// +kubebuilder:validation:Optional
+	ClassName string `json:"className,omitempty"`
+
+	ServiceTemplate `json:",inline"`
+}
+
+// ServiceTemplate customizes the generated Service. The type, selector, load balancer class
+// and IP families of the Service, the labels of the operator and the requested IPs are set
+// by the operator and cannot be changed.
+type ServiceTemplate struct {
+	// Metadata are the labels and annotations added to the Service, like the options of the
+	// load balancer
+	// +kubebuilder:validation:Optional
+	Metadata ServiceTemplateMetadata `json:"metadata,omitempty"`
+
+	// Spec is merged over the spec of the generated Service
+	// +kubebuilder:validation:Optional
+	Spec ServiceTemplateSpec `json:"spec,omitempty"`
+}
+
+// ServiceTemplateMetadata are the labels and annotations of a ServiceTemplate
+type ServiceTemplateMetadata struct {
+	// +kubebuilder:validation:Optional
+	Labels map[string]string `json:"labels,omitempty"`
+	// +kubebuilder:validation:Optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ServiceTemplateSpec are the fields of the Service spec a ServiceTemplate can set, the
+// fields not set keep the values of the previous template
+type ServiceTemplateSpec struct {
+	// Ports replace the single TCP port 65534 of the generated Service
+	// +kubebuilder:validation:Optional
+	Ports []corev1.ServicePort `json:"ports,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Cluster;Local
+	ExternalTrafficPolicy corev1.ServiceExternalTrafficPolicy `json:"externalTrafficPolicy,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Cluster;Local
+	InternalTrafficPolicy *corev1.ServiceInternalTrafficPolicy `json:"internalTrafficPolicy,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	AllocateLoadBalancerNodePorts *bool `json:"allocateLoadBalancerNodePorts,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	LoadBalancerSourceRanges []string `json:"loadBalancerSourceRanges,omitempty"`
+}
+
+// HAEgressGatewayPolicyAffinity groups the affinity and anti-affinity rules between policies
+type HAEgressGatewayPolicyAffinity struct {
+	// PolicyAffinity keeps the egress IPs in the same topology domain as the egress IPs of
+	// the selected policies
+	// +kubebuilder:validation:Optional
+	PolicyAffinity []PolicyAffinityTerm `json:"policyAffinity,omitempty"`
+
+	// PolicyAntiAffinity keeps the egress IPs out of the topology domains of the egress IPs
+	// of the selected policies
+	// +kubebuilder:validation:Optional
+	PolicyAntiAffinity []PolicyAffinityTerm `json:"policyAntiAffinity,omitempty"`
+}
+
+// PolicyAffinityTerm selects a set of HAEgressGatewayPolicies and the node label defining
+// the topology domain they are compared on
+type PolicyAffinityTerm struct {
+	// LabelSelector selects the other HAEgressGatewayPolicies
+	LabelSelector *metav1.LabelSelector `json:"labelSelector"`
+
+	// TopologyKey is the node label defining the topology domain, like
+	// kubernetes.io/hostname or topology.kubernetes.io/zone
+	// +kubebuilder:default="kubernetes.io/hostname"
+	// +kubebuilder:validation:Optional
+	TopologyKey string `json:"topologyKey,omitempty"`
+}
+
+// HAEgressGatewayPolicyShardStatus defines the observed state of a single egress IP when
+// the policy has more replicas
+type HAEgressGatewayPolicyShardStatus struct {
+	Shard   int32  `json:"shard"`
+	Service string `json:"service"`
+
+	// +kubebuilder:validation:Optional
+	ExitNode string `json:"exitNode,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	IPAddress string `json:"ipAddress,omitempty"`
+}
+
+// HAEgressGatewayPolicyZoneStatus reports the selected pods and the gateway nodes of the
+// policy in a zone
+type HAEgressGatewayPolicyZoneStatus struct {
+	Zone string `json:"zone"`
+	Pods int32  `json:"pods"`
+
+	// +kubebuilder:validation:Optional
+	GatewayNodes int32 `json:"gatewayNodes,omitempty"`
+}
+
 // HAEgressGatewayPolicy defines the observed state of haEgressGatewayPolicy
 type HAEgressGatewayPolicyStatus struct {
 	ServiceCreated bool `json:"serviceCreated"`
@@ -33,8 +153,40 @@ type HAEgressGatewayPolicyStatus struct {
 	// +kubebuilder:validation:Optional
 	IPAddress string `json:"ipAddress,omitempty"`
 
+	// IPAddresses lists all the assigned addresses, one per IP family on dual-stack services
+	// +kubebuilder:validation:Optional
+	IPAddresses []string `json:"ipAddresses,omitempty"`
+
 	// +kubebuilder:validation:Optional
 	LastModifiedTime metav1.Time `json:"lastModifiedTime,omitempty"`
+
+	// Shards reports the egress IP and exit node of every shard when the policy has more replicas
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=shard
+	Shards []HAEgressGatewayPolicyShardStatus `json:"shards,omitempty"`
+
+	// ExitZone is the zone of the exit node
+	// +kubebuilder:validation:Optional
+	ExitZone string `json:"exitZone,omitempty"`
+
+	// PreferredZone is the zone running most of the selected pods, where zone-aware
+	// policies place their egress IPs
+	// +kubebuilder:validation:Optional
+	PreferredZone string `json:"preferredZone,omitempty"`
+
+	// Zones reports the distribution of the selected pods and gateway nodes across the zones
+	// of zone-aware policies
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=zone
+	Zones []HAEgressGatewayPolicyZoneStatus `json:"zones,omitempty"`
+
+	// Conditions reports the latest observations of the policy state
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -49,8 +201,8 @@ type HAEgressGatewayPolicy struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-	Spec   ciliumv2.CiliumEgressGatewayPolicySpec `json:"spec,omitempty"`
-	Status HAEgressGatewayPolicyStatus            `json:"status,omitempty"`
+	Spec   HAEgressGatewayPolicySpec   `json:"spec,omitempty"`
+	Status HAEgressGatewayPolicyStatus `json:"status,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -22,6 +22,8 @@ limitations under the License.
 package v2
 
 import (
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -31,7 +33,7 @@ func (in *HAEgressGatewayPolicy) DeepCopyInto(out *HAEgressGatewayPolicy) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HAEgressGatewayPolicy.
@@ -52,6 +54,35 @@ func (in *HAEgressGatewayPolicy) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HAEgressGatewayPolicyAffinity) DeepCopyInto(out *HAEgressGatewayPolicyAffinity) {
+	*out = *in
+	if in.PolicyAffinity != nil {
+		in, out := &in.PolicyAffinity, &out.PolicyAffinity
+		*out = make([]PolicyAffinityTerm, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.PolicyAntiAffinity != nil {
+		in, out := &in.PolicyAntiAffinity, &out.PolicyAntiAffinity
+		*out = make([]PolicyAffinityTerm, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HAEgressGatewayPolicyAffinity.
+func (in *HAEgressGatewayPolicyAffinity) DeepCopy() *HAEgressGatewayPolicyAffinity {
+	if in == nil {
+		return nil
+	}
+	out := new(HAEgressGatewayPolicyAffinity)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *HAEgressGatewayPolicyList) DeepCopyInto(out *HAEgressGatewayPolicyList) {
 	*out = *in
@@ -84,9 +115,73 @@ func (in *HAEgressGatewayPolicyList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HAEgressGatewayPolicyShardStatus) DeepCopyInto(out *HAEgressGatewayPolicyShardStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HAEgressGatewayPolicyShardStatus.
+func (in *HAEgressGatewayPolicyShardStatus) DeepCopy() *HAEgressGatewayPolicyShardStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(HAEgressGatewayPolicyShardStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HAEgressGatewayPolicySpec) DeepCopyInto(out *HAEgressGatewayPolicySpec) {
+	*out = *in
+	in.CiliumEgressGatewayPolicySpec.DeepCopyInto(&out.CiliumEgressGatewayPolicySpec)
+	if in.Affinity != nil {
+		in, out := &in.Affinity, &out.Affinity
+		*out = new(HAEgressGatewayPolicyAffinity)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ServiceTemplate != nil {
+		in, out := &in.ServiceTemplate, &out.ServiceTemplate
+		*out = new(PolicyServiceTemplate)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HAEgressGatewayPolicySpec.
+func (in *HAEgressGatewayPolicySpec) DeepCopy() *HAEgressGatewayPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(HAEgressGatewayPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *HAEgressGatewayPolicyStatus) DeepCopyInto(out *HAEgressGatewayPolicyStatus) {
 	*out = *in
+	if in.IPAddresses != nil {
+		in, out := &in.IPAddresses, &out.IPAddresses
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.LastModifiedTime.DeepCopyInto(&out.LastModifiedTime)
+	if in.Shards != nil {
+		in, out := &in.Shards, &out.Shards
+		*out = make([]HAEgressGatewayPolicyShardStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.Zones != nil {
+		in, out := &in.Zones, &out.Zones
+		*out = make([]HAEgressGatewayPolicyZoneStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HAEgressGatewayPolicyStatus.
@@ -98,3 +193,137 @@ func (in *HAEgressGatewayPolicyStatus) DeepCopy() *HAEgressGatewayPolicyStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HAEgressGatewayPolicyZoneStatus) DeepCopyInto(out *HAEgressGatewayPolicyZoneStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HAEgressGatewayPolicyZoneStatus.
+func (in *HAEgressGatewayPolicyZoneStatus) DeepCopy() *HAEgressGatewayPolicyZoneStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(HAEgressGatewayPolicyZoneStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PolicyAffinityTerm) DeepCopyInto(out *PolicyAffinityTerm) {
+	*out = *in
+	if in.LabelSelector != nil {
+		in, out := &in.LabelSelector, &out.LabelSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PolicyAffinityTerm.
+func (in *PolicyAffinityTerm) DeepCopy() *PolicyAffinityTerm {
+	if in == nil {
+		return nil
+	}
+	out := new(PolicyAffinityTerm)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PolicyServiceTemplate) DeepCopyInto(out *PolicyServiceTemplate) {
+	*out = *in
+	in.ServiceTemplate.DeepCopyInto(&out.ServiceTemplate)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PolicyServiceTemplate.
+func (in *PolicyServiceTemplate) DeepCopy() *PolicyServiceTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(PolicyServiceTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceTemplate) DeepCopyInto(out *ServiceTemplate) {
+	*out = *in
+	in.Metadata.DeepCopyInto(&out.Metadata)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceTemplate.
+func (in *ServiceTemplate) DeepCopy() *ServiceTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceTemplateMetadata) DeepCopyInto(out *ServiceTemplateMetadata) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceTemplateMetadata.
+func (in *ServiceTemplateMetadata) DeepCopy() *ServiceTemplateMetadata {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceTemplateMetadata)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceTemplateSpec) DeepCopyInto(out *ServiceTemplateSpec) {
+	*out = *in
+	if in.Ports != nil {
+		in, out := &in.Ports, &out.Ports
+		*out = make([]v1.ServicePort, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.InternalTrafficPolicy != nil {
+		in, out := &in.InternalTrafficPolicy, &out.InternalTrafficPolicy
+		*out = new(v1.ServiceInternalTrafficPolicy)
+		**out = **in
+	}
+	if in.AllocateLoadBalancerNodePorts != nil {
+		in, out := &in.AllocateLoadBalancerNodePorts, &out.AllocateLoadBalancerNodePorts
+		*out = new(bool)
+		**out = **in
+	}
+	if in.LoadBalancerSourceRanges != nil {
+		in, out := &in.LoadBalancerSourceRanges, &out.LoadBalancerSourceRanges
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceTemplateSpec.
+func (in *ServiceTemplateSpec) DeepCopy() *ServiceTemplateSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceTemplateSpec)
+	in.DeepCopyInto(out)
+	return out
+}
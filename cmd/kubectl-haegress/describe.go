@@ -0,0 +1,137 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// describe prints the policy together with the Services and CiliumEgressGatewayPolicies
+// generated for its shards, the expected names are computed like the operator does so that
+// missing or foreign objects are reported
+func describe(ctx context.Context, c client.Client, args []string) error {
+	fs := flag.NewFlagSet("describe", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: describe POLICY\n")
+		fs.PrintDefaults()
+	}
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return fmt.Errorf("a policy name is required")
+	}
+
+	policy := &haegressv2.HAEgressGatewayPolicy{}
+	if err := c.Get(ctx, types.NamespacedName{Name: positional[0]}, policy); err != nil {
+		return err
+	}
+	spec := policy.Spec.CiliumEgressGatewayPolicySpec
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "HAEgressGatewayPolicy:\t%s\n", policy.Name)
+	fmt.Fprintf(w, "Destinations:\t%s\n", orNone(joinCIDRs(spec.DestinationCIDRs)))
+	fmt.Fprintf(w, "Excluded:\t%s\n", orNone(joinCIDRs(spec.ExcludedCIDRs)))
+	if spec.EgressGateway != nil && spec.EgressGateway.NodeSelector != nil {
+		fmt.Fprintf(w, "Node Selector:\t%s\n", orNone(slimv1.FormatLabelSelector(spec.EgressGateway.NodeSelector)))
+	}
+	fmt.Fprintf(w, "Replicas:\t%d\n", haegressiputil.PolicyReplicas(policy))
+	fmt.Fprintf(w, "Service Namespace:\t%s\n", haegressiputil.ServiceNamespace(policy, egressNamespace))
+	fmt.Fprintf(w, "Conditions:\t%s\n", orNone(activeConditions(policy)))
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	vipProvider := provider.NewKubeVIP(c)
+	serviceNamespace := haegressiputil.ServiceNamespace(policy, egressNamespace)
+	for shard := 0; shard < haegressiputil.PolicyReplicas(policy); shard++ {
+		serviceName := haegressiputil.ShardServiceName(policy.Name, shard)
+		fmt.Printf("\nShard %d:\n", shard)
+		w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+		service := &corev1.Service{}
+		err := c.Get(ctx, types.NamespacedName{Namespace: serviceNamespace, Name: serviceName}, service)
+		switch {
+		case apierrors.IsNotFound(err):
+			fmt.Fprintf(w, "  Service:\t%s/%s (missing)\n", serviceNamespace, serviceName)
+		case err != nil:
+			return err
+		default:
+			fmt.Fprintf(w, "  Service:\t%s/%s (%s)\n", serviceNamespace, serviceName, ownership(service, policy))
+			fmt.Fprintf(w, "    Load Balancer IPs:\t%s\n", orNone(strings.Join(haegressiputil.AssignedLoadBalancerIPs(service), ", ")))
+			fmt.Fprintf(w, "    VIP Node:\t%s\n", orNone(vipProvider.CurrentNode(service)))
+		}
+
+		name := haegressiputil.CiliumEgressGatewayPolicyName(serviceNamespace, serviceName)
+		ciliumEgressGatewayPolicy := &ciliumv2.CiliumEgressGatewayPolicy{}
+		err = c.Get(ctx, types.NamespacedName{Name: name}, ciliumEgressGatewayPolicy)
+		switch {
+		case apierrors.IsNotFound(err):
+			fmt.Fprintf(w, "  CiliumEgressGatewayPolicy:\t%s (missing)\n", name)
+		case err != nil:
+			return err
+		default:
+			fmt.Fprintf(w, "  CiliumEgressGatewayPolicy:\t%s (%s)\n", name, ownership(ciliumEgressGatewayPolicy, policy))
+			egressIP := ""
+			if ciliumEgressGatewayPolicy.Spec.EgressGateway != nil {
+				egressIP = ciliumEgressGatewayPolicy.Spec.EgressGateway.EgressIP
+			}
+			fmt.Fprintf(w, "    Egress IP:\t%s\n", orNone(egressIP))
+			fmt.Fprintf(w, "    Gateway Node:\t%s\n", orNone(haegressiputil.CiliumEgressGatewayPolicyNode(ciliumEgressGatewayPolicy)))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ownership describes whether the object is controlled by the policy
+func ownership(obj metav1.Object, policy *haegressv2.HAEgressGatewayPolicy) string {
+	if metav1.IsControlledBy(obj, policy) {
+		return "owned"
+	}
+	if owner := metav1.GetControllerOf(obj); owner != nil {
+		return fmt.Sprintf("owned by %s/%s", strings.ToLower(owner.Kind), owner.Name)
+	}
+	return "not owned"
+}
+
+func joinCIDRs(cidrs []ciliumv2.IPv4CIDR) string {
+	values := []string{}
+	for _, cidr := range cidrs {
+		values = append(values, string(cidr))
+	}
+	return strings.Join(values, ", ")
+}
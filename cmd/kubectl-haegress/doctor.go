@@ -0,0 +1,100 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	"k8s.io/apimachinery/pkg/labels"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// doctor checks the prerequisites of the operator and prints the findings with a hint to
+// fix the problems, it fails when a problem is found
+func doctor(ctx context.Context, c client.Client, args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	options := haegressiputil.DoctorOptions{}
+	fs.StringVar(&options.CiliumNamespace, "cilium-namespace", "kube-system", "The namespace of the Cilium ConfigMap")
+	fs.StringVar(&options.CiliumConfigMap, "cilium-configmap", "cilium-config", "The name of the Cilium ConfigMap")
+	fs.StringVar(&options.KubeVIPNamespace, "kube-vip-namespace", "kube-system", "The namespace of the kube-vip DaemonSet")
+	kubeVIPSelector := fs.String("kube-vip-selector", "app.kubernetes.io/name=kube-vip-ds", "The label selector of the kube-vip DaemonSet")
+	fs.StringVar(&options.LoadBalancerClass, "load-balancer-class", haegressiputil.DefaultKubeVIPLoadBalancerClass, "The LoadBalancer class used by the operator")
+	serviceAccount := fs.String("service-account", "", "The NAMESPACE/NAME of the operator service account whose permissions are checked, "+
+		"empty checks the current user")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: doctor [FLAGS]\n")
+		fs.PrintDefaults()
+	}
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected arguments %v", positional)
+	}
+	if options.KubeVIPSelector, err = labels.Parse(*kubeVIPSelector); err != nil {
+		return err
+	}
+
+	findings := haegressiputil.Doctor(ctx, c, options)
+
+	// The permissions are reviewed impersonating the operator service account
+	reviewer := c
+	if *serviceAccount != "" {
+		namespace, name, ok := strings.Cut(*serviceAccount, "/")
+		if !ok {
+			return fmt.Errorf("--service-account must be NAMESPACE/NAME")
+		}
+		config, err := ctrl.GetConfig()
+		if err != nil {
+			return err
+		}
+		config.Impersonate.UserName = fmt.Sprintf("system:serviceaccount:%s:%s", namespace, name)
+		if reviewer, err = client.New(config, client.Options{Scheme: scheme}); err != nil {
+			return err
+		}
+	}
+	findings = append(findings, haegressiputil.CheckPermissions(ctx, reviewer)...)
+
+	problems := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SEVERITY\tCHECK\tFINDING")
+	for _, finding := range findings {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", finding.Severity, finding.Check, finding.Message)
+		if finding.Hint != "" {
+			fmt.Fprintf(w, "\t\t  hint: %s\n", finding.Hint)
+		}
+		if finding.Severity == haegressiputil.FindingError {
+			problems++
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if problems > 0 {
+		return fmt.Errorf("%d problem(s) found", problems)
+	}
+	return nil
+}
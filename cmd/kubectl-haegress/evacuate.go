@@ -0,0 +1,125 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"time"
+
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// pollInterval is the interval between two progress checks of evacuate and move
+const pollInterval = 2 * time.Second
+
+// evacuate sets the egress-evacuate annotation on the node, the operator moves every VIP
+// off the node and does not assign new ones to it, and reports the progress per policy
+func evacuate(ctx context.Context, c client.Client, args []string) error {
+	fs := flag.NewFlagSet("evacuate", flag.ContinueOnError)
+	undo := fs.Bool("undo", false, "Remove the evacuation request, the node can hold egress VIPs again")
+	wait := fs.Bool("wait", true, "Wait until every egress VIP has left the node")
+	timeout := fs.Duration("timeout", 5*time.Minute, "How long to wait for the evacuation")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: evacuate NODE [--undo] [--wait] [--timeout DURATION]\n")
+		fs.PrintDefaults()
+	}
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return fmt.Errorf("a node name is required")
+	}
+	nodeName := positional[0]
+
+	node := &corev1.Node{}
+	if err := c.Get(ctx, types.NamespacedName{Name: nodeName}, node); err != nil {
+		return err
+	}
+	var value *string
+	if !*undo {
+		enabled := "true"
+		value = &enabled
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]*string{haegressip.EvacuateAnnotation: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if err := c.Patch(ctx, node, client.RawPatch(types.MergePatchType, patch)); err != nil {
+		return err
+	}
+	if *undo {
+		fmt.Printf("node/%s can hold egress VIPs again\n", nodeName)
+		return nil
+	}
+	fmt.Printf("node/%s marked for egress evacuation\n", nodeName)
+	if !*wait {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	reported := map[string]string{}
+	for {
+		usages, err := haegressiputil.NodeEgressUsages(ctx, c, provider.NewKubeVIP(c), nodeName)
+		if err != nil {
+			return err
+		}
+		pending := map[string]bool{}
+		for _, usage := range usages {
+			pending[usage.Policy] = true
+			progress := fmt.Sprintf("%d VIP(s) and %d CiliumEgressGatewayPolicy(ies) still on the node",
+				len(usage.Services), len(usage.CiliumEgressGatewayPolicies))
+			if reported[usage.Policy] != progress {
+				fmt.Printf("haegressgatewaypolicy/%s: %s\n", usage.Policy, progress)
+				reported[usage.Policy] = progress
+			}
+		}
+		for policy, progress := range reported {
+			if !pending[policy] && progress != "moved" {
+				fmt.Printf("haegressgatewaypolicy/%s: moved\n", policy)
+				reported[policy] = "moved"
+			}
+		}
+		if len(usages) == 0 {
+			fmt.Printf("node/%s evacuated\n", nodeName)
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("node/%s not evacuated after %s", nodeName, *timeout)
+		case <-ticker.C:
+		}
+	}
+}
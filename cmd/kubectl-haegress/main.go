@@ -0,0 +1,133 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// kubectl-haegress is a command line companion of the operator, it can be used as a
+// kubectl plugin when installed in the PATH
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	scheme = runtime.NewScheme()
+
+	// egressNamespace is the namespace of the services of the policies without the
+	// namespace annotation, it must match the operator configuration
+	egressNamespace string
+)
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(ciliumv2.AddToScheme(scheme))
+	utilruntime.Must(haegressv2.AddToScheme(scheme))
+}
+
+// command is a subcommand, it gets its own arguments. Local commands run without a cluster
+// and get a nil client.
+type command struct {
+	name        string
+	description string
+	run         func(ctx context.Context, c client.Client, args []string) error
+	local       bool
+}
+
+var commands = []command{
+	{"status", "Show the egress IPs, exit nodes, conditions and last failovers of the policies", status, false},
+	{"describe", "Show a policy with the Services and CiliumEgressGatewayPolicies it owns", describe, false},
+	{"pods", "List the pods currently matched by a policy", pods, false},
+	{"move", "Move the egress IPs of a policy to a node and wait for the move to complete", move, false},
+	{"render", "Print the objects the operator would generate for policy manifests, without a cluster", render, true},
+	{"evacuate", "Move every egress VIP off a node and wait for the evacuation to complete", evacuate, false},
+	{"doctor", "Check the Cilium, kube-vip, CRD and RBAC prerequisites of the operator", doctor, false},
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: kubectl haegress [--kubeconfig FILE] [--egress-default-namespace NAMESPACE] COMMAND [ARGS]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %-10s %s\n", cmd.name, cmd.description)
+	}
+}
+
+func main() {
+	flag.StringVar(&egressNamespace, "egress-default-namespace", "egress-system", "The namespace where the operator creates the services if no namespaces were specified")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != flag.Arg(0) {
+			continue
+		}
+		if cmd.local {
+			if err := cmd.run(ctrl.SetupSignalHandler(), nil, flag.Args()[1:]); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+		config, err := ctrl.GetConfig()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		c, err := client.New(config, client.Options{Scheme: scheme})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := cmd.run(ctrl.SetupSignalHandler(), c, flag.Args()[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "error: unknown command %q\n", flag.Arg(0))
+	usage()
+	os.Exit(2)
+}
+
+// parseArgs parses the flags of a subcommand, also when they follow the positional
+// arguments like in kubectl
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// move sets the move-to annotation on the policy, the operator moves the egress IPs to the
+// node and removes the annotation once done, and reports the outcome of the Moved condition
+func move(ctx context.Context, c client.Client, args []string) error {
+	fs := flag.NewFlagSet("move", flag.ContinueOnError)
+	wait := fs.Bool("wait", true, "Wait until the operator has completed the move")
+	timeout := fs.Duration("timeout", 5*time.Minute, "How long to wait for the move")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: move POLICY NODE [--wait] [--timeout DURATION]\n")
+		fs.PrintDefaults()
+	}
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		fs.Usage()
+		return fmt.Errorf("a policy and a node name are required")
+	}
+	policyName, nodeName := positional[0], positional[1]
+
+	policy := &haegressv2.HAEgressGatewayPolicy{}
+	if err := c.Get(ctx, types.NamespacedName{Name: policyName}, policy); err != nil {
+		return err
+	}
+	// The operator validates the target too, checking it here gives an immediate answer
+	if err := haegressiputil.ValidateMoveTarget(ctx, c, policy, nodeName); err != nil {
+		return err
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{haegressip.MoveToAnnotation: nodeName},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if err := c.Patch(ctx, policy, client.RawPatch(types.MergePatchType, patch)); err != nil {
+		return err
+	}
+	fmt.Printf("haegressgatewaypolicy/%s moving to node/%s\n", policyName, nodeName)
+	if !*wait {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("haegressgatewaypolicy/%s not moved after %s", policyName, *timeout)
+		case <-ticker.C:
+		}
+
+		if err := c.Get(ctx, types.NamespacedName{Name: policyName}, policy); err != nil {
+			return err
+		}
+		// The operator removes the annotation when the request is completed
+		if _, pending := policy.Annotations[haegressip.MoveToAnnotation]; pending {
+			continue
+		}
+		condition := meta.FindStatusCondition(policy.Status.Conditions, haegressip.ConditionMoved)
+		if condition == nil {
+			return fmt.Errorf("haegressgatewaypolicy/%s move request removed without an outcome", policyName)
+		}
+		if condition.Status != metav1.ConditionTrue {
+			return fmt.Errorf("haegressgatewaypolicy/%s %s: %s", policyName, condition.Reason, condition.Message)
+		}
+		fmt.Printf("haegressgatewaypolicy/%s moved to node/%s\n", policyName, nodeName)
+		return nil
+	}
+}
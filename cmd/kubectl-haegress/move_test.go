@@ -0,0 +1,78 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestMove(t *testing.T) {
+	node := func(name string, ready corev1.ConditionStatus, unschedulable bool) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       corev1.NodeSpec{Unschedulable: unschedulable},
+			Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready}}},
+		}
+	}
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{name: "ready node", args: []string{"payments", "node-a", "--wait=false"}},
+		{name: "node not ready", args: []string{"payments", "node-b", "--wait=false"}, wantErr: true},
+		{name: "cordoned node", args: []string{"payments", "node-c", "--wait=false"}, wantErr: true},
+		{name: "unknown node", args: []string{"payments", "node-d", "--wait=false"}, wantErr: true},
+		{name: "unknown policy", args: []string{"billing", "node-a", "--wait=false"}, wantErr: true},
+		{name: "missing node", args: []string{"payments"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+				&haegressv2.HAEgressGatewayPolicy{ObjectMeta: metav1.ObjectMeta{Name: "payments"}},
+				node("node-a", corev1.ConditionTrue, false),
+				node("node-b", corev1.ConditionFalse, false),
+				node("node-c", corev1.ConditionTrue, true),
+			).Build()
+
+			err := move(context.Background(), c, tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("move() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			policy := &haegressv2.HAEgressGatewayPolicy{}
+			if err := c.Get(context.Background(), types.NamespacedName{Name: "payments"}, policy); err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			// A refused move does not reach the operator
+			want := ""
+			if !tt.wantErr {
+				want = tt.args[1]
+			}
+			if got := policy.Annotations[haegressip.MoveToAnnotation]; got != want {
+				t.Errorf("move-to annotation = %q, want %q", got, want)
+			}
+		})
+	}
+}
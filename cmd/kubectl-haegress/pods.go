@@ -0,0 +1,79 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// pods lists the pods matched by the policy selectors, evaluated like the operator and
+// Cilium do, with the shard they are bucketed in
+func pods(ctx context.Context, c client.Client, args []string) error {
+	fs := flag.NewFlagSet("pods", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: pods POLICY\n")
+		fs.PrintDefaults()
+	}
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return fmt.Errorf("a policy name is required")
+	}
+
+	policy := &haegressv2.HAEgressGatewayPolicy{}
+	if err := c.Get(ctx, types.NamespacedName{Name: positional[0]}, policy); err != nil {
+		return err
+	}
+	podList := &corev1.PodList{}
+	if err := c.List(ctx, podList); err != nil {
+		return err
+	}
+	namespaces, err := haegressiputil.NamespaceLabels(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	replicas := haegressiputil.PolicyReplicas(policy)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tNAME\tNODE\tSHARD")
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if !haegressiputil.PodSelected(policy.Spec.CiliumEgressGatewayPolicySpec, pod, namespaces[pod.Namespace]) {
+			continue
+		}
+		shard := "0"
+		if replicas > 1 {
+			shard = strconv.Itoa(haegressiputil.PodShard(pod.Namespace, pod.Name, replicas))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", pod.Namespace, pod.Name, orNone(pod.Spec.NodeName), shard)
+	}
+	return w.Flush()
+}
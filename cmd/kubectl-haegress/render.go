@@ -0,0 +1,131 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/yaml"
+)
+
+// render reads HAEgressGatewayPolicy manifests and prints the CiliumEgressGatewayPolicies and
+// Services the operator would generate for them, with the same generation code. The other
+// documents are ignored, so that whole GitOps directories can be rendered.
+func render(_ context.Context, _ client.Client, args []string) error {
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+	loadBalancerClass := fs.String("load-balancer-class", "kube-vip.io/kube-vip-class", "The LoadBalancer class used by the operator")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: render FILE... [--load-balancer-class CLASS], use - to read the standard input\n")
+		fs.PrintDefaults()
+	}
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		fs.Usage()
+		return fmt.Errorf("at least a file is required")
+	}
+	options := haegressiputil.RenderOptions{
+		EgressNamespace:   egressNamespace,
+		LoadBalancerClass: *loadBalancerClass,
+	}
+
+	policies := []*haegressv2.HAEgressGatewayPolicy{}
+	for _, name := range positional {
+		read, err := readPolicies(name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		policies = append(policies, read...)
+	}
+
+	first := true
+	for _, policy := range policies {
+		objects, err := haegressiputil.RenderPolicy(policy, options, scheme)
+		if err != nil {
+			return fmt.Errorf("haegressgatewaypolicy/%s: %w", policy.Name, err)
+		}
+		for _, obj := range objects {
+			gvk, err := apiutil.GVKForObject(obj, scheme)
+			if err != nil {
+				return err
+			}
+			obj.GetObjectKind().SetGroupVersionKind(gvk)
+			data, err := yaml.Marshal(obj)
+			if err != nil {
+				return err
+			}
+			if !first {
+				fmt.Println("---")
+			}
+			first = false
+			fmt.Print(string(data))
+		}
+	}
+	return nil
+}
+
+// readPolicies returns the HAEgressGatewayPolicies of a multi-document YAML or JSON file
+func readPolicies(name string) ([]*haegressv2.HAEgressGatewayPolicy, error) {
+	var reader io.Reader = os.Stdin
+	if name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	policies := []*haegressv2.HAEgressGatewayPolicy{}
+	decoder := utilyaml.NewYAMLOrJSONDecoder(reader, 4096)
+	for {
+		document := map[string]interface{}{}
+		if err := decoder.Decode(&document); err != nil {
+			if errors.Is(err, io.EOF) {
+				return policies, nil
+			}
+			return nil, err
+		}
+		obj := &unstructured.Unstructured{Object: document}
+		gvk := obj.GroupVersionKind()
+		if gvk.Group != haegressv2.GroupVersion.Group || gvk.Kind != "HAEgressGatewayPolicy" {
+			continue
+		}
+		if gvk.Version != haegressv2.GroupVersion.Version {
+			return nil, fmt.Errorf("haegressgatewaypolicy/%s: unsupported version %s", obj.GetName(), gvk.Version)
+		}
+		policy := &haegressv2.HAEgressGatewayPolicy{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(document, policy); err != nil {
+			return nil, fmt.Errorf("haegressgatewaypolicy/%s: %w", obj.GetName(), err)
+		}
+		policies = append(policies, policy)
+	}
+}
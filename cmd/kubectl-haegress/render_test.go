@@ -0,0 +1,123 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const renderPolicies = `apiVersion: v1
+kind: Namespace
+metadata:
+  name: egress-system
+---
+apiVersion: cilium.angeloxx.ch/v2
+kind: HAEgressGatewayPolicy
+metadata:
+  name: payments
+spec:
+  destinationCIDRs:
+  - 0.0.0.0/0
+---
+apiVersion: cilium.io/v2
+kind: CiliumEgressGatewayPolicy
+metadata:
+  name: unmanaged
+---
+{"apiVersion": "cilium.angeloxx.ch/v2", "kind": "HAEgressGatewayPolicy", "metadata": {"name": "billing"}}
+`
+
+func TestReadPolicies(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		want     []string
+		wantErr  bool
+	}{
+		{name: "policies among other documents", manifest: renderPolicies, want: []string{"payments", "billing"}},
+		{name: "no policy", manifest: "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: egress-system\n", want: []string{}},
+		{name: "unsupported version", manifest: "apiVersion: cilium.angeloxx.ch/v1\nkind: HAEgressGatewayPolicy\nmetadata:\n  name: payments\n", wantErr: true},
+		{name: "invalid document", manifest: "apiVersion: cilium.angeloxx.ch/v2\nkind: HAEgressGatewayPolicy\nspec: [\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "policies.yaml")
+			if err := os.WriteFile(name, []byte(tt.manifest), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			policies, err := readPolicies(name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readPolicies() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := []string{}
+			for _, policy := range policies {
+				got = append(got, policy.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readPolicies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderPolicies(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "policies.yaml")
+	if err := os.WriteFile(name, []byte(renderPolicies), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	policies, err := readPolicies(name)
+	if err != nil {
+		t.Fatalf("readPolicies() error = %v", err)
+	}
+
+	// Every policy gets its Service and its CiliumEgressGatewayPolicy, owned by the policy
+	for _, policy := range policies {
+		objects, err := haegressiputil.RenderPolicy(policy, haegressiputil.RenderOptions{
+			EgressNamespace:   "egress-system",
+			LoadBalancerClass: "kube-vip.io/kube-vip-class",
+		}, scheme)
+		if err != nil {
+			t.Fatalf("RenderPolicy(%s) error = %v", policy.Name, err)
+		}
+		services, ciliumEgressGatewayPolicies := 0, 0
+		for _, obj := range objects {
+			switch obj.(type) {
+			case *corev1.Service:
+				services++
+			case *ciliumv2.CiliumEgressGatewayPolicy:
+				ciliumEgressGatewayPolicies++
+			}
+			if owner := obj.GetOwnerReferences(); len(owner) != 1 || owner[0].Name != policy.Name {
+				t.Errorf("RenderPolicy(%s) %T %s owners = %v", policy.Name, obj, obj.GetName(), owner)
+			}
+		}
+		if services != 1 || ciliumEgressGatewayPolicies != 1 {
+			t.Errorf("RenderPolicy(%s) = %d Services and %d CiliumEgressGatewayPolicies, want 1 and 1",
+				policy.Name, services, ciliumEgressGatewayPolicies)
+		}
+	}
+}
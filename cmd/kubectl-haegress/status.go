@@ -0,0 +1,230 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// failoverReasons are the reasons of the events recorded when an egress IP changes node
+var failoverReasons = map[string]bool{
+	haegressip.EventEgressUpdateReason: true,
+	haegressip.EventRebalanceReason:    true,
+	haegressip.EventFailbackReason:     true,
+	haegressip.EventAffinityReason:     true,
+	haegressip.EventEvacuationReason:   true,
+	haegressip.EventMoveReason:         true,
+}
+
+// status prints a summary of every policy, or the details of the named ones
+func status(ctx context.Context, c client.Client, args []string) error {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	failovers := fs.Int("failovers", 5, "Number of recent failovers to show for every named policy")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: status [POLICY...] [--failovers N]\n")
+		fs.PrintDefaults()
+	}
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(positional) == 0 {
+		policies := &haegressv2.HAEgressGatewayPolicyList{}
+		if err := c.List(ctx, policies); err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tIP ADDRESSES\tEXIT NODE\tZONE\tREPLICAS\tCONDITIONS")
+		for i := range policies.Items {
+			policy := &policies.Items[i]
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", policy.Name, orNone(strings.Join(policyIPAddresses(policy), ",")),
+				orNone(policy.Status.ExitNode), orNone(policy.Status.ExitZone), haegressiputil.PolicyReplicas(policy),
+				orNone(activeConditions(policy)))
+		}
+		return w.Flush()
+	}
+
+	for n, name := range positional {
+		policy := &haegressv2.HAEgressGatewayPolicy{}
+		if err := c.Get(ctx, types.NamespacedName{Name: name}, policy); err != nil {
+			return err
+		}
+		if n > 0 {
+			fmt.Println()
+		}
+		if err := printStatus(ctx, c, policy, *failovers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printStatus(ctx context.Context, c client.Client, policy *haegressv2.HAEgressGatewayPolicy, failovers int) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", policy.Name)
+	fmt.Fprintf(w, "IP Addresses:\t%s\n", orNone(strings.Join(policyIPAddresses(policy), ", ")))
+	fmt.Fprintf(w, "Exit Node:\t%s\n", orNone(policy.Status.ExitNode))
+	fmt.Fprintf(w, "Exit Zone:\t%s\n", orNone(policy.Status.ExitZone))
+	fmt.Fprintf(w, "Last Modified:\t%s\n", policy.Status.LastModifiedTime.Format(time.RFC3339))
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(policy.Status.Shards) > 0 {
+		fmt.Println("Shards:")
+		w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "  SHARD\tSERVICE\tIP ADDRESS\tEXIT NODE")
+		for _, shard := range policy.Status.Shards {
+			fmt.Fprintf(w, "  %d\t%s\t%s\t%s\n", shard.Shard, shard.Service, orNone(shard.IPAddress), orNone(shard.ExitNode))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("Conditions:")
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "  TYPE\tSTATUS\tREASON\tLAST TRANSITION\tMESSAGE")
+	for _, condition := range policy.Status.Conditions {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\n", condition.Type, condition.Status, condition.Reason,
+			condition.LastTransitionTime.Format(time.RFC3339), condition.Message)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	events, err := policyFailovers(ctx, c, policy)
+	if err != nil {
+		return err
+	}
+	if len(events) > failovers {
+		events = events[:failovers]
+	}
+	fmt.Println("Last Failovers:")
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "  TIME\tREASON\tOBJECT\tMESSAGE")
+	for _, event := range events {
+		fmt.Fprintf(w, "  %s\t%s\t%s/%s\t%s\n", eventTime(&event).Format(time.RFC3339), event.Reason,
+			strings.ToLower(event.InvolvedObject.Kind), event.InvolvedObject.Name, event.Message)
+	}
+	return w.Flush()
+}
+
+// policyFailovers returns the events recorded when the egress IPs of the policy changed node,
+// the most recent first
+func policyFailovers(ctx context.Context, c client.Client, policy *haegressv2.HAEgressGatewayPolicy) ([]corev1.Event, error) {
+	objects := []client.MatchingFields{{"involvedObject.kind": "HAEgressGatewayPolicy", "involvedObject.name": policy.Name}}
+	ciliumEgressGatewayPolicies, err := haegressiputil.PolicyCiliumEgressGatewayPolicies(ctx, c, policy)
+	if err != nil {
+		return nil, err
+	}
+	for _, ciliumEgressGatewayPolicy := range ciliumEgressGatewayPolicies {
+		objects = append(objects, client.MatchingFields{
+			"involvedObject.kind": "CiliumEgressGatewayPolicy",
+			"involvedObject.name": ciliumEgressGatewayPolicy.Name,
+		})
+	}
+
+	failovers := []corev1.Event{}
+	for _, fields := range objects {
+		events := &corev1.EventList{}
+		if err := c.List(ctx, events, fields); err != nil {
+			return nil, err
+		}
+		for _, event := range events.Items {
+			if failoverReasons[event.Reason] {
+				failovers = append(failovers, event)
+			}
+		}
+	}
+	sort.SliceStable(failovers, func(i, j int) bool {
+		return eventTime(&failovers[i]).After(eventTime(&failovers[j]))
+	})
+	return failovers, nil
+}
+
+// eventTime returns the last time the event has been observed
+func eventTime(event *corev1.Event) time.Time {
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	}
+	return event.CreationTimestamp.Time
+}
+
+// policyIPAddresses returns the egress IPs of every shard of the policy
+func policyIPAddresses(policy *haegressv2.HAEgressGatewayPolicy) []string {
+	if len(policy.Status.Shards) == 0 {
+		if len(policy.Status.IPAddresses) > 0 {
+			return policy.Status.IPAddresses
+		}
+		if policy.Status.IPAddress != "" {
+			return []string{policy.Status.IPAddress}
+		}
+		return []string{}
+	}
+	addresses := []string{}
+	for _, shard := range policy.Status.Shards {
+		if shard.IPAddress != "" {
+			addresses = append(addresses, shard.IPAddress)
+		}
+	}
+	return addresses
+}
+
+// activeConditions returns the conditions of the policy reporting a problem or an operation
+// in progress
+func activeConditions(policy *haegressv2.HAEgressGatewayPolicy) string {
+	active := []string{}
+	for _, condition := range policy.Status.Conditions {
+		switch condition.Type {
+		case haegressip.ConditionPreferredNode, haegressip.ConditionMoved:
+			if condition.Status != "True" {
+				active = append(active, condition.Type+"="+condition.Reason)
+			}
+		default:
+			if condition.Status != "False" {
+				active = append(active, condition.Type+"="+condition.Reason)
+			}
+		}
+	}
+	return strings.Join(active, ",")
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "<none>"
+	}
+	return value
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPolicyIPAddresses(t *testing.T) {
+	tests := []struct {
+		name   string
+		status haegressv2.HAEgressGatewayPolicyStatus
+		want   []string
+	}{
+		{name: "not assigned", want: []string{}},
+		{name: "single address", status: haegressv2.HAEgressGatewayPolicyStatus{IPAddress: "10.0.0.1"}, want: []string{"10.0.0.1"}},
+		{
+			name: "shards",
+			status: haegressv2.HAEgressGatewayPolicyStatus{IPAddress: "10.0.0.1", Shards: []haegressv2.HAEgressGatewayPolicyShardStatus{
+				{Shard: 0, IPAddress: "10.0.0.1"}, {Shard: 1}, {Shard: 2, IPAddress: "10.0.0.3"},
+			}},
+			want: []string{"10.0.0.1", "10.0.0.3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &haegressv2.HAEgressGatewayPolicy{Status: tt.status}
+			if got := policyIPAddresses(policy); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("policyIPAddresses() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestActiveConditions(t *testing.T) {
+	condition := func(conditionType string, status metav1.ConditionStatus, reason string) metav1.Condition {
+		return metav1.Condition{Type: conditionType, Status: status, Reason: reason}
+	}
+	tests := []struct {
+		name       string
+		conditions []metav1.Condition
+		want       string
+	}{
+		{name: "no conditions", want: ""},
+		{
+			name: "healthy",
+			conditions: []metav1.Condition{
+				condition(haegressip.ConditionIPConflict, metav1.ConditionFalse, "NoConflict"),
+				condition(haegressip.ConditionPreferredNode, metav1.ConditionTrue, "OnPreferredNode"),
+				condition(haegressip.ConditionMoved, metav1.ConditionTrue, "Succeeded"),
+			},
+			want: "",
+		},
+		{
+			name: "problems",
+			conditions: []metav1.Condition{
+				condition(haegressip.ConditionIPConflict, metav1.ConditionTrue, "Conflict"),
+				condition(haegressip.ConditionPreferredNode, metav1.ConditionFalse, "WaitingForFailback"),
+				condition(haegressip.ConditionMoved, metav1.ConditionUnknown, "Moving"),
+			},
+			want: "IPConflict=Conflict,PreferredNode=WaitingForFailback,Moved=Moving",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &haegressv2.HAEgressGatewayPolicy{Status: haegressv2.HAEgressGatewayPolicyStatus{Conditions: tt.conditions}}
+			if got := activeConditions(policy); got != tt.want {
+				t.Errorf("activeConditions() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
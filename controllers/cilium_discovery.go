@@ -0,0 +1,164 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	"github.com/go-logr/logr"
+	"k8s.io/client-go/discovery"
+	"net/http"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CiliumDiscovery tells whether the CiliumEgressGatewayPolicy CRD is served at the version
+// used by the operator. Until it is, the operator runs in a degraded mode: the policies get
+// the CiliumUnavailable condition, the watches on the CiliumEgressGatewayPolicies are not
+// started and the readiness check fails. The CRD is looked up periodically and the
+// registered callbacks, starting the watches, run once it appears.
+type CiliumDiscovery struct {
+	Discovery discovery.DiscoveryInterface
+	Log       logr.Logger
+	// Interval between two lookups of the CRD while it is not served
+	Interval time.Duration
+
+	mu        sync.Mutex
+	available bool
+	message   string
+	callbacks []func() error
+}
+
+// Discover looks up the CRD once, it runs the callbacks when the CRD becomes available
+func (d *CiliumDiscovery) Discover() error {
+	versions, err := haegressiputil.CiliumEgressGatewayPolicyVersions(d.Discovery)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	wanted := ciliumv2.SchemeGroupVersion.Version
+	served := false
+	for _, version := range versions {
+		served = served || version == wanted
+	}
+	switch {
+	case served:
+		d.message = fmt.Sprintf("CiliumEgressGatewayPolicy is served as %s/%s", haegressiputil.CiliumGroup, strings.Join(versions, ", "))
+	case len(versions) > 0:
+		d.message = fmt.Sprintf("CiliumEgressGatewayPolicy is served as %s/%s, %s is required",
+			haegressiputil.CiliumGroup, strings.Join(versions, ", "), wanted)
+	default:
+		d.message = "The CiliumEgressGatewayPolicy CRD is not installed, check that the Cilium egress gateway is enabled"
+	}
+	if !served || d.available {
+		return nil
+	}
+
+	d.Log.Info("CiliumEgressGatewayPolicy CRD available", "versions", versions)
+	for _, callback := range d.callbacks {
+		if err := callback(); err != nil {
+			return err
+		}
+	}
+	d.callbacks = nil
+	d.available = true
+	return nil
+}
+
+// Available returns true if the CiliumEgressGatewayPolicies are served, a nil discovery
+// assumes they are
+func (d *CiliumDiscovery) Available() bool {
+	if d == nil {
+		return true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.available
+}
+
+// Message describes the outcome of the last lookup
+func (d *CiliumDiscovery) Message() string {
+	if d == nil {
+		return ""
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.message
+}
+
+// OnAvailable registers a callback run when the CRD becomes available, or immediately if
+// it already is
+func (d *CiliumDiscovery) OnAvailable(callback func() error) error {
+	if d == nil {
+		return callback()
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.available {
+		return callback()
+	}
+	d.callbacks = append(d.callbacks, callback)
+	return nil
+}
+
+// Start looks up the CRD until it is available, it implements manager.Runnable
+func (d *CiliumDiscovery) Start(ctx context.Context) error {
+	if d.Available() {
+		return nil
+	}
+	d.Log.Info("CiliumEgressGatewayPolicy CRD not available, running in degraded mode", "reason", d.Message())
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := d.Discover(); err != nil {
+				d.Log.Error(err, "unable to discover the CiliumEgressGatewayPolicy CRD")
+				continue
+			}
+			if d.Available() {
+				return nil
+			}
+		}
+	}
+}
+
+// NeedLeaderElection makes the discovery run on every replica, the readiness depends on it
+func (d *CiliumDiscovery) NeedLeaderElection() bool {
+	return false
+}
+
+// ReadyzCheck fails while the CiliumEgressGatewayPolicies are not served
+func (d *CiliumDiscovery) ReadyzCheck(_ *http.Request) error {
+	if !d.Available() {
+		return fmt.Errorf("%s", d.Message())
+	}
+	return nil
+}
+
+// SetupWithManager adds the discovery to the Manager.
+func (d *CiliumDiscovery) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(d)
+}
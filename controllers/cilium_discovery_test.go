@@ -0,0 +1,110 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	clienttesting "k8s.io/client-go/testing"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// ciliumResources returns the discovery of the cilium.io versions serving the
+// CiliumEgressGatewayPolicies
+func ciliumResources(versions ...string) []*metav1.APIResourceList {
+	resources := []*metav1.APIResourceList{{
+		GroupVersion: "v1",
+		APIResources: []metav1.APIResource{{Name: "services", Kind: "Service", Namespaced: true}},
+	}}
+	for _, version := range versions {
+		resources = append(resources, &metav1.APIResourceList{
+			GroupVersion: "cilium.io/" + version,
+			APIResources: []metav1.APIResource{{Name: "ciliumegressgatewaypolicies", Kind: "CiliumEgressGatewayPolicy"}},
+		})
+	}
+	return resources
+}
+
+func TestCiliumDiscoveryDiscover(t *testing.T) {
+	tests := []struct {
+		name          string
+		versions      []string
+		wantAvailable bool
+		wantMessage   string
+	}{
+		{name: "not installed", wantMessage: "CRD is not installed"},
+		{name: "other version", versions: []string{"v2alpha1"}, wantMessage: "served as cilium.io/v2alpha1, v2 is required"},
+		{name: "served", versions: []string{"v2", "v2alpha1"}, wantAvailable: true, wantMessage: "served as cilium.io/v2, v2alpha1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &CiliumDiscovery{
+				Discovery: &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{Resources: ciliumResources(tt.versions...)}},
+				Log:       ctrl.Log,
+			}
+			if err := d.Discover(); err != nil {
+				t.Fatalf("Discover() error = %v", err)
+			}
+			if got := d.Available(); got != tt.wantAvailable {
+				t.Errorf("Available() = %v, want %v", got, tt.wantAvailable)
+			}
+			if got := d.Message(); !strings.Contains(got, tt.wantMessage) {
+				t.Errorf("Message() = %q, want it to contain %q", got, tt.wantMessage)
+			}
+			if err := d.ReadyzCheck(nil); (err != nil) == tt.wantAvailable {
+				t.Errorf("ReadyzCheck() error = %v, want an error %v", err, !tt.wantAvailable)
+			}
+		})
+	}
+}
+
+func TestCiliumDiscoveryOnAvailable(t *testing.T) {
+	fake := &clienttesting.Fake{Resources: ciliumResources()}
+	d := &CiliumDiscovery{Discovery: &fakediscovery.FakeDiscovery{Fake: fake}, Log: ctrl.Log}
+	started := 0
+	if err := d.OnAvailable(func() error { started++; return nil }); err != nil {
+		t.Fatalf("OnAvailable() error = %v", err)
+	}
+
+	// The watches start once the CRD appears and are not started again
+	for _, versions := range [][]string{nil, {"v2"}, {"v2"}} {
+		fake.Resources = ciliumResources(versions...)
+		if err := d.Discover(); err != nil {
+			t.Fatalf("Discover() error = %v", err)
+		}
+	}
+	if started != 1 {
+		t.Errorf("callback run %d times, want 1", started)
+	}
+	if err := d.OnAvailable(func() error { started++; return nil }); err != nil || started != 2 {
+		t.Errorf("OnAvailable() on an available CRD ran the callback %d times, error = %v, want 2", started, err)
+	}
+}
+
+func TestCiliumDiscoveryNil(t *testing.T) {
+	var d *CiliumDiscovery
+	if !d.Available() {
+		t.Errorf("Available() = false, a nil discovery assumes the CRD is served")
+	}
+	started := false
+	if err := d.OnAvailable(func() error { started = true; return nil }); err != nil || !started {
+		t.Errorf("OnAvailable() did not run the callback, error = %v", err)
+	}
+}
@@ -0,0 +1,271 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"time"
+)
+
+// Failback moves the VIPs of the policies declaring preferred nodes, or a preferred zone,
+// back to a preferred node once it has been ready for the stable-for duration and, when
+// configured, inside the failback window. The outcome is recorded in the PreferredNode
+// condition of the policy.
+type Failback struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+	Provider provider.Provider
+
+	// EgressNamespace is the default namespace of the services
+	EgressNamespace string
+	// Interval between two evaluations of the preferred nodes
+	Interval time.Duration
+
+	// pending are the failbacks requested and not completed yet, by service
+	pending map[types.NamespacedName]pendingFailback
+}
+
+// pendingFailback is a VIP moved back to a preferred node, the CiliumEgressGatewayPolicy is
+// expected to follow it before the deadline
+type pendingFailback struct {
+	target   string
+	deadline time.Time
+}
+
+// failbackState is the outcome of the evaluation of a service
+type failbackState struct {
+	status  metav1.ConditionStatus
+	reason  string
+	message string
+}
+
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch;update
+
+// Start runs the failback loop until the context is cancelled, it implements manager.Runnable
+func (r *Failback) Start(ctx context.Context) error {
+	r.Log.Info("Starting egress VIP failback", "interval", r.Interval)
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := r.failback(ctx); err != nil {
+				r.Log.Error(err, "unable to fail back the egress VIPs")
+			}
+		}
+	}
+}
+
+// NeedLeaderElection makes the failback run only on the leader
+func (r *Failback) NeedLeaderElection() bool {
+	return true
+}
+
+func (r *Failback) failback(ctx context.Context) error {
+	policies := &haegressv2.HAEgressGatewayPolicyList{}
+	if err := r.List(ctx, policies); err != nil {
+		return err
+	}
+	if r.pending == nil {
+		r.pending = map[types.NamespacedName]pendingFailback{}
+	}
+	// The failbacks of the services not evaluated anymore are forgotten
+	evaluated := map[types.NamespacedName]bool{}
+	defer func() {
+		for service := range r.pending {
+			if !evaluated[service] {
+				delete(r.pending, service)
+			}
+		}
+	}()
+	for i := range policies.Items {
+		policy := &policies.Items[i]
+		if !haegressiputil.PolicyHasPreference(policy) {
+			// The preference has been removed
+			if meta.RemoveStatusCondition(&policy.Status.Conditions, haegressip.ConditionPreferredNode) {
+				if err := r.Status().Update(ctx, policy); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		// A move requested with the move-to annotation is in progress, or held
+		if policy.Annotations[haegressip.MoveToAnnotation] != "" {
+			continue
+		}
+		if haegressiputil.PolicyHeld(policy, time.Now()) {
+			if _, err := haegressiputil.UpdatePolicyCondition(ctx, r.Client, policy, haegressip.ConditionPreferredNode,
+				metav1.ConditionFalse, "HeldAfterMove", fmt.Sprintf("The VIPs have been moved on request and are held until %s",
+					policy.Annotations[haegressip.HoldUntilAnnotation])); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := r.failbackPolicy(ctx, policy, evaluated); err != nil {
+			r.Log.Error(err, "unable to fail back the HAEgressGatewayPolicy", "policy", policy.Name)
+		}
+	}
+	return nil
+}
+
+// failbackPolicy moves the VIPs of the policy back to the preferred nodes, a VIP is moved once
+// and followed until the CiliumEgressGatewayPolicy holds it or VIPMoveTimeout expires. The
+// services evaluated are recorded in evaluated.
+func (r *Failback) failbackPolicy(ctx context.Context, policy *haegressv2.HAEgressGatewayPolicy, evaluated map[types.NamespacedName]bool) error {
+	window, err := haegressiputil.PolicyFailbackWindow(policy)
+	if err != nil {
+		_, err = haegressiputil.UpdatePolicyCondition(ctx, r.Client, policy, haegressip.ConditionPreferredNode,
+			metav1.ConditionFalse, "InvalidFailbackWindow", err.Error())
+		return err
+	}
+	stableFor := haegressiputil.PolicyFailbackStableFor(policy)
+
+	services := &corev1.ServiceList{}
+	if err := r.List(ctx, services,
+		client.InNamespace(haegressiputil.ServiceNamespace(policy, r.EgressNamespace)),
+		client.MatchingLabels{haegressip.HAEgressGatewayPolicyName: policy.Name}); err != nil {
+		return err
+	}
+	nodes, err := haegressiputil.AllowedNodes(ctx, r, policy)
+	if err != nil {
+		return err
+	}
+
+	// Preferred nodes are tried in the annotation order, then by name
+	preferred := []corev1.Node{}
+	preferredNodes := haegressiputil.PolicyPreferredNodes(policy)
+	order := map[string]int{}
+	for i, name := range preferredNodes {
+		order[name] = i
+	}
+	for _, node := range nodes {
+		if haegressiputil.NodePreferred(policy, &node) {
+			preferred = append(preferred, node)
+		}
+	}
+	sort.SliceStable(preferred, func(i, j int) bool {
+		oi, ok := order[preferred[i].Name]
+		if !ok {
+			oi = len(preferredNodes)
+		}
+		oj, ok := order[preferred[j].Name]
+		if !ok {
+			oj = len(preferredNodes)
+		}
+		if oi != oj {
+			return oi < oj
+		}
+		return preferred[i].Name < preferred[j].Name
+	})
+
+	now := time.Now()
+	state := failbackState{metav1.ConditionTrue, "OnPreferredNode", "The VIPs are held by preferred nodes"}
+	for i := range services.Items {
+		service := &services.Items[i]
+		key := types.NamespacedName{Namespace: service.Namespace, Name: service.Name}
+		current := r.Provider.CurrentNode(service)
+		if current == "" {
+			continue
+		}
+		if r.onPreferredNode(policy, current, nodes) {
+			continue
+		}
+		evaluated[key] = true
+
+		target := ""
+		for _, node := range preferred {
+			if haegressiputil.NodeStableFor(&node, stableFor, now) {
+				target = node.Name
+				break
+			}
+		}
+		switch {
+		case len(preferred) == 0:
+			state = failbackState{metav1.ConditionFalse, "PreferredNodeUnavailable",
+				fmt.Sprintf("Service %s/%s is held by %s, no preferred node is ready", service.Namespace, service.Name, current)}
+		case target == "":
+			state = failbackState{metav1.ConditionFalse, "WaitingForStableNode",
+				fmt.Sprintf("Service %s/%s is held by %s, waiting for a preferred node to be ready for %s",
+					service.Namespace, service.Name, current, stableFor)}
+		case !window.Contains(now):
+			state = failbackState{metav1.ConditionFalse, "OutsideFailbackWindow",
+				fmt.Sprintf("Service %s/%s is held by %s, failback to %s is allowed in the %s window",
+					service.Namespace, service.Name, current, target, window.String())}
+		case r.pending[key].target == target && now.Before(r.pending[key].deadline):
+			// Already moved, waiting for the CiliumEgressGatewayPolicy to follow
+			state = failbackState{metav1.ConditionFalse, "FailingBack",
+				fmt.Sprintf("Service %s/%s is failing back from %s to %s", service.Namespace, service.Name, current, target)}
+		case r.pending[key].target == target:
+			// Timed out, the failback is retried at the next evaluation
+			delete(r.pending, key)
+			r.Recorder.Event(policy, corev1.EventTypeWarning, haegressip.EventFailbackReason,
+				fmt.Sprintf("Failback of the VIP of service %s/%s to %s has not completed within %s", service.Namespace, service.Name, target, haegressip.VIPMoveTimeout))
+			state = failbackState{metav1.ConditionFalse, "FailbackTimedOut",
+				fmt.Sprintf("Service %s/%s is held by %s, the failback to %s has not completed within %s",
+					service.Namespace, service.Name, current, target, haegressip.VIPMoveTimeout)}
+		default:
+			r.Log.Info("Failing back egress VIP to the preferred node", "service", key.String(),
+				"from", current, "to", target)
+			if err := r.Provider.MoveVIP(ctx, service, target); err != nil {
+				return err
+			}
+			r.pending[key] = pendingFailback{target: target, deadline: now.Add(haegressip.VIPMoveTimeout)}
+			r.Recorder.Event(policy, corev1.EventTypeNormal, haegressip.EventFailbackReason,
+				fmt.Sprintf("Failing back VIP of service %s/%s from %s to preferred node %s", service.Namespace, service.Name, current, target))
+			state = failbackState{metav1.ConditionFalse, "FailingBack",
+				fmt.Sprintf("Service %s/%s is failing back from %s to %s", service.Namespace, service.Name, current, target)}
+		}
+	}
+
+	_, err = haegressiputil.UpdatePolicyCondition(ctx, r.Client, policy, haegressip.ConditionPreferredNode,
+		state.status, state.reason, state.message)
+	return err
+}
+
+// onPreferredNode returns true if the current node is a preferred node, the node is looked
+// up among the eligible ones so that the zone can be evaluated
+func (r *Failback) onPreferredNode(policy *haegressv2.HAEgressGatewayPolicy, current string, nodes []corev1.Node) bool {
+	for _, node := range nodes {
+		if node.Name == current {
+			return haegressiputil.NodePreferred(policy, &node)
+		}
+	}
+	return false
+}
+
+// SetupWithManager adds the failback to the Manager.
+func (r *Failback) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(r)
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestFailback(t *testing.T) {
+	now := time.Now()
+	closedWindow := now.Add(2*time.Hour).Format("15:04") + "-" + now.Add(3*time.Hour).Format("15:04")
+	tests := []struct {
+		name string
+		// annotations are added to the policy preferring node-a
+		annotations map[string]string
+		// readySince is when node-a became ready, zero if it is not ready
+		readySince time.Time
+		// current is the node announcing the VIP
+		current    string
+		wantReason string
+		wantHolder string
+	}{
+		{name: "preferred node stable", readySince: now.Add(-time.Hour), current: "node-b", wantReason: "FailingBack", wantHolder: "node-a"},
+		{name: "on the preferred node", readySince: now.Add(-time.Hour), current: "node-a", wantReason: "OnPreferredNode", wantHolder: "node-a"},
+		{name: "preferred node not ready", current: "node-b", wantReason: "PreferredNodeUnavailable", wantHolder: "node-b"},
+		{name: "preferred node not stable yet", readySince: now.Add(-time.Minute), current: "node-b", wantReason: "WaitingForStableNode", wantHolder: "node-b"},
+		{
+			name:        "shorter stable-for",
+			annotations: map[string]string{haegressip.FailbackStableForAnnotation: "30s"},
+			readySince:  now.Add(-time.Minute), current: "node-b", wantReason: "FailingBack", wantHolder: "node-a",
+		},
+		{
+			name:        "outside the failback window",
+			annotations: map[string]string{haegressip.FailbackWindowAnnotation: closedWindow},
+			readySince:  now.Add(-time.Hour), current: "node-b", wantReason: "OutsideFailbackWindow", wantHolder: "node-b",
+		},
+		{
+			name:        "held after a move",
+			annotations: map[string]string{haegressip.HoldUntilAnnotation: now.Add(time.Hour).UTC().Format(time.RFC3339)},
+			readySince:  now.Add(-time.Hour), current: "node-b", wantReason: "HeldAfterMove", wantHolder: "node-b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			preferred := testNode("node-a", "zone-a")
+			preferred.Status.Conditions[0].LastTransitionTime = metav1.NewTime(tt.readySince)
+			if tt.readySince.IsZero() {
+				preferred.Status.Conditions[0].Status = corev1.ConditionFalse
+			}
+			policy := testPolicy("payments")
+			policy.Annotations[haegressip.PreferredNodesAnnotation] = "node-a"
+			for key, value := range tt.annotations {
+				policy.Annotations[key] = value
+			}
+			service := testService(policy, "10.0.0.1", tt.current)
+			c := newTestClient(preferred, testNode("node-b", "zone-b"), policy, service, testLease(service, tt.current))
+			recorder := record.NewFakeRecorder(10)
+			r := &Failback{Client: c, Log: ctrl.Log, Recorder: recorder, Provider: provider.NewKubeVIP(c), EgressNamespace: "egress-system"}
+
+			// The VIP is moved back once, the next evaluations wait for it to be followed
+			for pass := 0; pass < 2; pass++ {
+				if err := r.failback(context.Background()); err != nil {
+					t.Fatalf("failback() pass %d error = %v", pass, err)
+				}
+			}
+			current := &haegressv2.HAEgressGatewayPolicy{}
+			if err := c.Get(context.Background(), client.ObjectKeyFromObject(policy), current); err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			condition := meta.FindStatusCondition(current.Status.Conditions, haegressip.ConditionPreferredNode)
+			if condition == nil || condition.Reason != tt.wantReason {
+				t.Errorf("PreferredNode condition = %+v, want reason %s", condition, tt.wantReason)
+			}
+			lease := &coordinationv1.Lease{}
+			if err := c.Get(context.Background(), client.ObjectKeyFromObject(testLease(service, "")), lease); err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if got := *lease.Spec.HolderIdentity; got != tt.wantHolder {
+				t.Errorf("Lease held by %s, want %s", got, tt.wantHolder)
+			}
+			wantEvents := 0
+			if tt.wantReason == "FailingBack" {
+				wantEvents = 1
+			}
+			if got := len(recorder.Events); got != wantEvents {
+				t.Errorf("recorded %d failback events, want %d", got, wantEvents)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"github.com/angeloxx/cilium-haegress-operator/pkg/metrics"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	"sync"
+	"time"
+)
+
+// routineRank is the rank of the reconciles not following a VIP move, below every class
+const routineRank = -1
+
+// FailoverQueue prioritizes the reconciles following the VIP moves. The workqueues of the
+// controllers are FIFO, so when a node holding many VIPs fails the moves are recorded here as
+// soon as they are seen and the reconciles of a lower rank, the routine reconciles of the
+// policies first and then the moves of the lower priority classes, are postponed while more
+// urgent moves are pending, up to MaxDeferral. It also measures the recovery of the moves
+// and of the batches of moves, the failover storms.
+type FailoverQueue struct {
+	// MaxDeferral bounds how long a reconcile is postponed
+	MaxDeferral time.Duration
+	// RetryAfter is the delay of a postponed reconcile
+	RetryAfter time.Duration
+
+	mu         sync.Mutex
+	pending    map[string]pendingFailover
+	deferred   map[string]time.Time
+	stormStart time.Time
+	stormSize  int
+}
+
+// pendingFailover is a VIP move not followed yet
+type pendingFailover struct {
+	class haegressiputil.PriorityClass
+	since time.Time
+}
+
+// Moved records a VIP move to be followed by the reconcile of key
+func (q *FailoverQueue) Moved(key string, class haegressiputil.PriorityClass) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.pending == nil {
+		q.pending = map[string]pendingFailover{}
+	}
+	now := time.Now()
+	if len(q.pending) == 0 {
+		q.stormStart = now
+		q.stormSize = 0
+	}
+	if _, ok := q.pending[key]; ok {
+		return
+	}
+	q.pending[key] = pendingFailover{class: class, since: now}
+	q.stormSize++
+	metrics.FailoversPending.WithLabelValues(string(class)).Inc()
+}
+
+// Followed records that the reconcile of key has completed, the storm ends with the last
+// pending move
+func (q *FailoverQueue) Followed(key string) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.deferred, key)
+	failover, ok := q.pending[key]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	delete(q.pending, key)
+	metrics.FailoversPending.WithLabelValues(string(failover.class)).Dec()
+	metrics.FailoverRecoverySeconds.WithLabelValues(string(failover.class)).Observe(now.Sub(failover.since).Seconds())
+	if len(q.pending) == 0 {
+		metrics.FailoverStormSeconds.Observe(now.Sub(q.stormStart).Seconds())
+		metrics.FailoverStormSize.Observe(float64(q.stormSize))
+	}
+}
+
+// Forget drops the pending move of key without measuring its recovery, when the move will
+// not be followed: the service is gone, not owned any more or failing with a permanent error
+func (q *FailoverQueue) Forget(key string) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.deferred, key)
+	failover, ok := q.pending[key]
+	if !ok {
+		return
+	}
+	delete(q.pending, key)
+	metrics.FailoversPending.WithLabelValues(string(failover.class)).Dec()
+}
+
+// Pending returns true if a move of key is waiting to be followed
+func (q *FailoverQueue) Pending(key string) bool {
+	if q == nil {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, ok := q.pending[key]
+	return ok
+}
+
+// Defer returns how long the reconcile of key must be postponed, zero when it can run. A
+// reconcile following a move has the rank of its class, any other reconcile runs after the
+// moves.
+func (q *FailoverQueue) Defer(key string) time.Duration {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	rank := routineRank
+	if failover, ok := q.pending[key]; ok {
+		rank = failover.class.Rank()
+	}
+	urgent := false
+	for other, failover := range q.pending {
+		if other != key && failover.class.Rank() > rank {
+			urgent = true
+			break
+		}
+	}
+	if !urgent {
+		delete(q.deferred, key)
+		return 0
+	}
+
+	if q.deferred == nil {
+		q.deferred = map[string]time.Time{}
+	}
+	now := time.Now()
+	first, ok := q.deferred[key]
+	if !ok {
+		q.deferred[key] = now
+		first = now
+	}
+	if now.Sub(first) >= q.MaxDeferral {
+		delete(q.deferred, key)
+		return 0
+	}
+	return q.RetryAfter
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+)
+
+func TestFailoverQueueDefer(t *testing.T) {
+	tests := []struct {
+		name    string
+		pending map[string]haegressiputil.PriorityClass
+		key     string
+		want    bool
+	}{
+		{name: "no pending move", key: "routine", want: false},
+		{name: "routine reconcile during a failover", pending: map[string]haegressiputil.PriorityClass{"a": haegressiputil.PriorityClassLow}, key: "routine", want: true},
+		{name: "move of a higher class", pending: map[string]haegressiputil.PriorityClass{"a": haegressiputil.PriorityClassLow, "b": haegressiputil.PriorityClassCritical}, key: "b", want: false},
+		{name: "move of a lower class", pending: map[string]haegressiputil.PriorityClass{"a": haegressiputil.PriorityClassLow, "b": haegressiputil.PriorityClassCritical}, key: "a", want: true},
+		{name: "moves of the same class", pending: map[string]haegressiputil.PriorityClass{"a": haegressiputil.PriorityClassHigh, "b": haegressiputil.PriorityClassHigh}, key: "a", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &FailoverQueue{MaxDeferral: time.Hour, RetryAfter: time.Second}
+			for key, class := range tt.pending {
+				q.Moved(key, class)
+			}
+			if got := q.Defer(tt.key) > 0; got != tt.want {
+				t.Errorf("Defer(%q) deferred = %v, want %v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailoverQueueMaxDeferral(t *testing.T) {
+	q := &FailoverQueue{MaxDeferral: 50 * time.Millisecond, RetryAfter: time.Second}
+	q.Moved("a", haegressiputil.PriorityClassNormal)
+	if got := q.Defer("routine"); got != time.Second {
+		t.Fatalf("Defer() = %s, want 1s", got)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if got := q.Defer("routine"); got != 0 {
+		t.Errorf("Defer() = %s after MaxDeferral, want 0", got)
+	}
+}
+
+func TestFailoverQueueDone(t *testing.T) {
+	tests := []struct {
+		name string
+		done func(q *FailoverQueue, key string)
+	}{
+		{"followed", func(q *FailoverQueue, key string) { q.Followed(key) }},
+		{"forgotten", func(q *FailoverQueue, key string) { q.Forget(key) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &FailoverQueue{MaxDeferral: time.Hour, RetryAfter: time.Second}
+			q.Moved("a", haegressiputil.PriorityClassHigh)
+			q.Moved("b", haegressiputil.PriorityClassLow)
+			tt.done(q, "a")
+			if q.Pending("a") {
+				t.Errorf("Pending(a) = true, want false")
+			}
+			if !q.Pending("b") {
+				t.Errorf("Pending(b) = false, want true")
+			}
+			if got := q.Defer("b"); got != 0 {
+				t.Errorf("Defer(b) = %s, want 0 once the more urgent move is done", got)
+			}
+			tt.done(q, "b")
+			if got := q.Defer("routine"); got != 0 {
+				t.Errorf("Defer(routine) = %s, want 0 without pending moves", got)
+			}
+		})
+	}
+}
+
+func TestFailoverQueueNil(t *testing.T) {
+	var q *FailoverQueue
+	q.Moved("a", haegressiputil.PriorityClassHigh)
+	q.Followed("a")
+	q.Forget("a")
+	if q.Pending("a") || q.Defer("a") != 0 {
+		t.Errorf("a nil FailoverQueue must never defer")
+	}
+}
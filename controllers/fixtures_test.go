@@ -0,0 +1,118 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// testScheme knows the types of the operator, of Cilium and of Kubernetes
+func testScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(ciliumv2.AddToScheme(scheme))
+	utilruntime.Must(haegressv2.AddToScheme(scheme))
+	return scheme
+}
+
+// newTestClientBuilder returns a fake client builder with the indexes and the status
+// subresources used by the controllers
+func newTestClientBuilder(objects ...client.Object) *fake.ClientBuilder {
+	return fake.NewClientBuilder().
+		WithScheme(testScheme()).
+		WithObjects(objects...).
+		WithStatusSubresource(&haegressv2.HAEgressGatewayPolicy{}, &corev1.Service{}).
+		WithIndex(&corev1.Service{}, haegressip.ServiceLoadBalancerIPIndex, haegressiputil.IndexServiceLoadBalancerIPs)
+}
+
+// newTestClient returns a fake client holding the objects
+func newTestClient(objects ...client.Object) client.Client {
+	return newTestClientBuilder(objects...).Build()
+}
+
+// testPolicy returns a policy whose services are created in egress-system
+func testPolicy(name string) *haegressv2.HAEgressGatewayPolicy {
+	return &haegressv2.HAEgressGatewayPolicy{
+		TypeMeta:   metav1.TypeMeta{APIVersion: haegressv2.GroupVersion.String(), Kind: "HAEgressGatewayPolicy"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, UID: apitypes.UID("uid-" + name), Annotations: map[string]string{}},
+	}
+}
+
+// testService returns the service of the policy holding ip on node, an empty node is not
+// assigned yet
+func testService(policy *haegressv2.HAEgressGatewayPolicy, ip string, node string) *corev1.Service {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              policy.Name,
+			Namespace:         "egress-system",
+			CreationTimestamp: metav1.NewTime(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)),
+			Labels: map[string]string{
+				haegressip.HAEgressGatewayPolicyName:      policy.Name,
+				haegressip.HAEgressGatewayPolicyNamespace: "egress-system",
+			},
+			Annotations: map[string]string{haegressip.KubeVIPLoadBalancerIPsAnnotation: ip},
+		},
+		Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+	}
+	if node != "" {
+		service.Annotations[haegressip.KubeVIPVipHostAnnotation] = node
+		service.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: ip}}
+	}
+	utilruntime.Must(controllerutil.SetControllerReference(policy, service, testScheme()))
+	return service
+}
+
+// testCiliumEgressGatewayPolicy returns the CiliumEgressGatewayPolicy of the service using
+// egressIP on node
+func testCiliumEgressGatewayPolicy(policy *haegressv2.HAEgressGatewayPolicy, service *corev1.Service, egressIP string, node string) *ciliumv2.CiliumEgressGatewayPolicy {
+	ciliumEgressGatewayPolicy := &ciliumv2.CiliumEgressGatewayPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: haegressiputil.CiliumEgressGatewayPolicyName(service.Namespace, service.Name)},
+		Spec: ciliumv2.CiliumEgressGatewayPolicySpec{
+			EgressGateway: &ciliumv2.EgressGateway{
+				NodeSelector: &slimv1.LabelSelector{MatchLabels: map[string]slimv1.MatchLabelsValue{haegressip.NodeNameAnnotation: node}},
+				EgressIP:     egressIP,
+			},
+		},
+	}
+	utilruntime.Must(controllerutil.SetControllerReference(policy, ciliumEgressGatewayPolicy, testScheme()))
+	return ciliumEgressGatewayPolicy
+}
+
+// testNode returns a ready gateway node
+func testNode(name string, zone string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{
+			corev1.LabelHostname: name, corev1.LabelTopologyZone: zone,
+		}},
+		Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}},
+	}
+}
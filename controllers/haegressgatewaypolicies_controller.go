@@ -21,6 +21,8 @@ import (
 	"fmt"
 	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
 	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/config"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
 	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
 	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
 	"github.com/go-logr/logr"
@@ -34,11 +36,17 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/ratelimiter"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"strings"
+	"sync"
+	"time"
 )
 
 // HAEgressGatewayPolicyReconciler reconciles a HAEgressGatewayPolicy object
@@ -49,6 +57,32 @@ type HAEgressGatewayPolicyReconciler struct {
 	Recorder          record.EventRecorder
 	EgressNamespace   string
 	LoadBalancerClass string
+	OverlapDetection  string
+	Dampening         *haegressiputil.Dampening
+	Provider          provider.Provider
+	Cilium            *CiliumDiscovery
+	// RateLimiter retries the failed reconciles, nil uses the controller-runtime default
+	RateLimiter ratelimiter.RateLimiter
+	// MaxConcurrentReconciles is the number of policies reconciled in parallel
+	MaxConcurrentReconciles int
+	// Failovers postpones the routine reconciles while VIP moves are followed, nil disables it
+	Failovers *FailoverQueue
+	// Sharding spreads the policies across the replicas, nil reconciles every policy
+	Sharding *ReplicaSharding
+	// Scope restricts the instance to some policies and service namespaces
+	Scope haegressiputil.Scope
+	// Config provides the settings reloaded from the configuration file, nil uses the defaults
+	Config *config.Watcher
+	// MoveHold keeps the policies moved on request on their node, 0 lets the rebalancer and
+	// the failback move them again at once
+	MoveHold time.Duration
+
+	// moves tracks the move requests in progress by policy name
+	moves sync.Map
+	// podSelections caches the pods selected by every policy for the overlaps and the zones
+	podSelections podSelectionCache
+	// zoneCandidates tracks the zones elected to replace the preferred zone by policy name
+	zoneCandidates sync.Map
 }
 
 //+kubebuilder:rbac:groups=cilium.angeloxx.ch,resources=haegressgatewaypolicies,verbs=get;list;watch;create;update;patch;delete
@@ -67,6 +101,16 @@ type HAEgressGatewayPolicyReconciler struct {
 func (r *HAEgressGatewayPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := ctrl.LoggerFrom(ctx)
 
+	// With the replica sharding the policy may be reconciled by another replica
+	if !r.Sharding.Owns(req.Name) {
+		return ctrl.Result{}, nil
+	}
+
+	// The VIP moves are followed before the routine reconciles, for example after a node failure
+	if delay := r.Failovers.Defer(req.String()); delay > 0 {
+		return ctrl.Result{RequeueAfter: delay}, nil
+	}
+
 	var haEgressGatewayPolicy haegressv2.HAEgressGatewayPolicy
 
 	if err := r.Get(ctx, req.NamespacedName, &haEgressGatewayPolicy); err != nil {
@@ -74,53 +118,163 @@ func (r *HAEgressGatewayPolicyReconciler) Reconcile(ctx context.Context, req ctr
 			// we'll ignore not-found errors, since they can't be fixed by an immediate
 			// requeue (we'll need to wait for a new notification), and we can get them
 			// on deleted requests.
+			r.zoneCandidates.Delete(req.Name)
+			r.Dampening.Forget(req.Name)
 			return ctrl.Result{}, nil
 		}
 		log.Error(err, "unable to fetch HAEgressGatewayPolicy", "HAEgressGatewayPolicy", req.NamespacedName)
 		return ctrl.Result{}, err
 	}
 
-	if err := r.UpdateOrCreateCiliumEgressGatewayPolicy(ctx, &haEgressGatewayPolicy); err != nil {
-		log.Error(err, "unable to create or update CiliumEgressGatewayPolicy, please check RBAC permissions")
-		return ctrl.Result{RequeueAfter: haegressip.HAEgressGatewayPolicyChcekRequeueAfter}, err
+	// Without the CiliumEgressGatewayPolicy CRD nothing can be generated, the policy waits for it
+	if !r.Cilium.Available() {
+		if _, err := haegressiputil.UpdatePolicyCondition(ctx, r.Client, &haEgressGatewayPolicy, haegressip.ConditionCiliumUnavailable,
+			metav1.ConditionTrue, "CRDNotServed", r.Cilium.Message()); err != nil {
+			log.Error(err, "unable to update the HAEgressGatewayPolicy conditions")
+		}
+		return ctrl.Result{RequeueAfter: haegressip.CiliumCheckRequeueAfter.Get()}, nil
+	}
+	if r.Cilium != nil {
+		if _, err := haegressiputil.UpdatePolicyCondition(ctx, r.Client, &haEgressGatewayPolicy, haegressip.ConditionCiliumUnavailable,
+			metav1.ConditionFalse, "CRDServed", r.Cilium.Message()); err != nil {
+			log.Error(err, "unable to update the HAEgressGatewayPolicy conditions")
+		}
+	}
+
+	// The services of the policy must be in the namespaces seen by this instance
+	if namespace := haegressiputil.ServiceNamespace(&haEgressGatewayPolicy, r.EgressNamespace); !r.Scope.ServiceNamespaceAllowed(namespace) {
+		return haegressiputil.HandleReconcileError(ctx, r.Client, log, haEgressGatewayPolicy.Name, haegressiputil.NewPermanentError("NamespaceOutOfScope",
+			fmt.Errorf("the services namespace %s is not one of the namespaces of the operator: %s", namespace, strings.Join(r.Scope.ServiceNamespaces, ", "))))
+	}
+
+	// An unsupported IP family is reported before any object of the policy is changed
+	if err := haegressiputil.ValidatePolicyIPFamilies(&haEgressGatewayPolicy); err != nil {
+		return haegressiputil.HandleReconcileError(ctx, r.Client, log, haEgressGatewayPolicy.Name, err)
+	}
+
+	// The malformed tuning annotations fall back to their defaults, they are reported once
+	if err := r.ReportInvalidAnnotations(ctx, &haEgressGatewayPolicy); err != nil {
+		log.Error(err, "unable to update the HAEgressGatewayPolicy conditions")
+	}
+
+	// An invalid Service template is reported before any object of the policy is changed
+	if _, err := haegressiputil.PolicyServiceTemplate(&haEgressGatewayPolicy, r.renderOptions()); err != nil {
+		return haegressiputil.HandleReconcileError(ctx, r.Client, log, haEgressGatewayPolicy.Name, err)
+	}
+
+	// Every replica (shard) of the policy has its own Service, egress IP and CiliumEgressGatewayPolicy
+	replicas := haegressiputil.PolicyReplicas(&haEgressGatewayPolicy)
+	if err := r.CheckShardServiceNames(ctx, &haEgressGatewayPolicy, replicas); err != nil {
+		return haegressiputil.HandleReconcileError(ctx, r.Client, log, haEgressGatewayPolicy.Name, err)
+	}
+	for shard := 0; shard < replicas; shard++ {
+		if err := r.UpdateOrCreateCiliumEgressGatewayPolicy(ctx, &haEgressGatewayPolicy, shard); err != nil {
+			log.Error(err, "unable to create or update CiliumEgressGatewayPolicy, please check RBAC permissions")
+			return haegressiputil.HandleReconcileError(ctx, r.Client, log, haEgressGatewayPolicy.Name, err)
+		}
+
+		// Check if a service generated by this controller already exists, if not create the service
+		if err := r.UpdateOrCreateService(ctx, &haEgressGatewayPolicy, shard); err != nil {
+			log.Error(err, "unable to create or update Service, please check RBAC permissions")
+			return haegressiputil.HandleReconcileError(ctx, r.Client, log, haEgressGatewayPolicy.Name, err)
+		}
+	}
+
+	if err := r.DeleteStaleShards(ctx, &haEgressGatewayPolicy, replicas); err != nil {
+		log.Error(err, "unable to delete the Services and CiliumEgressGatewayPolicies of removed shards")
+		return haegressiputil.HandleReconcileError(ctx, r.Client, log, haEgressGatewayPolicy.Name, err)
+	}
+
+	if err := r.ShardPods(ctx, &haEgressGatewayPolicy, replicas); err != nil {
+		log.Error(err, "unable to label the pods selected by the HAEgressGatewayPolicy with their shard")
+		return haegressiputil.HandleReconcileError(ctx, r.Client, log, haEgressGatewayPolicy.Name, err)
 	}
 
-	// Check if a service generated by this controller already exists, if not create the service
-	if err := r.UpdateOrCreateService(ctx, &haEgressGatewayPolicy); err != nil {
-		log.Error(err, "unable to create or update Service, please check RBAC permissions")
-		return ctrl.Result{RequeueAfter: haegressip.HAEgressGatewayPolicyChcekRequeueAfter}, err
+	if err := haegressiputil.ClearReconcileError(ctx, r.Client, &haEgressGatewayPolicy); err != nil {
+		log.Error(err, "unable to update the HAEgressGatewayPolicy conditions")
+	}
+
+	if err := r.UpdateZones(ctx, &haEgressGatewayPolicy); err != nil {
+		log.Error(err, "unable to update the zones of the HAEgressGatewayPolicy")
+	}
+
+	// A move requested with the move-to annotation is checked until it completes
+	result, err := r.HandleMoveRequest(ctx, &haEgressGatewayPolicy)
+	if err != nil {
+		log.Error(err, "unable to move the egress IPs as requested")
+		return haegressiputil.HandleReconcileError(ctx, r.Client, log, haEgressGatewayPolicy.Name, err)
+	}
+	if result.RequeueAfter > 0 {
+		return result, nil
+	}
+
+	// Pods come and go, so the overlap detection and the zones are periodically re-evaluated
+	if r.OverlapDetection != "" && r.OverlapDetection != haegressip.OverlapDetectionDisabled {
+		if err := r.CheckOverlaps(ctx, &haEgressGatewayPolicy); err != nil {
+			log.Error(err, "unable to check overlaps with other egress policies")
+		}
+		return ctrl.Result{RequeueAfter: haegressip.OverlapCheckRequeueAfter.Get()}, nil
+	}
+	if haegressiputil.PolicyZoneAware(&haEgressGatewayPolicy) {
+		return ctrl.Result{RequeueAfter: haegressip.OverlapCheckRequeueAfter.Get()}, nil
 	}
 
 	return ctrl.Result{}, nil
 }
 
-func (r *HAEgressGatewayPolicyReconciler) UpdateOrCreateCiliumEgressGatewayPolicy(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy) error {
-	log := ctrl.LoggerFrom(ctx)
-	logger := log.WithValues("HAEgressGatewayPolicy", haEgressGatewayPolicy.Name)
+// ReportInvalidAnnotations sets the InvalidAnnotations condition of the policy and emits a
+// warning event when the malformed annotations change
+func (r *HAEgressGatewayPolicyReconciler) ReportInvalidAnnotations(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy) error {
+	status, reason, message := metav1.ConditionFalse, "AnnotationsValid", "The annotations of the policy are valid"
+	invalid := haegressiputil.InvalidAnnotations(haEgressGatewayPolicy)
+	if len(invalid) > 0 {
+		status, reason, message = metav1.ConditionTrue, "MalformedAnnotations", strings.Join(invalid, "; ")
+	}
 
-	serviceNamespace := r.EgressNamespace
-	if haEgressGatewayPolicy.Annotations[haegressip.HAEgressGatewayPolicyNamespace] != "" {
-		serviceNamespace = haEgressGatewayPolicy.Annotations[haegressip.HAEgressGatewayPolicyNamespace]
+	changed, err := haegressiputil.UpdatePolicyCondition(ctx, r.Client, haEgressGatewayPolicy, haegressip.ConditionInvalidAnnotations, status, reason, message)
+	if err != nil {
+		return err
+	}
+	if changed && len(invalid) > 0 {
+		ctrl.LoggerFrom(ctx).Info("HAEgressGatewayPolicy has malformed annotations", "annotations", message)
+		r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventAnnotationReason, message)
 	}
+	return nil
+}
 
-	ciliumEgressGatewayPolicyNew := &ciliumv2.CiliumEgressGatewayPolicy{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: fmt.Sprintf("%s-%s",
-				serviceNamespace,
-				haEgressGatewayPolicy.Name),
-			Labels:      haEgressGatewayPolicy.Labels,
-			Annotations: haEgressGatewayPolicy.Annotations,
-		},
-		Spec: haEgressGatewayPolicy.Spec,
+// deleteCiliumEgressGatewayPolicy removes a CiliumEgressGatewayPolicy no longer needed by the
+// policy, for example after a shard has been removed
+func (r *HAEgressGatewayPolicyReconciler) deleteCiliumEgressGatewayPolicy(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy, name string) error {
+	ciliumEgressGatewayPolicy := &ciliumv2.CiliumEgressGatewayPolicy{}
+	if err := r.Get(ctx, types.NamespacedName{Name: name}, ciliumEgressGatewayPolicy); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(ciliumEgressGatewayPolicy, haEgressGatewayPolicy) {
+		return nil
 	}
+	if err := r.Delete(ctx, ciliumEgressGatewayPolicy); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	r.Recorder.Event(haEgressGatewayPolicy,
+		corev1.EventTypeNormal,
+		"Deleted",
+		fmt.Sprintf("CiliumEgressGatewayPolicy %q deleted", name))
+	return nil
+}
+
+func (r *HAEgressGatewayPolicyReconciler) UpdateOrCreateCiliumEgressGatewayPolicy(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy, shard int) error {
+	log := ctrl.LoggerFrom(ctx)
+	logger := log.WithValues("HAEgressGatewayPolicy", haEgressGatewayPolicy.Name)
+	serviceNamespace := haegressiputil.ServiceNamespace(haEgressGatewayPolicy, r.EgressNamespace)
+	serviceName := haegressiputil.ShardServiceName(haEgressGatewayPolicy.Name, shard)
 
-	// Set HAEgressGatewayPolicy instance as the owner and controller
-	if err := controllerutil.SetControllerReference(haEgressGatewayPolicy, ciliumEgressGatewayPolicyNew, r.Scheme); err != nil {
+	ciliumEgressGatewayPolicyNew, err := haegressiputil.RenderCiliumEgressGatewayPolicy(haEgressGatewayPolicy, shard, r.renderOptions(), r.Scheme)
+	if err != nil {
 		return err
 	}
 
 	ciliumEgressGatewayPolicyExist := &ciliumv2.CiliumEgressGatewayPolicy{}
-	err := r.Get(ctx, types.NamespacedName{
+	err = r.Get(ctx, types.NamespacedName{
 		Name: ciliumEgressGatewayPolicyNew.Name,
 	}, ciliumEgressGatewayPolicyExist)
 
@@ -141,10 +295,10 @@ func (r *HAEgressGatewayPolicyReconciler) UpdateOrCreateCiliumEgressGatewayPolic
 
 		// If service already exists, reconcile
 		service := &corev1.Service{}
-		err = r.Get(ctx, types.NamespacedName{Name: haEgressGatewayPolicy.Name, Namespace: serviceNamespace}, service)
+		err = r.Get(ctx, types.NamespacedName{Name: serviceName, Namespace: serviceNamespace}, service)
 		if err == nil {
 			// Call the services reconcile function
-			_, syncError := haegressiputil.SyncServiceWithCiliumEgressGatewayPolicy(ctx, r.Client, logger, r.Recorder, *service, *ciliumEgressGatewayPolicyNew)
+			_, syncError := haegressiputil.SyncServiceWithCiliumEgressGatewayPolicy(ctx, r.Client, logger, r.Recorder, *service, *ciliumEgressGatewayPolicyNew, r.Dampening, r.Provider)
 			if syncError != nil {
 				return syncError
 			}
@@ -163,8 +317,17 @@ func (r *HAEgressGatewayPolicyReconciler) UpdateOrCreateCiliumEgressGatewayPolic
 				fmt.Sprintf("Resource %q already exists and is not managed by HAEgressGatewayPolicy", ciliumEgressGatewayPolicyExist.Name))
 			return nil
 		} else {
-			if !reflect.DeepEqual(ciliumEgressGatewayPolicyExist.Spec.Selectors, ciliumEgressGatewayPolicyNew.Spec.Selectors) {
+			specChanged := !reflect.DeepEqual(ciliumEgressGatewayPolicyExist.Spec.Selectors, ciliumEgressGatewayPolicyNew.Spec.Selectors) ||
+				!reflect.DeepEqual(ciliumEgressGatewayPolicyExist.Spec.DestinationCIDRs, ciliumEgressGatewayPolicyNew.Spec.DestinationCIDRs) ||
+				!reflect.DeepEqual(ciliumEgressGatewayPolicyExist.Spec.ExcludedCIDRs, ciliumEgressGatewayPolicyNew.Spec.ExcludedCIDRs)
+			if specChanged {
 				ciliumEgressGatewayPolicyExist.Spec.Selectors = ciliumEgressGatewayPolicyNew.Spec.Selectors
+				ciliumEgressGatewayPolicyExist.Spec.DestinationCIDRs = ciliumEgressGatewayPolicyNew.Spec.DestinationCIDRs
+				ciliumEgressGatewayPolicyExist.Spec.ExcludedCIDRs = ciliumEgressGatewayPolicyNew.Spec.ExcludedCIDRs
+			}
+			// The labels and annotations removed from the policy are removed too
+			metadataChanged := haegressiputil.SyncPropagatedMetadata(ciliumEgressGatewayPolicyExist, ciliumEgressGatewayPolicyNew, haEgressGatewayPolicy)
+			if specChanged || metadataChanged {
 				err = r.Update(ctx, ciliumEgressGatewayPolicyExist)
 				if err != nil {
 					return err
@@ -179,61 +342,19 @@ func (r *HAEgressGatewayPolicyReconciler) UpdateOrCreateCiliumEgressGatewayPolic
 	return nil
 }
 
-func (r *HAEgressGatewayPolicyReconciler) UpdateOrCreateService(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy) error {
+func (r *HAEgressGatewayPolicyReconciler) UpdateOrCreateService(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy, shard int) error {
 	log := ctrl.LoggerFrom(ctx)
 
-	serviceNamespace := r.EgressNamespace
-	if haEgressGatewayPolicy.Annotations[haegressip.HAEgressGatewayPolicyNamespace] != "" {
-		serviceNamespace = haEgressGatewayPolicy.Annotations[haegressip.HAEgressGatewayPolicyNamespace]
-	}
-
 	// @TODO: check if target namespace exists
 
-	// Define the service and copy all annotations from the HAEgressGatewayPolicy instance
-	service := &corev1.Service{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:        haEgressGatewayPolicy.Name,
-			Namespace:   serviceNamespace,
-			Labels:      haEgressGatewayPolicy.Labels,
-			Annotations: haEgressGatewayPolicy.Annotations,
-		},
-		Spec: corev1.ServiceSpec{
-			LoadBalancerClass: &r.LoadBalancerClass,
-			Ports: []corev1.ServicePort{
-				{
-					Name:     "nope",
-					Protocol: corev1.ProtocolTCP,
-					Port:     65534,
-				},
-			},
-			Type: corev1.ServiceTypeLoadBalancer,
-			// Points nowhere, is a serviceless service used to create the IP object
-			Selector: map[string]string{
-				haegressip.HAEgressGatewayPolicyNamespace: serviceNamespace,
-				haegressip.HAEgressGatewayPolicyName:      haEgressGatewayPolicy.Name,
-			},
-		},
-	}
-
-	if service.Labels == nil {
-		service.Labels = make(map[string]string)
-	}
-	if service.Annotations == nil {
-		service.Annotations = make(map[string]string)
-	}
-	// Avoid L2 announcement by Cilium
-	service.Labels[haegressip.KubernetesServiceProxyNameAnnotation] = "kubevip-managed-by-cilium-haegess"
-	service.Labels[haegressip.HAEgressGatewayPolicyNamespace] = serviceNamespace
-	service.Labels[haegressip.HAEgressGatewayPolicyName] = haEgressGatewayPolicy.Name
-
-	// Set HAEgressGatewayPolicy instance as the owner and controller
-	if err := controllerutil.SetControllerReference(haEgressGatewayPolicy, service, r.Scheme); err != nil {
+	service, err := haegressiputil.RenderService(haEgressGatewayPolicy, shard, r.renderOptions(), r.Scheme)
+	if err != nil {
 		return err
 	}
 
 	// Check if the service already exists, create if not exist, while if exist it will update the service
 	found := &corev1.Service{}
-	err := r.Get(ctx, types.NamespacedName{Name: service.Name, Namespace: service.Namespace}, found)
+	err = r.Get(ctx, types.NamespacedName{Name: service.Name, Namespace: service.Namespace}, found)
 	if err != nil && apierrors.IsNotFound(err) {
 		log.Info("Creating a new Service for HAEgressGatewayPolicy", "Service.Namespace", service.Namespace, "Service.Name", service.Name)
 		err = r.Create(ctx, service)
@@ -256,9 +377,12 @@ func (r *HAEgressGatewayPolicyReconciler) UpdateOrCreateService(ctx context.Cont
 
 			return nil
 		} else {
-			if !reflect.DeepEqual(found.Spec.Selector, service.Spec.Selector) {
+			// The labels and annotations removed from the policy are removed too, the ones set by
+			// the load balancer are kept
+			metadataChanged := haegressiputil.SyncPropagatedMetadata(found, service, haEgressGatewayPolicy)
+			if specChanged := haegressiputil.SyncServiceSpec(found, service); metadataChanged || specChanged {
 				log.Info("Updating Service already controlled by HAEgressGatewayPolicy", "Service.Namespace", found.Namespace, "Service.Name", found.Name)
-				err = r.Update(ctx, service)
+				err = r.Update(ctx, found)
 				if err != nil {
 					return err
 				}
@@ -269,6 +393,21 @@ func (r *HAEgressGatewayPolicyReconciler) UpdateOrCreateService(ctx context.Cont
 	return nil
 }
 
+// renderOptions returns the settings used to generate the objects of the policies
+func (r *HAEgressGatewayPolicyReconciler) renderOptions() haegressiputil.RenderOptions {
+	options := haegressiputil.RenderOptions{
+		EgressNamespace:   r.EgressNamespace,
+		LoadBalancerClass: r.LoadBalancerClass,
+	}
+	if r.Config != nil {
+		current := r.Config.Current()
+		options.Propagation = current.Propagation
+		options.ServiceTemplate = current.ServiceTemplate
+		options.ServiceClasses = current.ServiceClasses
+	}
+	return options
+}
+
 func (r *HAEgressGatewayPolicyReconciler) findObjectsForHaegressGatewayPolicy(ctx context.Context, obj client.Object) []reconcile.Request {
 	ownerRefs := obj.GetOwnerReferences()
 	requests := []reconcile.Request{}
@@ -289,7 +428,7 @@ func (r *HAEgressGatewayPolicyReconciler) findObjectsForHaegressGatewayPolicy(ct
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *HAEgressGatewayPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	c, err := ctrl.NewControllerManagedBy(mgr).
 		For(&haegressv2.HAEgressGatewayPolicy{}).
 		Watches(
 			&corev1.Service{},
@@ -310,9 +449,45 @@ func (r *HAEgressGatewayPolicyReconciler) SetupWithManager(mgr ctrl.Manager) err
 			}),
 		).
 		Watches(
-			&ciliumv2.CiliumEgressGatewayPolicy{},
-			handler.EnqueueRequestsFromMapFunc(r.findObjectsForHaegressGatewayPolicy),
+			&corev1.Pod{},
+			handler.EnqueueRequestsFromMapFunc(r.findPoliciesForPod),
 			builder.WithPredicates(predicate.Funcs{
+				DeleteFunc: func(e event.DeleteEvent) bool {
+					return false
+				},
+				CreateFunc: func(e event.CreateEvent) bool {
+					return true
+				},
+				UpdateFunc: func(e event.UpdateEvent) bool {
+					return !reflect.DeepEqual(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels())
+				},
+				GenericFunc: func(e event.GenericEvent) bool {
+					return false
+				},
+			}),
+		).
+		WithOptions(r.Sharding.ControllerOptions(controller.Options{
+			RateLimiter:             r.RateLimiter,
+			MaxConcurrentReconciles: r.MaxConcurrentReconciles,
+		})).
+		Build(r)
+	if err != nil {
+		return err
+	}
+
+	// The policies gained from a replica leaving the sharding are reconciled at once
+	if r.Sharding != nil {
+		if err := c.Watch(&source.Channel{Source: r.Sharding.Subscribe()}, &handler.EnqueueRequestForObject{}); err != nil {
+			return err
+		}
+	}
+
+	// The CiliumEgressGatewayPolicies are watched once their CRD is served
+	return r.Cilium.OnAvailable(func() error {
+		return c.Watch(
+			source.Kind(mgr.GetCache(), &ciliumv2.CiliumEgressGatewayPolicy{}),
+			handler.EnqueueRequestsFromMapFunc(r.findObjectsForHaegressGatewayPolicy),
+			predicate.Funcs{
 				DeleteFunc: func(e event.DeleteEvent) bool {
 					return true
 				},
@@ -325,7 +500,7 @@ func (r *HAEgressGatewayPolicyReconciler) SetupWithManager(mgr ctrl.Manager) err
 				GenericFunc: func(e event.GenericEvent) bool {
 					return false
 				},
-			}),
-		).
-		Complete(r)
+			},
+		)
+	})
 }
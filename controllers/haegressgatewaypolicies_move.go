@@ -0,0 +1,131 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+	"time"
+)
+
+// manualMove is a move requested with the move-to annotation and still in progress
+type manualMove struct {
+	target  string
+	started time.Time
+}
+
+// HandleMoveRequest moves the egress IPs of the policy to the node requested with the move-to
+// annotation, waits for the CiliumEgressGatewayPolicies to follow them and records the outcome
+// in the Moved condition. The annotation is removed once the request is completed or failed.
+func (r *HAEgressGatewayPolicyReconciler) HandleMoveRequest(ctx context.Context, policy *haegressv2.HAEgressGatewayPolicy) (ctrl.Result, error) {
+	log := ctrl.LoggerFrom(ctx)
+	target := strings.TrimSpace(policy.Annotations[haegressip.MoveToAnnotation])
+	if target == "" {
+		r.moves.Delete(policy.Name)
+		return ctrl.Result{}, nil
+	}
+
+	var move manualMove
+	if value, ok := r.moves.Load(policy.Name); ok && value.(manualMove).target == target {
+		move = value.(manualMove)
+	} else {
+		if err := haegressiputil.ValidateMoveTarget(ctx, r, policy, target); err != nil {
+			return ctrl.Result{}, r.completeMove(ctx, policy, metav1.ConditionFalse, "InvalidTarget",
+				fmt.Sprintf("Move to %s refused: %s", target, err.Error()))
+		}
+		move = manualMove{target: target, started: time.Now()}
+		r.moves.Store(policy.Name, move)
+
+		services, err := haegressiputil.PolicyServices(ctx, r, policy, r.EgressNamespace)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		for i := range services {
+			if r.Provider.CurrentNode(&services[i]) == target {
+				continue
+			}
+			if err := r.Provider.MoveVIP(ctx, &services[i], target); err != nil {
+				r.moves.Delete(policy.Name)
+				return ctrl.Result{}, r.completeMove(ctx, policy, metav1.ConditionFalse, "Failed",
+					fmt.Sprintf("Move of the VIP of service %s/%s to %s failed: %s", services[i].Namespace, services[i].Name, target, err.Error()))
+			}
+		}
+		log.Info("Moving egress IPs on request", "node", target)
+		r.Recorder.Event(policy, corev1.EventTypeNormal, haegressip.EventMoveReason,
+			fmt.Sprintf("Moving the egress IPs to %s on request", target))
+		if _, err := haegressiputil.UpdatePolicyCondition(ctx, r.Client, policy, haegressip.ConditionMoved,
+			metav1.ConditionUnknown, "Moving", fmt.Sprintf("Moving the egress IPs to %s", target)); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	moved, err := haegressiputil.PolicyMovedTo(ctx, r, r.Provider, policy, r.EgressNamespace, target)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	switch {
+	case moved:
+		r.moves.Delete(policy.Name)
+		log.Info("Egress IPs moved on request", "node", target, "duration", time.Since(move.started).String())
+		message := fmt.Sprintf("The egress IPs have been moved to %s", target)
+		if r.MoveHold > 0 {
+			message = fmt.Sprintf("%s and are held there until %s", message, time.Now().Add(r.MoveHold).Format(time.RFC3339))
+		}
+		return ctrl.Result{}, r.completeMove(ctx, policy, metav1.ConditionTrue, "Succeeded", message)
+	case time.Since(move.started) > haegressip.VIPMoveTimeout:
+		r.moves.Delete(policy.Name)
+		return ctrl.Result{}, r.completeMove(ctx, policy, metav1.ConditionFalse, "TimedOut",
+			fmt.Sprintf("The egress IPs have not been moved to %s within %s", target, haegressip.VIPMoveTimeout))
+	}
+	return ctrl.Result{RequeueAfter: haegressip.LeaseCheckRequeueAfter.Get()}, nil
+}
+
+// completeMove records the outcome of the move request and removes the move-to annotation, a
+// successful move is held with the hold-until annotation so that the rebalancer and the
+// failback do not undo it
+func (r *HAEgressGatewayPolicyReconciler) completeMove(ctx context.Context, policy *haegressv2.HAEgressGatewayPolicy, status metav1.ConditionStatus, reason string, message string) error {
+	eventType := corev1.EventTypeNormal
+	if status != metav1.ConditionTrue {
+		eventType = corev1.EventTypeWarning
+	}
+	r.Recorder.Event(policy, eventType, haegressip.EventMoveReason, message)
+	if _, err := haegressiputil.UpdatePolicyCondition(ctx, r.Client, policy, haegressip.ConditionMoved, status, reason, message); err != nil {
+		return err
+	}
+	annotations := map[string]*string{haegressip.MoveToAnnotation: nil}
+	if status == metav1.ConditionTrue && r.MoveHold > 0 {
+		holdUntil := time.Now().Add(r.MoveHold).UTC().Format(time.RFC3339)
+		annotations[haegressip.HoldUntilAnnotation] = &holdUntil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		return err
+	}
+	return r.Patch(ctx, policy, client.RawPatch(types.MergePatchType, patch))
+}
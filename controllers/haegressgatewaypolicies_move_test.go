@@ -0,0 +1,122 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestHandleMoveRequest(t *testing.T) {
+	policy := testPolicy("payments")
+	service := testService(policy, "10.0.0.1", "node-a")
+	ciliumEgressGatewayPolicy := testCiliumEgressGatewayPolicy(policy, service, "10.0.0.1", "node-a")
+	c := newTestClient(testNode("node-a", "zone-a"), testNode("node-b", "zone-b"),
+		policy, service, ciliumEgressGatewayPolicy, testLease(service, "node-a"))
+	r := &HAEgressGatewayPolicyReconciler{
+		Client: c, Recorder: record.NewFakeRecorder(10), Provider: provider.NewKubeVIP(c),
+		EgressNamespace: "egress-system", MoveHold: time.Hour,
+	}
+	ctx := context.Background()
+	handle := func(target string) (bool, *haegressv2.HAEgressGatewayPolicy) {
+		t.Helper()
+		current := &haegressv2.HAEgressGatewayPolicy{}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(policy), current); err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if current.Annotations == nil {
+			current.Annotations = map[string]string{}
+		}
+		current.Annotations[haegressip.MoveToAnnotation] = target
+		if err := c.Update(ctx, current); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+		result, err := r.HandleMoveRequest(ctx, current)
+		if err != nil {
+			t.Fatalf("HandleMoveRequest() error = %v", err)
+		}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(policy), current); err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		return result.RequeueAfter > 0, current
+	}
+	moved := func(current *haegressv2.HAEgressGatewayPolicy) *metav1.Condition {
+		return meta.FindStatusCondition(current.Status.Conditions, haegressip.ConditionMoved)
+	}
+
+	// A node that cannot hold the egress IPs is refused and the request is dropped
+	requeue, current := handle("node-c")
+	if condition := moved(current); requeue || condition == nil || condition.Reason != "InvalidTarget" {
+		t.Fatalf("move to an unknown node: requeue %v, condition %+v, want InvalidTarget", requeue, condition)
+	}
+	if _, ok := current.Annotations[haegressip.MoveToAnnotation]; ok {
+		t.Errorf("move-to annotation kept after a refused move")
+	}
+
+	// The VIP is handed over and the request is followed until the CiliumEgressGatewayPolicy
+	// uses the node
+	requeue, current = handle("node-b")
+	if condition := moved(current); !requeue || condition == nil || condition.Reason != "Moving" {
+		t.Fatalf("move to node-b: requeue %v, condition %+v, want Moving", requeue, condition)
+	}
+	lease := &coordinationv1.Lease{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(testLease(service, "")), lease); err != nil || *lease.Spec.HolderIdentity != "node-b" {
+		t.Fatalf("Lease held by %v, error %v, want node-b", lease.Spec.HolderIdentity, err)
+	}
+
+	// kube-vip announces the VIP on node-b and the CiliumEgressGatewayPolicy follows it
+	announced := &corev1.Service{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(service), announced); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	announced.Annotations[haegressip.KubeVIPVipHostAnnotation] = "node-b"
+	if err := c.Update(ctx, announced); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	followed := &ciliumv2.CiliumEgressGatewayPolicy{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(ciliumEgressGatewayPolicy), followed); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	followed.Spec.EgressGateway.NodeSelector = &slimv1.LabelSelector{MatchLabels: map[string]slimv1.MatchLabelsValue{haegressip.NodeNameAnnotation: "node-b"}}
+	if err := c.Update(ctx, followed); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	requeue, current = handle("node-b")
+	if condition := moved(current); requeue || condition == nil || condition.Status != metav1.ConditionTrue {
+		t.Fatalf("completed move: requeue %v, condition %+v, want Succeeded", requeue, condition)
+	}
+	if _, ok := current.Annotations[haegressip.MoveToAnnotation]; ok {
+		t.Errorf("move-to annotation kept after the move")
+	}
+	if holdUntil, err := time.Parse(time.RFC3339, current.Annotations[haegressip.HoldUntilAnnotation]); err != nil || time.Until(holdUntil) < 50*time.Minute {
+		t.Errorf("hold-until annotation = %q, want about an hour from now", current.Annotations[haegressip.HoldUntilAnnotation])
+	}
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"reflect"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxOverlappingPodsReported limits the number of pods listed in the Overlapping condition
+const maxOverlappingPodsReported = 5
+
+//+kubebuilder:rbac:groups="",resources=pods;namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups=cilium.io,resources=ciliumegressgatewaypolicies,verbs=list;watch
+
+// podSelection is the pods selected by every egress policy, evaluated at once for all the
+// policies
+type podSelection struct {
+	evaluated time.Time
+	// generations of the policies evaluated, keyed by reference
+	generations map[string]int64
+	policies    []haegressiputil.EgressPolicyRef
+	// selections are the namespace/name of the pods selected by each policy
+	selections map[string][]string
+	// podNodes are the nodes of the selected pods
+	podNodes map[string]string
+}
+
+// podSelectionCache keeps the last podSelection, so that the overlaps and the zones of every
+// policy are evaluated listing the pods once per overlap check interval, or when a policy
+// changes, instead of once per policy
+type podSelectionCache struct {
+	mu      sync.Mutex
+	current *podSelection
+}
+
+// currentPodSelection returns the pods selected by every egress policy, the result must not
+// be modified
+func (r *HAEgressGatewayPolicyReconciler) currentPodSelection(ctx context.Context) (*podSelection, error) {
+	policies := &haegressv2.HAEgressGatewayPolicyList{}
+	if err := r.List(ctx, policies); err != nil {
+		return nil, err
+	}
+	refs := []haegressiputil.EgressPolicyRef{}
+	generations := map[string]int64{}
+	for _, policy := range policies.Items {
+		ref := haegressiputil.EgressPolicyRef{Kind: "HAEgressGatewayPolicy", Name: policy.Name, Spec: policy.Spec.CiliumEgressGatewayPolicySpec}
+		refs = append(refs, ref)
+		generations[ref.String()] = policy.Generation
+	}
+
+	if r.OverlapDetection == haegressip.OverlapDetectionAll {
+		ciliumEgressGatewayPolicies := &ciliumv2.CiliumEgressGatewayPolicyList{}
+		if err := r.List(ctx, ciliumEgressGatewayPolicies); err != nil {
+			return nil, err
+		}
+		for _, policy := range ciliumEgressGatewayPolicies.Items {
+			if metav1.GetControllerOf(&policy) != nil {
+				continue
+			}
+			ref := haegressiputil.EgressPolicyRef{Kind: "CiliumEgressGatewayPolicy", Name: policy.Name, Spec: policy.Spec}
+			refs = append(refs, ref)
+			generations[ref.String()] = policy.Generation
+		}
+	}
+
+	r.podSelections.mu.Lock()
+	defer r.podSelections.mu.Unlock()
+	if current := r.podSelections.current; current != nil &&
+		time.Since(current.evaluated) < haegressip.OverlapCheckRequeueAfter.Get() &&
+		reflect.DeepEqual(current.generations, generations) {
+		return current, nil
+	}
+
+	// Only the namespaces the policies can select are listed
+	listed := []string{}
+	for _, ref := range refs {
+		namespaces := haegressiputil.PolicyNamespaces(ref.Spec)
+		if namespaces == nil {
+			listed = []string{""}
+			break
+		}
+		for _, namespace := range namespaces {
+			if !slices.Contains(listed, namespace) {
+				listed = append(listed, namespace)
+			}
+		}
+	}
+	pods := []corev1.Pod{}
+	for _, namespace := range listed {
+		namespacePods := &corev1.PodList{}
+		if err := r.List(ctx, namespacePods, client.InNamespace(namespace)); err != nil {
+			return nil, err
+		}
+		pods = append(pods, namespacePods.Items...)
+	}
+	namespaces, err := haegressiputil.NamespaceLabels(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	selection := &podSelection{
+		evaluated:   time.Now(),
+		generations: generations,
+		policies:    refs,
+		selections:  haegressiputil.PolicySelections(refs, pods, namespaces),
+		podNodes:    map[string]string{},
+	}
+	for i := range pods {
+		selection.podNodes[fmt.Sprintf("%s/%s", pods[i].Namespace, pods[i].Name)] = pods[i].Spec.NodeName
+	}
+	r.podSelections.current = selection
+	return selection, nil
+}
+
+// CheckOverlaps evaluates the selectors of all the egress policies against the running pods
+// and sets the Overlapping condition when another policy selects the same pods for an
+// overlapping destination, a case where the Cilium behavior is undefined.
+func (r *HAEgressGatewayPolicyReconciler) CheckOverlaps(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy) error {
+	log := ctrl.LoggerFrom(ctx)
+
+	selection, err := r.currentPodSelection(ctx)
+	if err != nil {
+		return err
+	}
+	self := haegressiputil.EgressPolicyRef{Kind: "HAEgressGatewayPolicy", Name: haEgressGatewayPolicy.Name, Spec: haEgressGatewayPolicy.Spec.CiliumEgressGatewayPolicySpec}
+	overlaps := haegressiputil.FindOverlaps(self, selection.policies, selection.selections)
+
+	status, reason, message := metav1.ConditionFalse, "NoOverlap", "No other policy selects the same pods for overlapping destinations"
+	if len(overlaps) > 0 {
+		descriptions := []string{}
+		for _, overlap := range overlaps {
+			affected := overlap.Pods
+			if len(affected) > maxOverlappingPodsReported {
+				affected = append(affected[:maxOverlappingPodsReported:maxOverlappingPodsReported],
+					fmt.Sprintf("and %d more", len(overlap.Pods)-maxOverlappingPodsReported))
+			}
+			descriptions = append(descriptions, fmt.Sprintf("%s on %s (pods: %s)",
+				overlap.Policy, strings.Join(overlap.Destinations, ","), strings.Join(affected, ", ")))
+		}
+		status, reason, message = metav1.ConditionTrue, "SelectorsOverlap", fmt.Sprintf("Overlaps with %s", strings.Join(descriptions, "; "))
+	}
+
+	changed, err := haegressiputil.UpdatePolicyCondition(ctx, r.Client, haEgressGatewayPolicy, haegressip.ConditionOverlapping, status, reason, message)
+	if err != nil {
+		return err
+	}
+	if changed && len(overlaps) > 0 {
+		log.Info("HAEgressGatewayPolicy overlaps with other egress policies", "overlaps", message)
+		r.Recorder.Event(haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.ConditionOverlapping, message)
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=cilium.io,resources=ciliumegressgatewaypolicies,verbs=get;list;watch;create;update;patch;delete
+
+// ShardPods labels every pod selected by the policy with the shard it has been bucketed in,
+// the shard CiliumEgressGatewayPolicies select the pods by this label, the pods not labeled
+// yet are selected by the first shard. When the policy has a single replica the label is
+// removed.
+func (r *HAEgressGatewayPolicyReconciler) ShardPods(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy, replicas int) error {
+	log := ctrl.LoggerFrom(ctx)
+	shardLabel := haegressiputil.ShardLabel(haEgressGatewayPolicy.Name)
+
+	// The pods already labeled must be updated, and the ones of the namespaces the policy
+	// selects must be bucketed
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.HasLabels{shardLabel}); err != nil {
+		return err
+	}
+	if replicas > 1 {
+		namespaces := haegressiputil.PolicyNamespaces(haEgressGatewayPolicy.Spec.CiliumEgressGatewayPolicySpec)
+		if namespaces == nil {
+			namespaces = []string{""}
+		}
+		for _, namespace := range namespaces {
+			namespacePods := &corev1.PodList{}
+			if err := r.List(ctx, namespacePods, client.InNamespace(namespace)); err != nil {
+				return err
+			}
+			for _, pod := range namespacePods.Items {
+				if _, labeled := pod.Labels[shardLabel]; !labeled {
+					pods.Items = append(pods.Items, pod)
+				}
+			}
+		}
+	}
+
+	namespaces, err := haegressiputil.NamespaceLabels(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		current, labeled := pod.Labels[shardLabel]
+
+		var desired *string
+		if replicas > 1 && haegressiputil.PodSelected(haEgressGatewayPolicy.Spec.CiliumEgressGatewayPolicySpec, pod, namespaces[pod.Namespace]) {
+			shard := strconv.Itoa(haegressiputil.PodShard(pod.Namespace, pod.Name, replicas))
+			if labeled && current == shard {
+				continue
+			}
+			desired = &shard
+		} else if !labeled {
+			continue
+		}
+
+		// A null value removes the label from the pod
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"labels": map[string]*string{shardLabel: desired},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if err := r.Patch(ctx, pod, client.RawPatch(types.MergePatchType, patch)); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		if desired != nil {
+			log.V(1).Info("Pod assigned to egress shard", "pod", fmt.Sprintf("%s/%s", pod.Namespace, pod.Name), "shard", *desired)
+		}
+	}
+	return nil
+}
+
+// CheckShardServiceNames rejects the policy when the Service of one of its shards is also the
+// Service of another policy in the same namespace, for example the shard 1 of egress and the
+// policy egress-1. The policy already controlling the Service keeps it, otherwise the oldest.
+func (r *HAEgressGatewayPolicyReconciler) CheckShardServiceNames(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy, replicas int) error {
+	serviceNamespace := haegressiputil.ServiceNamespace(haEgressGatewayPolicy, r.EgressNamespace)
+	names := haegressiputil.ShardServiceNames(haEgressGatewayPolicy.Name, replicas)
+
+	policies := &haegressv2.HAEgressGatewayPolicyList{}
+	if err := r.List(ctx, policies); err != nil {
+		return err
+	}
+	for i := range policies.Items {
+		other := &policies.Items[i]
+		if other.UID == haEgressGatewayPolicy.UID || haegressiputil.ServiceNamespace(other, r.EgressNamespace) != serviceNamespace {
+			continue
+		}
+		for _, name := range haegressiputil.ShardServiceNames(other.Name, haegressiputil.PolicyReplicas(other)) {
+			shard := slices.Index(names, name)
+			if shard < 0 || r.keepsShardService(ctx, haEgressGatewayPolicy, other, serviceNamespace, name) {
+				continue
+			}
+			return haegressiputil.NewPermanentError("ShardServiceNameCollision",
+				fmt.Errorf("the Service %s/%s of shard %d is also a Service of the HAEgressGatewayPolicy %s, rename one of the policies",
+					serviceNamespace, name, shard, other.Name))
+		}
+	}
+	return nil
+}
+
+// keepsShardService returns true if the policy keeps the Service claimed by the other policy:
+// it already controls the Service or, when none of them does, it is the oldest
+func (r *HAEgressGatewayPolicyReconciler) keepsShardService(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy, other *haegressv2.HAEgressGatewayPolicy, namespace string, name string) bool {
+	service := &corev1.Service{}
+	if err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, service); err == nil {
+		if metav1.IsControlledBy(service, haEgressGatewayPolicy) {
+			return true
+		}
+		if metav1.IsControlledBy(service, other) {
+			return false
+		}
+	}
+	if !haEgressGatewayPolicy.CreationTimestamp.Equal(&other.CreationTimestamp) {
+		return haEgressGatewayPolicy.CreationTimestamp.Before(&other.CreationTimestamp)
+	}
+	return haEgressGatewayPolicy.Name < other.Name
+}
+
+// DeleteStaleShards deletes the Services and CiliumEgressGatewayPolicies of the shards
+// removed when the number of replicas of the policy has been reduced
+func (r *HAEgressGatewayPolicyReconciler) DeleteStaleShards(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy, replicas int) error {
+	serviceNamespace := haegressiputil.ServiceNamespace(haEgressGatewayPolicy, r.EgressNamespace)
+
+	services := &corev1.ServiceList{}
+	if err := r.List(ctx, services, client.InNamespace(serviceNamespace), client.MatchingLabels{
+		haegressip.HAEgressGatewayPolicyName: haEgressGatewayPolicy.Name,
+	}); err != nil {
+		return err
+	}
+
+	for i := range services.Items {
+		service := &services.Items[i]
+		shard, err := strconv.Atoi(service.Labels[haegressip.ShardIndexLabel])
+		if err != nil || shard < replicas || !metav1.IsControlledBy(service, haEgressGatewayPolicy) {
+			continue
+		}
+		if err := r.deleteCiliumEgressGatewayPolicy(ctx, haEgressGatewayPolicy,
+			haegressiputil.CiliumEgressGatewayPolicyName(service.Namespace, service.Name)); err != nil {
+			return err
+		}
+		if err := r.Delete(ctx, service); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		r.Recorder.Event(haEgressGatewayPolicy,
+			corev1.EventTypeNormal,
+			"Deleted",
+			fmt.Sprintf("Service %s/%s of shard %d deleted", service.Namespace, service.Name, shard))
+	}
+
+	shards := []haegressv2.HAEgressGatewayPolicyShardStatus{}
+	for _, shard := range haEgressGatewayPolicy.Status.Shards {
+		if replicas > 1 && int(shard.Shard) < replicas {
+			shards = append(shards, shard)
+		}
+	}
+	if len(shards) != len(haEgressGatewayPolicy.Status.Shards) {
+		haEgressGatewayPolicy.Status.Shards = shards
+		return r.Status().Update(ctx, haEgressGatewayPolicy)
+	}
+	return nil
+}
+
+// findPoliciesForPod maps a pod to the sharded policies selecting it, so that new pods are
+// bucketed as soon as they are created. The zone-aware policies count the pods periodically.
+func (r *HAEgressGatewayPolicyReconciler) findPoliciesForPod(ctx context.Context, obj client.Object) []reconcile.Request {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil
+	}
+	policies := &haegressv2.HAEgressGatewayPolicyList{}
+	if err := r.List(ctx, policies); err != nil {
+		r.Log.Error(err, "unable to list HAEgressGatewayPolicies")
+		return nil
+	}
+	if !podOfInterest(pod, policies.Items) {
+		return nil
+	}
+
+	namespace := &corev1.Namespace{}
+	if err := r.Get(ctx, types.NamespacedName{Name: pod.Namespace}, namespace); err != nil {
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for i := range policies.Items {
+		policy := &policies.Items[i]
+		_, labeled := pod.Labels[haegressiputil.ShardLabel(policy.Name)]
+		if labeled || (haegressiputil.PolicyReplicas(policy) > 1 && haegressiputil.PodSelected(policy.Spec.CiliumEgressGatewayPolicySpec, pod, namespace.Labels)) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: policy.Name},
+			})
+		}
+	}
+	return requests
+}
+
+// podOfInterest returns true if the pod is labeled by a policy, or runs in a namespace that a
+// sharded policy can select: the events of the other pods are dropped before
+// looking up their namespace
+func podOfInterest(pod *corev1.Pod, policies []haegressv2.HAEgressGatewayPolicy) bool {
+	for key := range pod.Labels {
+		if strings.HasPrefix(key, haegressip.ShardLabelPrefix) {
+			return true
+		}
+	}
+	if pod.Spec.HostNetwork {
+		return false
+	}
+	for i := range policies {
+		policy := &policies[i]
+		if haegressiputil.PolicyReplicas(policy) <= 1 {
+			continue
+		}
+		namespaces := haegressiputil.PolicyNamespaces(policy.Spec.CiliumEgressGatewayPolicySpec)
+		if namespaces == nil || slices.Contains(namespaces, pod.Namespace) {
+			return true
+		}
+	}
+	return false
+}
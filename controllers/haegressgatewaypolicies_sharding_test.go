@@ -0,0 +1,100 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestCheckShardServiceNames(t *testing.T) {
+	// policy returns a policy created age ago with the replicas
+	policy := func(name string, replicas string, age time.Duration) *haegressv2.HAEgressGatewayPolicy {
+		policy := testPolicy(name)
+		policy.CreationTimestamp = metav1.NewTime(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC).Add(-age))
+		policy.Annotations[haegressip.ReplicasAnnotation] = replicas
+		return policy
+	}
+	tests := []struct {
+		name      string
+		policies  []*haegressv2.HAEgressGatewayPolicy
+		namespace string
+		// controller of the colliding service, empty when the service does not exist
+		controller string
+		rejected   string
+	}{
+		{
+			name:     "no collision",
+			policies: []*haegressv2.HAEgressGatewayPolicy{policy("egress", "2", time.Hour), policy("payments", "2", time.Minute)},
+		},
+		{
+			name:     "newer policy named as a shard",
+			policies: []*haegressv2.HAEgressGatewayPolicy{policy("egress", "2", time.Hour), policy("egress-1", "1", time.Minute)},
+			rejected: "egress-1",
+		},
+		{
+			name:     "newer policy with a shard named as a policy",
+			policies: []*haegressv2.HAEgressGatewayPolicy{policy("egress", "2", time.Minute), policy("egress-1", "1", time.Hour)},
+			rejected: "egress",
+		},
+		{
+			name:       "newer policy controlling the service keeps it",
+			policies:   []*haegressv2.HAEgressGatewayPolicy{policy("egress", "2", time.Minute), policy("egress-1", "1", time.Hour)},
+			controller: "egress",
+			rejected:   "egress-1",
+		},
+		{
+			name:      "policies in different namespaces",
+			policies:  []*haegressv2.HAEgressGatewayPolicy{policy("egress", "2", time.Minute), policy("egress-1", "1", time.Hour)},
+			namespace: "egress-other",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.namespace != "" {
+				tt.policies[1].Annotations[haegressip.HAEgressGatewayPolicyNamespace] = tt.namespace
+			}
+			objects := []client.Object{}
+			for _, policy := range tt.policies {
+				objects = append(objects, policy)
+				if policy.Name == tt.controller {
+					service := testService(policy, "10.0.0.10", "")
+					service.Name = "egress-1"
+					objects = append(objects, service)
+				}
+			}
+			r := &HAEgressGatewayPolicyReconciler{Client: newTestClient(objects...), EgressNamespace: "egress-system"}
+
+			for _, policy := range tt.policies {
+				err := r.CheckShardServiceNames(context.Background(), policy, haegressiputil.PolicyReplicas(policy))
+				if (err != nil) != (policy.Name == tt.rejected) {
+					t.Errorf("CheckShardServiceNames(%s) error = %v, rejected %q", policy.Name, err, tt.rejected)
+				}
+				if err != nil && haegressiputil.ClassifyError(err) != haegressiputil.ErrorPermanent {
+					t.Errorf("CheckShardServiceNames(%s) error = %v, want a permanent error", policy.Name, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	corev1 "k8s.io/api/core/v1"
+	"reflect"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sort"
+	"time"
+)
+
+//+kubebuilder:rbac:groups="",resources=nodes;pods;namespaces,verbs=get;list;watch
+
+// zoneCandidate is a zone elected to replace the preferred zone of a policy since a time
+type zoneCandidate struct {
+	zone  string
+	since time.Time
+}
+
+// UpdateZones reports in the status of a zone-aware policy the zones of the selected pods
+// and of the gateway nodes, and elects as preferred zone the one running most of the pods
+// among the zones with a gateway node. The failback moves the egress IP to that zone. The
+// pods are the ones of the last podSelection, at most one overlap check interval old.
+func (r *HAEgressGatewayPolicyReconciler) UpdateZones(ctx context.Context, haEgressGatewayPolicy *haegressv2.HAEgressGatewayPolicy) error {
+	log := ctrl.LoggerFrom(ctx)
+	if !haegressiputil.PolicyZoneAware(haEgressGatewayPolicy) && haEgressGatewayPolicy.Status.PreferredZone == "" &&
+		haEgressGatewayPolicy.Status.ExitZone == "" && len(haEgressGatewayPolicy.Status.Zones) == 0 {
+		return nil
+	}
+
+	nodeList := &corev1.NodeList{}
+	if err := r.List(ctx, nodeList); err != nil {
+		return err
+	}
+	nodeZones := map[string]string{}
+	for _, node := range nodeList.Items {
+		nodeZones[node.Name] = node.Labels[corev1.LabelTopologyZone]
+	}
+
+	zones := map[string]*haegressv2.HAEgressGatewayPolicyZoneStatus{}
+	zone := func(name string) *haegressv2.HAEgressGatewayPolicyZoneStatus {
+		if zones[name] == nil {
+			zones[name] = &haegressv2.HAEgressGatewayPolicyZoneStatus{Zone: name}
+		}
+		return zones[name]
+	}
+
+	exitZone := ""
+	if haegressiputil.PolicyZoneAware(haEgressGatewayPolicy) {
+		// The pods selected by every policy are evaluated once per overlap check interval
+		selection, err := r.currentPodSelection(ctx)
+		if err != nil {
+			return err
+		}
+		self := haegressiputil.EgressPolicyRef{Kind: "HAEgressGatewayPolicy", Name: haEgressGatewayPolicy.Name}
+		for _, pod := range selection.selections[self.String()] {
+			if podZone := nodeZones[selection.podNodes[pod]]; podZone != "" {
+				zone(podZone).Pods++
+			}
+		}
+		for i := range nodeList.Items {
+			node := &nodeList.Items[i]
+			if nodeZones[node.Name] != "" && haegressiputil.PolicyNodeMatches(haEgressGatewayPolicy, node) && haegressiputil.NodeEligible(node) {
+				zone(nodeZones[node.Name]).GatewayNodes++
+			}
+		}
+		exitZone = nodeZones[haEgressGatewayPolicy.Status.ExitNode]
+	}
+
+	distribution := []haegressv2.HAEgressGatewayPolicyZoneStatus{}
+	for _, status := range zones {
+		distribution = append(distribution, *status)
+	}
+	sort.Slice(distribution, func(i, j int) bool {
+		return distribution[i].Zone < distribution[j].Zone
+	})
+
+	// A zone replaces the preferred one only with a margin of pods kept for a while, so that
+	// the pods coming and going do not move the egress IP back and forth
+	preferredZone, replace := haegressiputil.ElectPreferredZone(distribution, haEgressGatewayPolicy.Status.PreferredZone, haegressip.PreferredZoneMargin)
+	if replace {
+		r.zoneCandidates.Delete(haEgressGatewayPolicy.Name)
+	} else {
+		candidate := zoneCandidate{zone: preferredZone, since: time.Now()}
+		if previous, loaded := r.zoneCandidates.LoadOrStore(haEgressGatewayPolicy.Name, candidate); loaded && previous.(zoneCandidate).zone == preferredZone {
+			candidate = previous.(zoneCandidate)
+		} else {
+			r.zoneCandidates.Store(haEgressGatewayPolicy.Name, candidate)
+		}
+		if time.Since(candidate.since) < haegressip.PreferredZoneStableFor {
+			preferredZone = haEgressGatewayPolicy.Status.PreferredZone
+		} else {
+			r.zoneCandidates.Delete(haEgressGatewayPolicy.Name)
+		}
+	}
+	if len(distribution) == 0 {
+		distribution = nil
+	}
+
+	if haEgressGatewayPolicy.Status.PreferredZone == preferredZone && haEgressGatewayPolicy.Status.ExitZone == exitZone &&
+		reflect.DeepEqual(haEgressGatewayPolicy.Status.Zones, distribution) {
+		return nil
+	}
+	if haEgressGatewayPolicy.Status.PreferredZone != preferredZone {
+		log.Info("Preferred zone of the HAEgressGatewayPolicy changed", "from", haEgressGatewayPolicy.Status.PreferredZone, "to", preferredZone)
+	}
+	haEgressGatewayPolicy.Status.PreferredZone = preferredZone
+	haEgressGatewayPolicy.Status.ExitZone = exitZone
+	haEgressGatewayPolicy.Status.Zones = distribution
+	return r.Status().Update(ctx, haEgressGatewayPolicy)
+}
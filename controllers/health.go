@@ -0,0 +1,183 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/metrics"
+	"github.com/go-logr/logr"
+	"net/http"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sync"
+	"time"
+)
+
+// Health provides the readiness and liveness checks of the operator: the informers must be
+// synced and the HAEgressGatewayPolicies listed recently from the API server to be ready, a
+// workqueue holding items without progress makes the operator not alive. The leadership is
+// served as a status, the standby replicas are ready too.
+type Health struct {
+	// Reader lists the policies from the API server, so that RBAC errors are detected
+	Reader client.Reader
+	Cache  cache.Cache
+	Log    logr.Logger
+	// Elected is closed when the replica is the leader, nil when leader election is disabled
+	Elected <-chan struct{}
+	// ListInterval between two lists of the policies, the readiness fails when the last
+	// successful list is older than two intervals
+	ListInterval time.Duration
+	// StallTimeout is how long a workqueue can hold items without processing any
+	StallTimeout time.Duration
+
+	mu       sync.Mutex
+	lastList time.Time
+	listErr  error
+	queues   map[string]queueProgress
+}
+
+// queueProgress records when a workqueue has last made progress
+type queueProgress struct {
+	done  uint64
+	since time.Time
+}
+
+// Start lists the policies periodically until the context is cancelled, it implements
+// manager.Runnable
+func (h *Health) Start(ctx context.Context) error {
+	ticker := time.NewTicker(h.ListInterval)
+	defer ticker.Stop()
+	for {
+		h.listPolicies(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection makes the checks run on every replica
+func (h *Health) NeedLeaderElection() bool {
+	return false
+}
+
+func (h *Health) listPolicies(ctx context.Context) {
+	err := h.Reader.List(ctx, &haegressv2.HAEgressGatewayPolicyList{}, client.Limit(1))
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.listErr = err
+	if err != nil {
+		h.Log.Error(err, "unable to list the HAEgressGatewayPolicies, check RBAC permissions")
+		return
+	}
+	h.lastList = time.Now()
+}
+
+// InformersCheck fails until the informers are synced
+func (h *Health) InformersCheck(req *http.Request) error {
+	ctx, cancel := context.WithTimeout(req.Context(), time.Second)
+	defer cancel()
+	if !h.Cache.WaitForCacheSync(ctx) {
+		return fmt.Errorf("informers not synced")
+	}
+	return nil
+}
+
+// PoliciesCheck fails when the policies have not been listed successfully recently
+func (h *Health) PoliciesCheck(_ *http.Request) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.lastList.IsZero() || time.Since(h.lastList) > 2*h.ListInterval {
+		if h.listErr != nil {
+			return fmt.Errorf("no recent list of the HAEgressGatewayPolicies: %w", h.listErr)
+		}
+		return fmt.Errorf("no recent list of the HAEgressGatewayPolicies")
+	}
+	return nil
+}
+
+// WorkqueueCheck fails when a workqueue holds items without processing any for the stall
+// timeout, or when an item is processed for longer
+func (h *Health) WorkqueueCheck(_ *http.Request) error {
+	queues, err := metrics.Workqueues()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.queues == nil {
+		h.queues = map[string]queueProgress{}
+	}
+	for name, stats := range queues {
+		progress, ok := h.queues[name]
+		if !ok || stats.Depth == 0 || stats.Done != progress.done {
+			progress = queueProgress{done: stats.Done, since: now}
+			h.queues[name] = progress
+		}
+		if stall := now.Sub(progress.since); stall > h.StallTimeout {
+			return fmt.Errorf("workqueue %s holds %.0f items without progress for %s", name, stats.Depth, stall.Round(time.Second))
+		}
+		if stats.LongestRunning > h.StallTimeout.Seconds() {
+			return fmt.Errorf("workqueue %s processes an item for %.0fs", name, stats.LongestRunning)
+		}
+	}
+	return nil
+}
+
+// Leader returns true if the replica is the leader or leader election is disabled
+func (h *Health) Leader() bool {
+	if h.Elected == nil {
+		return true
+	}
+	select {
+	case <-h.Elected:
+		return true
+	default:
+		return false
+	}
+}
+
+// ServeHTTP writes the leadership of the replica, leader or standby, for the status endpoint
+func (h *Health) ServeHTTP(rw http.ResponseWriter, _ *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain")
+	if h.Leader() {
+		_, _ = rw.Write([]byte("leader\n"))
+		return
+	}
+	_, _ = rw.Write([]byte("standby\n"))
+}
+
+// SetupWithManager adds the health checks to the Manager.
+func (h *Health) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.Add(h); err != nil {
+		return err
+	}
+	for name, check := range map[string]func(*http.Request) error{
+		"informers": h.InformersCheck,
+		"policies":  h.PoliciesCheck,
+	} {
+		if err := mgr.AddReadyzCheck(name, check); err != nil {
+			return err
+		}
+	}
+	return mgr.AddHealthzCheck("workqueue", h.WorkqueueCheck)
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestHealthLeader(t *testing.T) {
+	elected := make(chan struct{})
+	close(elected)
+	tests := []struct {
+		name    string
+		elected <-chan struct{}
+		want    string
+	}{
+		{name: "leader election disabled", elected: nil, want: "leader\n"},
+		{name: "standby", elected: make(chan struct{}), want: "standby\n"},
+		{name: "leader", elected: elected, want: "leader\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Health{Elected: tt.elected}
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, httptest.NewRequest("GET", "/debug/leader", nil))
+			if rw.Code != 200 || rw.Body.String() != tt.want {
+				t.Errorf("ServeHTTP() = %d %q, want 200 %q", rw.Code, rw.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestHealthPoliciesCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		listErr error
+		age     time.Duration
+		wantErr bool
+	}{
+		{name: "recent list", wantErr: false},
+		{name: "list denied", listErr: fmt.Errorf("forbidden"), wantErr: true},
+		{name: "stale list", age: 3 * time.Minute, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := newTestClientBuilder()
+			if tt.listErr != nil {
+				builder = builder.WithInterceptorFuncs(interceptor.Funcs{List: func(context.Context, client.WithWatch, client.ObjectList, ...client.ListOption) error {
+					return tt.listErr
+				}})
+			}
+			h := &Health{Reader: builder.Build(), Log: ctrl.Log, ListInterval: time.Minute}
+			if err := h.PoliciesCheck(nil); err == nil {
+				t.Errorf("PoliciesCheck() before the first list error = nil")
+			}
+			h.listPolicies(context.Background())
+			h.lastList = h.lastList.Add(-tt.age)
+			if err := h.PoliciesCheck(nil); (err != nil) != tt.wantErr {
+				t.Errorf("PoliciesCheck() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
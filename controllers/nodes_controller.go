@@ -0,0 +1,456 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	"reflect"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/ratelimiter"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"strings"
+	"sync"
+)
+
+// NodesController moves the VIPs off the nodes going into maintenance: cordoned nodes,
+// nodes with the maintenance annotation or taint and nodes being removed by the
+// autoscalers. Until every CiliumEgressGatewayPolicy points to another node, the local
+// kube-vip pod is protected by a PodDisruptionBudget so that its eviction is held. The
+// nodes holding a VIP are also protected from the autoscalers scale-down.
+type NodesController struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+	Provider provider.Provider
+
+	// Migration moves the VIPs off the nodes going into maintenance, the nodes with the
+	// egress-evacuate annotation are always evacuated
+	Migration bool
+	// AutoscalerProtection annotates the nodes holding a VIP to disable their scale-down
+	AutoscalerProtection bool
+
+	// DrainGuard enables the PodDisruptionBudget on the local kube-vip pod
+	DrainGuard bool
+	// KubeVIPNamespace and KubeVIPSelector find the kube-vip pods
+	KubeVIPNamespace string
+	KubeVIPSelector  labels.Selector
+	// RateLimiter retries the failed reconciles, nil uses the controller-runtime default
+	RateLimiter ratelimiter.RateLimiter
+	// MaxConcurrentReconciles is the number of nodes reconciled in parallel
+	MaxConcurrentReconciles int
+
+	// evacuating tracks the nodes being evacuated to report the completion once
+	evacuating sync.Map
+	// unplaced tracks the services of an evacuated node without an allowed node, to warn once
+	unplaced sync.Map
+	// unguarded tracks the nodes whose kube-vip pod is not evicted by the drains, to warn once
+	unguarded sync.Map
+}
+
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch;update
+
+func (r *NodesController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := r.Log.WithValues("node", req.Name)
+
+	node := &corev1.Node{}
+	if err := r.Get(ctx, req.NamespacedName, node); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.evacuating.Delete(req.Name)
+			r.unguarded.Delete(req.Name)
+			r.forgetUnplaced(req.Name)
+			return ctrl.Result{}, r.releaseDrainGuard(ctx, req.Name)
+		}
+		return ctrl.Result{}, err
+	}
+
+	reason := haegressiputil.NodeEvacuationReason(node)
+	if r.AutoscalerProtection {
+		holding, err := r.holdsVIPs(ctx, node.Name)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.syncScaleDownProtection(ctx, node, holding && reason == ""); err != nil {
+			logger.Error(err, "unable to update the scale-down protection of the node")
+			return haegressiputil.HandleReconcileError(ctx, r.Client, logger, "", err)
+		}
+	}
+	// An explicit evacuation is honored even when the maintenance migration is disabled
+	if reason == "" || (!r.Migration && reason != "Evacuate") {
+		r.evacuating.Delete(node.Name)
+		r.unguarded.Delete(node.Name)
+		r.forgetUnplaced(node.Name)
+		return ctrl.Result{}, r.releaseDrainGuard(ctx, node.Name)
+	}
+	r.evacuating.LoadOrStore(node.Name, true)
+
+	// The services that could not be moved are retried with the pending ones
+	pending, err := r.evacuate(ctx, logger, node, reason)
+	if err != nil && len(pending) == 0 {
+		return haegressiputil.HandleReconcileError(ctx, r.Client, logger, "", err)
+	}
+	if len(pending) > 0 {
+		if r.DrainGuard {
+			daemonSetPods, err := r.holdDrainGuard(ctx, node.Name)
+			if err != nil {
+				logger.Error(err, "unable to protect the kube-vip pod of the node")
+			}
+			// kubectl drain --ignore-daemonsets skips the DaemonSet pods without evicting them,
+			// the PodDisruptionBudget does not hold such a drain
+			if len(daemonSetPods) > 0 {
+				if _, warned := r.unguarded.LoadOrStore(node.Name, true); !warned {
+					r.Recorder.Event(node, corev1.EventTypeWarning, haegressip.EventDrainGuardReason,
+						fmt.Sprintf("The kube-vip pod %s is owned by a DaemonSet, a drain ignoring the DaemonSets does not wait for the egress VIPs to leave the node",
+							strings.Join(daemonSetPods, ", ")))
+				}
+			}
+		}
+		logger.Info("Waiting for the egress VIPs to leave the node", "reason", reason, "pending", pending)
+		return ctrl.Result{RequeueAfter: haegressip.LeaseCheckRequeueAfter.Get()}, nil
+	}
+
+	if err := r.releaseDrainGuard(ctx, node.Name); err != nil {
+		return ctrl.Result{}, err
+	}
+	r.unguarded.Delete(node.Name)
+	r.forgetUnplaced(node.Name)
+	if inProgress, _ := r.evacuating.Swap(node.Name, false); inProgress == true {
+		logger.Info("Egress VIPs moved off the node", "reason", reason)
+		r.Recorder.Event(node, corev1.EventTypeNormal, haegressip.EventEvacuationReason,
+			fmt.Sprintf("Every egress VIP moved off the node (%s)", reason))
+	}
+	return ctrl.Result{}, nil
+}
+
+// evacuate asks the provider to move every VIP held by the node to the least loaded allowed
+// node and returns the CiliumEgressGatewayPolicies still pointing to the node. A VIP already
+// handed over to another node is not moved again while its move completes, and a service that
+// cannot be moved does not hold the evacuation of the others.
+func (r *NodesController) evacuate(ctx context.Context, logger logr.Logger, node *corev1.Node, reason string) ([]string, error) {
+	services := &corev1.ServiceList{}
+	if err := r.List(ctx, services, client.HasLabels{haegressip.HAEgressGatewayPolicyName}); err != nil {
+		return nil, err
+	}
+	load := map[string]int{}
+	for i := range services.Items {
+		load[r.Provider.CurrentNode(&services.Items[i])]++
+	}
+
+	errs := []error{}
+	for i := range services.Items {
+		service := &services.Items[i]
+		if r.Provider.CurrentNode(service) != node.Name {
+			continue
+		}
+		elected, err := r.Provider.ElectedNode(ctx, service)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if elected != "" && elected != node.Name {
+			logger.V(1).Info("Egress VIP already moving off the node", "service", service.Namespace+"/"+service.Name, "to", elected)
+			continue
+		}
+		policy := &haegressv2.HAEgressGatewayPolicy{}
+		if err := r.Get(ctx, types.NamespacedName{Name: service.Labels[haegressip.HAEgressGatewayPolicyName]}, policy); err != nil {
+			continue
+		}
+		allowed, err := haegressiputil.AllowedNodes(ctx, r, policy)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		target := ""
+		for _, candidate := range allowed {
+			if candidate.Name != node.Name && (target == "" || load[candidate.Name] < load[target] ||
+				(load[candidate.Name] == load[target] && candidate.Name < target)) {
+				target = candidate.Name
+			}
+		}
+		if target == "" {
+			if _, warned := r.unplaced.LoadOrStore(node.Name+"/"+service.Namespace+"/"+service.Name, true); !warned {
+				r.Recorder.Event(node, corev1.EventTypeWarning, haegressip.EventEvacuationReason,
+					fmt.Sprintf("No node available for the VIP of service %s/%s", service.Namespace, service.Name))
+			}
+			continue
+		}
+		logger.Info("Moving egress VIP off the node", "reason", reason, "service", service.Namespace+"/"+service.Name, "to", target)
+		if err := r.Provider.MoveVIP(ctx, service, target); err != nil {
+			logger.Error(err, "unable to move the egress VIP off the node", "service", service.Namespace+"/"+service.Name, "to", target)
+			errs = append(errs, err)
+			continue
+		}
+		load[target]++
+		load[node.Name]--
+		r.Recorder.Event(policy, corev1.EventTypeNormal, haegressip.EventEvacuationReason,
+			fmt.Sprintf("Moving VIP of service %s/%s from %s (%s) to %s", service.Namespace, service.Name, node.Name, reason, target))
+	}
+
+	pending, err := r.pendingPolicies(ctx, node.Name)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return pending, errors.Join(errs...)
+}
+
+// forgetUnplaced drops the services of the node warned without an allowed node
+func (r *NodesController) forgetUnplaced(nodeName string) {
+	r.unplaced.Range(func(key, _ any) bool {
+		if strings.HasPrefix(key.(string), nodeName+"/") {
+			r.unplaced.Delete(key)
+		}
+		return true
+	})
+}
+
+// pendingPolicies returns the managed CiliumEgressGatewayPolicies whose nodeSelector still
+// points to the node
+func (r *NodesController) pendingPolicies(ctx context.Context, nodeName string) ([]string, error) {
+	usages, err := haegressiputil.NodeEgressUsages(ctx, r, r.Provider, nodeName)
+	if err != nil {
+		return nil, err
+	}
+	pending := []string{}
+	for _, usage := range usages {
+		pending = append(pending, usage.CiliumEgressGatewayPolicies...)
+	}
+	return pending, nil
+}
+
+// holdsVIPs returns true if the node announces a VIP or is the gateway of a managed
+// CiliumEgressGatewayPolicy
+func (r *NodesController) holdsVIPs(ctx context.Context, nodeName string) (bool, error) {
+	usages, err := haegressiputil.NodeEgressUsages(ctx, r, r.Provider, nodeName)
+	return len(usages) > 0, err
+}
+
+// syncScaleDownProtection adds the cluster-autoscaler and Karpenter annotations disabling
+// the scale-down of the node, or removes them when the node no longer needs protection.
+// Only the annotations added by the operator, recorded in the scale-down-protected
+// annotation, are removed.
+func (r *NodesController) syncScaleDownProtection(ctx context.Context, node *corev1.Node, protect bool) error {
+	annotations := map[string]*string{}
+	owned := strings.Split(node.Annotations[haegressip.ScaleDownProtectedAnnotation], ",")
+	enabled := "true"
+
+	if protect {
+		added := []string{}
+		for _, annotation := range []string{haegressip.ScaleDownDisabledAnnotation, haegressip.KarpenterDoNotDisruptAnnotation} {
+			if _, ok := node.Annotations[annotation]; ok && !containsAnnotation(owned, annotation) {
+				// Set by someone else, leave it alone
+				continue
+			}
+			annotations[annotation] = &enabled
+			added = append(added, annotation)
+		}
+		value := strings.Join(added, ",")
+		if len(added) == 0 || value == node.Annotations[haegressip.ScaleDownProtectedAnnotation] {
+			return nil
+		}
+		annotations[haegressip.ScaleDownProtectedAnnotation] = &value
+	} else {
+		if _, ok := node.Annotations[haegressip.ScaleDownProtectedAnnotation]; !ok {
+			return nil
+		}
+		for _, annotation := range owned {
+			if annotation != "" {
+				annotations[annotation] = nil
+			}
+		}
+		annotations[haegressip.ScaleDownProtectedAnnotation] = nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if err := r.Patch(ctx, node, client.RawPatch(types.MergePatchType, patch)); err != nil {
+		return err
+	}
+	if protect {
+		r.Log.Info("Disabled the scale-down of the node holding egress VIPs", "node", node.Name)
+	} else {
+		r.Log.Info("Restored the scale-down of the node", "node", node.Name)
+	}
+	return nil
+}
+
+func containsAnnotation(annotations []string, annotation string) bool {
+	for _, a := range annotations {
+		if a == annotation {
+			return true
+		}
+	}
+	return false
+}
+
+// findNodesForService maps a managed service to the node announcing its VIP, so that the
+// scale-down protection follows the VIP
+func (r *NodesController) findNodesForService(ctx context.Context, obj client.Object) []reconcile.Request {
+	service, ok := obj.(*corev1.Service)
+	if !ok || service.Labels[haegressip.HAEgressGatewayPolicyName] == "" {
+		return nil
+	}
+	if node := r.Provider.CurrentNode(service); node != "" {
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: node}}}
+	}
+	return nil
+}
+
+// holdDrainGuard labels the kube-vip pod running on the node and creates a
+// PodDisruptionBudget selecting it that allows no disruption. It returns the guarded pods
+// owned by a DaemonSet: the eviction API honors the PodDisruptionBudget, but kubectl drain
+// --ignore-daemonsets never evicts them and is not held.
+func (r *NodesController) holdDrainGuard(ctx context.Context, nodeName string) ([]string, error) {
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(r.KubeVIPNamespace), client.MatchingLabelsSelector{Selector: r.KubeVIPSelector}); err != nil {
+		return nil, err
+	}
+	daemonSetPods := []string{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Spec.NodeName != nodeName {
+			continue
+		}
+		if owner := metav1.GetControllerOf(pod); owner != nil && owner.Kind == "DaemonSet" {
+			daemonSetPods = append(daemonSetPods, pod.Name)
+		}
+		if pod.Labels[haegressip.DrainGuardLabel] == nodeName {
+			continue
+		}
+		if err := r.patchDrainGuardLabel(ctx, pod, &nodeName); err != nil {
+			return daemonSetPods, err
+		}
+	}
+
+	maxUnavailable := intstr.FromInt32(0)
+	pdb := &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      haegressip.DrainGuardPrefix + nodeName,
+			Namespace: r.KubeVIPNamespace,
+			Labels:    map[string]string{haegressip.DrainGuardLabel: nodeName},
+		},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			MaxUnavailable: &maxUnavailable,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{haegressip.DrainGuardLabel: nodeName},
+			},
+		},
+	}
+	if err := r.Create(ctx, pdb); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			return daemonSetPods, nil
+		}
+		return daemonSetPods, err
+	}
+	r.Log.Info("Holding the drain of the kube-vip pod until the egress VIPs leave the node", "node", nodeName)
+	return daemonSetPods, nil
+}
+
+// releaseDrainGuard deletes the PodDisruptionBudget protecting the kube-vip pod of the node
+func (r *NodesController) releaseDrainGuard(ctx context.Context, nodeName string) error {
+	pdb := &policyv1.PodDisruptionBudget{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: r.KubeVIPNamespace, Name: haegressip.DrainGuardPrefix + nodeName}, pdb)
+	if err == nil {
+		if err := r.Delete(ctx, pdb); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		r.Log.Info("Released the drain of the kube-vip pod", "node", nodeName)
+	} else if !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(r.KubeVIPNamespace), client.MatchingLabels{haegressip.DrainGuardLabel: nodeName}); err != nil {
+		return err
+	}
+	for i := range pods.Items {
+		if err := r.patchDrainGuardLabel(ctx, &pods.Items[i], nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// patchDrainGuardLabel sets the drain guard label of the pod, a nil value removes it
+func (r *NodesController) patchDrainGuardLabel(ctx context.Context, pod *corev1.Pod, value *string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]*string{haegressip.DrainGuardLabel: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return client.IgnoreNotFound(r.Patch(ctx, pod, client.RawPatch(types.MergePatchType, patch)))
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *NodesController) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.Node{}, builder.WithPredicates(predicate.Funcs{
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				// Ignore the heartbeats, only the evacuation triggers and the readiness matter
+				oldNode, oldOk := e.ObjectOld.(*corev1.Node)
+				newNode, newOk := e.ObjectNew.(*corev1.Node)
+				if !oldOk || !newOk {
+					return false
+				}
+				return oldNode.Spec.Unschedulable != newNode.Spec.Unschedulable ||
+					!reflect.DeepEqual(oldNode.Spec.Taints, newNode.Spec.Taints) ||
+					!reflect.DeepEqual(oldNode.Annotations, newNode.Annotations) ||
+					haegressiputil.NodeReady(oldNode) != haegressiputil.NodeReady(newNode)
+			},
+		})).
+		Watches(
+			&corev1.Service{},
+			handler.EnqueueRequestsFromMapFunc(r.findNodesForService),
+		).
+		WithOptions(controller.Options{
+			RateLimiter:             r.RateLimiter,
+			MaxConcurrentReconciles: r.MaxConcurrentReconciles,
+		}).
+		Complete(r)
+}
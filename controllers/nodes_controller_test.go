@@ -0,0 +1,188 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// testLease returns the kube-vip Lease of the service held by the node
+func testLease(service *corev1.Service, holder string) *coordinationv1.Lease {
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: provider.KubeVIPLeasePrefix + service.Name, Namespace: service.Namespace},
+		Spec:       coordinationv1.LeaseSpec{HolderIdentity: &holder},
+	}
+}
+
+// movingEvents returns the number of VIP moves recorded since the last call
+func movingEvents(recorder *record.FakeRecorder) int {
+	moves := 0
+	for {
+		select {
+		case event := <-recorder.Events:
+			if strings.Contains(event, "Moving VIP") {
+				moves++
+			}
+		default:
+			return moves
+		}
+	}
+}
+
+func TestNodesControllerEvacuate(t *testing.T) {
+	drained := testNode("node-a", "zone-a")
+	drained.Annotations = map[string]string{haegressip.EvacuateAnnotation: "true"}
+	objects := []client.Object{drained, testNode("node-b", "zone-b"), testNode("node-c", "zone-c")}
+	services := map[string]*corev1.Service{}
+	// billing has no Lease and cannot be moved, orders is already handed over to node-c
+	for name, ip := range map[string]string{"billing": "10.0.0.1", "orders": "10.0.0.2", "payments": "10.0.0.3"} {
+		policy := testPolicy(name)
+		service := testService(policy, ip, "node-a")
+		services[name] = service
+		objects = append(objects, policy, service, testCiliumEgressGatewayPolicy(policy, service, "", "node-a"))
+	}
+	objects = append(objects, testLease(services["orders"], "node-c"), testLease(services["payments"], "node-a"))
+
+	c := newTestClient(objects...)
+	recorder := record.NewFakeRecorder(20)
+	r := &NodesController{Client: c, Log: ctrl.Log, Recorder: recorder, Provider: provider.NewKubeVIP(c)}
+
+	for pass := 0; pass < 2; pass++ {
+		result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(drained)})
+		if err != nil {
+			t.Fatalf("Reconcile() pass %d error = %v", pass, err)
+		}
+		if result.RequeueAfter == 0 {
+			t.Errorf("Reconcile() pass %d not requeued while the VIPs are pending", pass)
+		}
+		want := 0
+		if pass == 0 {
+			want = 1
+		}
+		if got := movingEvents(recorder); got != want {
+			t.Errorf("Reconcile() pass %d recorded %d moves, want %d", pass, got, want)
+		}
+	}
+
+	for name, want := range map[string]string{"orders": "node-c", "payments": "node-b"} {
+		lease := &coordinationv1.Lease{}
+		if err := c.Get(context.Background(), client.ObjectKeyFromObject(testLease(services[name], "")), lease); err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if got := *lease.Spec.HolderIdentity; got != want {
+			t.Errorf("Lease of %s held by %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestNodesControllerScaleDownProtection(t *testing.T) {
+	protected := haegressip.ScaleDownDisabledAnnotation + "," + haegressip.KarpenterDoNotDisruptAnnotation
+	tests := []struct {
+		name          string
+		annotations   map[string]string
+		holdsVIP      bool
+		unschedulable bool
+		want          map[string]string
+	}{
+		{
+			name:     "holding a VIP",
+			holdsVIP: true,
+			want: map[string]string{
+				haegressip.ScaleDownDisabledAnnotation:     "true",
+				haegressip.KarpenterDoNotDisruptAnnotation: "true",
+				haegressip.ScaleDownProtectedAnnotation:    protected,
+			},
+		},
+		{
+			name:        "holding a VIP with an annotation set by someone else",
+			annotations: map[string]string{haegressip.ScaleDownDisabledAnnotation: "true"},
+			holdsVIP:    true,
+			want: map[string]string{
+				haegressip.ScaleDownDisabledAnnotation:     "true",
+				haegressip.KarpenterDoNotDisruptAnnotation: "true",
+				haegressip.ScaleDownProtectedAnnotation:    haegressip.KarpenterDoNotDisruptAnnotation,
+			},
+		},
+		{
+			name: "VIP gone",
+			annotations: map[string]string{
+				haegressip.ScaleDownDisabledAnnotation:     "true",
+				haegressip.KarpenterDoNotDisruptAnnotation: "true",
+				haegressip.ScaleDownProtectedAnnotation:    protected,
+			},
+			want: map[string]string{},
+		},
+		{
+			name: "VIP gone with an annotation set by someone else",
+			annotations: map[string]string{
+				haegressip.ScaleDownDisabledAnnotation:     "true",
+				haegressip.KarpenterDoNotDisruptAnnotation: "true",
+				haegressip.ScaleDownProtectedAnnotation:    haegressip.KarpenterDoNotDisruptAnnotation,
+			},
+			want: map[string]string{haegressip.ScaleDownDisabledAnnotation: "true"},
+		},
+		{
+			name:          "cordoned node holding a VIP",
+			holdsVIP:      true,
+			unschedulable: true,
+			want:          map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := testNode("node-a", "zone-a")
+			node.Annotations = tt.annotations
+			node.Spec.Unschedulable = tt.unschedulable
+			objects := []client.Object{node}
+			if tt.holdsVIP {
+				policy := testPolicy("payments")
+				objects = append(objects, policy, testService(policy, "10.0.0.1", node.Name))
+			}
+			c := newTestClient(objects...)
+			r := &NodesController{
+				Client: c, Log: ctrl.Log, Recorder: record.NewFakeRecorder(10), Provider: provider.NewKubeVIP(c),
+				AutoscalerProtection: true,
+			}
+
+			if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(node)}); err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+			got := &corev1.Node{}
+			if err := c.Get(context.Background(), client.ObjectKeyFromObject(node), got); err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if got.Annotations == nil {
+				got.Annotations = map[string]string{}
+			}
+			if !reflect.DeepEqual(got.Annotations, tt.want) {
+				t.Errorf("node annotations = %v, want %v", got.Annotations, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,314 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"time"
+)
+
+// Rebalancer spreads the egress VIPs across the gateway nodes: it periodically looks at the
+// distribution of the exit nodes and moves one VIP at a time, through the provider, from the
+// most loaded node to the least loaded eligible one.
+type Rebalancer struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+	Provider provider.Provider
+
+	// Interval between two evaluations of the distribution
+	Interval time.Duration
+	// MaxVIPsPerNode moves VIPs away from a node holding more VIPs, 0 means no limit
+	MaxVIPsPerNode int
+	// Window restricts the moves to a daily maintenance window
+	Window haegressiputil.TimeWindow
+	// DisruptionBudget is the maximum number of moves in a maintenance window, or in a day
+	// when no window is configured, 0 means no limit
+	DisruptionBudget int
+
+	pending     *rebalanceMove
+	restored    bool
+	windowMoves int
+	windowDay   int
+}
+
+// rebalanceMove is the move in progress, it is recorded in the RebalanceMoveAnnotation of the
+// service so that a new leader waits for it too
+type rebalanceMove struct {
+	service types.NamespacedName
+	Policy  string    `json:"policy"`
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Started time.Time `json:"started"`
+}
+
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch;update
+
+// Start runs the rebalancer until the context is cancelled, it implements manager.Runnable
+func (r *Rebalancer) Start(ctx context.Context) error {
+	r.Log.Info("Starting egress VIP rebalancer", "interval", r.Interval, "window", r.Window.String(),
+		"maxVIPsPerNode", r.MaxVIPsPerNode, "disruptionBudget", r.DisruptionBudget)
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := r.rebalance(ctx); err != nil {
+				r.Log.Error(err, "unable to rebalance the egress VIPs")
+			}
+		}
+	}
+}
+
+// NeedLeaderElection makes the rebalancer run only on the leader
+func (r *Rebalancer) NeedLeaderElection() bool {
+	return true
+}
+
+func (r *Rebalancer) rebalance(ctx context.Context) error {
+	now := time.Now()
+	if !r.Window.Contains(now) {
+		r.windowMoves = 0
+		return nil
+	}
+	if !r.Window.IsSet() && r.windowDay != now.YearDay() {
+		r.windowDay = now.YearDay()
+		r.windowMoves = 0
+	}
+
+	// Only one VIP is moved at a time: wait for the previous move to complete, even when it
+	// has been started by the previous leader
+	if !r.restored {
+		if err := r.restorePendingMove(ctx); err != nil {
+			return err
+		}
+	}
+	if r.pending != nil {
+		done, err := r.checkPendingMove(ctx)
+		if err != nil || !done {
+			return err
+		}
+	}
+
+	if r.DisruptionBudget > 0 && r.windowMoves >= r.DisruptionBudget {
+		r.Log.V(1).Info("Disruption budget exhausted for the current window", "moves", r.windowMoves)
+		return nil
+	}
+
+	services := &corev1.ServiceList{}
+	if err := r.List(ctx, services, client.HasLabels{haegressip.HAEgressGatewayPolicyName}); err != nil {
+		return err
+	}
+
+	// Count the VIPs held by every candidate node
+	load := map[string]int{}
+	candidates := map[string][]string{}
+	policies := map[string]*haegressv2.HAEgressGatewayPolicy{}
+	for i := range services.Items {
+		service := &services.Items[i]
+		policy := &haegressv2.HAEgressGatewayPolicy{}
+		if err := r.Get(ctx, types.NamespacedName{Name: service.Labels[haegressip.HAEgressGatewayPolicyName]}, policy); err != nil {
+			continue
+		}
+		key := service.Namespace + "/" + service.Name
+		if current := r.Provider.CurrentNode(service); current != "" {
+			load[current]++
+		}
+		// The VIPs of the policies with preferred nodes are placed by the failback, the ones
+		// being moved, or recently moved, on request are left alone
+		if haegressiputil.PolicyHasPreference(policy) || policy.Annotations[haegressip.MoveToAnnotation] != "" ||
+			haegressiputil.PolicyHeld(policy, time.Now()) {
+			continue
+		}
+		nodes, err := haegressiputil.AllowedNodes(ctx, r, policy)
+		if err != nil {
+			return err
+		}
+		policies[key] = policy
+		for _, node := range nodes {
+			candidates[key] = append(candidates[key], node.Name)
+			if _, ok := load[node.Name]; !ok {
+				load[node.Name] = 0
+			}
+		}
+	}
+
+	// Try the services of the most loaded nodes first
+	sort.SliceStable(services.Items, func(i, j int) bool {
+		return load[r.Provider.CurrentNode(&services.Items[i])] > load[r.Provider.CurrentNode(&services.Items[j])]
+	})
+	for i := range services.Items {
+		service := &services.Items[i]
+		key := service.Namespace + "/" + service.Name
+		current := r.Provider.CurrentNode(service)
+		if current == "" || policies[key] == nil {
+			continue
+		}
+
+		target := ""
+		for _, node := range candidates[key] {
+			if target == "" || load[node] < load[target] || (load[node] == load[target] && node < target) {
+				target = node
+			}
+		}
+		if target == "" || target == current {
+			continue
+		}
+		overLimit := r.MaxVIPsPerNode > 0 && load[current] > r.MaxVIPsPerNode
+		if !overLimit && load[current]-load[target] <= 1 {
+			continue
+		}
+		if r.MaxVIPsPerNode > 0 && load[target]+1 > r.MaxVIPsPerNode {
+			continue
+		}
+
+		r.Log.Info("Moving egress VIP to rebalance the gateway nodes", "service", key,
+			"from", current, "to", target, "fromVIPs", load[current], "toVIPs", load[target])
+		if err := r.Provider.MoveVIP(ctx, service, target); err != nil {
+			return err
+		}
+		r.pending = &rebalanceMove{
+			service: types.NamespacedName{Namespace: service.Namespace, Name: service.Name},
+			Policy:  policies[key].Name,
+			From:    current,
+			To:      target,
+			Started: time.Now(),
+		}
+		r.windowMoves++
+		r.Recorder.Event(policies[key], corev1.EventTypeNormal, haegressip.EventRebalanceReason,
+			fmt.Sprintf("Moving VIP of service %s from %s to %s to rebalance the gateway nodes", key, current, target))
+		return r.recordPendingMove(ctx, r.pending.service, r.pending)
+	}
+	return nil
+}
+
+// restorePendingMove loads the move started by the previous leader, if any
+func (r *Rebalancer) restorePendingMove(ctx context.Context) error {
+	services := &corev1.ServiceList{}
+	if err := r.List(ctx, services, client.HasLabels{haegressip.HAEgressGatewayPolicyName}); err != nil {
+		return err
+	}
+	r.restored = true
+	for i := range services.Items {
+		service := &services.Items[i]
+		value, ok := service.Annotations[haegressip.RebalanceMoveAnnotation]
+		if !ok {
+			continue
+		}
+		move := &rebalanceMove{}
+		if err := json.Unmarshal([]byte(value), move); err != nil {
+			r.Log.Error(err, "ignoring a malformed rebalance move", "service", service.Namespace+"/"+service.Name)
+			continue
+		}
+		move.service = types.NamespacedName{Namespace: service.Namespace, Name: service.Name}
+		if r.pending == nil || move.Started.After(r.pending.Started) {
+			r.pending = move
+		}
+	}
+	if r.pending != nil {
+		r.Log.Info("Waiting for the egress VIP move started by the previous leader", "service", r.pending.service.String(),
+			"from", r.pending.From, "to", r.pending.To)
+	}
+	return nil
+}
+
+// recordPendingMove records the move in the annotation of the service, nil removes it
+func (r *Rebalancer) recordPendingMove(ctx context.Context, service types.NamespacedName, move *rebalanceMove) error {
+	var value *string
+	if move != nil {
+		data, err := json.Marshal(move)
+		if err != nil {
+			return err
+		}
+		encoded := string(data)
+		value = &encoded
+	}
+	// A null value removes the annotation
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]*string{haegressip.RebalanceMoveAnnotation: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	target := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: service.Namespace, Name: service.Name}}
+	return client.IgnoreNotFound(r.Patch(ctx, target, client.RawPatch(types.MergePatchType, patch)))
+}
+
+// completePendingMove forgets the move in progress and removes it from its service
+func (r *Rebalancer) completePendingMove(ctx context.Context) error {
+	service := r.pending.service
+	r.pending = nil
+	return r.recordPendingMove(ctx, service, nil)
+}
+
+// checkPendingMove returns true when the previous move is completed, that is when the
+// CiliumEgressGatewayPolicy follows the VIP on the new node, or when it has timed out
+func (r *Rebalancer) checkPendingMove(ctx context.Context) (bool, error) {
+	policy := &haegressv2.HAEgressGatewayPolicy{}
+	if err := r.Get(ctx, types.NamespacedName{Name: r.pending.Policy}, policy); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			return false, err
+		}
+		return true, r.completePendingMove(ctx)
+	}
+	service := &corev1.Service{}
+	if err := r.Get(ctx, r.pending.service, service); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			return false, err
+		}
+		r.pending = nil
+		return true, nil
+	}
+
+	if r.Provider.CurrentNode(service) == r.pending.To && haegressiputil.PolicyExitNode(policy, service) == r.pending.To {
+		r.Log.Info("Egress VIP moved", "service", r.pending.service.String(), "node", r.pending.To,
+			"duration", time.Since(r.pending.Started).String())
+		return true, r.completePendingMove(ctx)
+	}
+	if time.Since(r.pending.Started) > haegressip.VIPMoveTimeout {
+		r.Log.Info("Egress VIP move timed out", "service", r.pending.service.String(), "node", r.pending.To)
+		r.Recorder.Event(policy, corev1.EventTypeWarning, haegressip.EventRebalanceReason,
+			fmt.Sprintf("Move of the VIP of service %s to %s timed out", r.pending.service.String(), r.pending.To))
+		return true, r.completePendingMove(ctx)
+	}
+	return false, nil
+}
+
+// SetupWithManager adds the rebalancer to the Manager.
+func (r *Rebalancer) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(r)
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestRebalancerPendingMove(t *testing.T) {
+	// node-a holds every VIP, node-b none
+	objects := []client.Object{testNode("node-a", "zone-a"), testNode("node-b", "zone-b")}
+	services := []*corev1.Service{}
+	for name, ip := range map[string]string{"billing": "10.0.0.1", "orders": "10.0.0.2", "payments": "10.0.0.3"} {
+		policy := testPolicy(name)
+		policy.Status.ExitNode = "node-a"
+		service := testService(policy, ip, "node-a")
+		services = append(services, service)
+		objects = append(objects, policy, service, testLease(service, "node-a"))
+	}
+	c := newTestClient(objects...)
+	newRebalancer := func() *Rebalancer {
+		return &Rebalancer{Client: c, Log: ctrl.Log, Recorder: record.NewFakeRecorder(10), Provider: provider.NewKubeVIP(c)}
+	}
+	moving := func() []string {
+		names := []string{}
+		for _, service := range services {
+			current := &corev1.Service{}
+			if err := c.Get(context.Background(), client.ObjectKeyFromObject(service), current); err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if _, ok := current.Annotations[haegressip.RebalanceMoveAnnotation]; ok {
+				names = append(names, current.Name)
+			}
+		}
+		return names
+	}
+
+	if err := newRebalancer().rebalance(context.Background()); err != nil {
+		t.Fatalf("rebalance() error = %v", err)
+	}
+	recorded := moving()
+	if len(recorded) != 1 {
+		t.Fatalf("services with a recorded move = %v, want one", recorded)
+	}
+
+	// A new leader waits for the recorded move instead of starting another one
+	leader := newRebalancer()
+	if err := leader.rebalance(context.Background()); err != nil {
+		t.Fatalf("rebalance() error = %v", err)
+	}
+	if leader.pending == nil || leader.pending.service.Name != recorded[0] || leader.pending.To != "node-b" {
+		t.Fatalf("restored move = %+v, want %s to node-b", leader.pending, recorded[0])
+	}
+	leases := &coordinationv1.LeaseList{}
+	if err := c.List(context.Background(), leases); err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	handedOver := 0
+	for _, lease := range leases.Items {
+		if *lease.Spec.HolderIdentity == "node-b" {
+			handedOver++
+		}
+	}
+	if handedOver != 1 {
+		t.Errorf("Leases handed over = %d, want 1 while the move is pending", handedOver)
+	}
+
+	// Once the CiliumEgressGatewayPolicy follows the VIP the move is completed and forgotten
+	service := &corev1.Service{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "egress-system", Name: recorded[0]}, service); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	move := &rebalanceMove{}
+	if err := json.Unmarshal([]byte(service.Annotations[haegressip.RebalanceMoveAnnotation]), move); err != nil || move.From != "node-a" {
+		t.Errorf("recorded move = %+v, %v, want a move from node-a", move, err)
+	}
+	service.Annotations[haegressip.KubeVIPVipHostAnnotation] = "node-b"
+	if err := c.Update(context.Background(), service); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	policy := &haegressv2.HAEgressGatewayPolicy{}
+	if err := c.Get(context.Background(), client.ObjectKey{Name: recorded[0]}, policy); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	policy.Status.ExitNode = "node-b"
+	if err := c.Status().Update(context.Background(), policy); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if done, err := leader.checkPendingMove(context.Background()); err != nil || !done {
+		t.Fatalf("checkPendingMove() = %v, %v, want done", done, err)
+	}
+	if got := moving(); len(got) != 0 {
+		t.Errorf("services with a recorded move = %v after the move, want none", got)
+	}
+	if leader.pending != nil {
+		t.Errorf("pending move = %+v after the move, want none", leader.pending)
+	}
+}
@@ -0,0 +1,381 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/metrics"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	"github.com/go-logr/logr"
+	"hash/fnv"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"reflect"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReplicaSharding spreads the HAEgressGatewayPolicies, and their services, across the
+// operator replicas. Every replica renews a Lease while it runs, the replicas with a live
+// Lease are placed on a consistent-hash ring and each one reconciles the policies hashed to
+// it. When a replica joins or leaves, or stops renewing its Lease, only its share of the
+// policies moves and the replicas gaining policies reconcile them at once. The controllers
+// of the nodes, the rebalancer and the failback stay on the leader.
+//
+// The ownership of a policy is fenced by a per-policy Lease naming its holder, the replicas
+// only disagree on the ring while their views of the members differ. A replica reconciles a
+// policy only while it holds its Lease and its own Lease is live; the replica hashed to the
+// policy takes the Lease once the previous holder has released it, one renewal after it
+// stopped reconciling the policy, or once the holder is no longer a member. The policy Leases
+// are written only on handovers, the liveness of the holder is the one of its replica Lease.
+type ReplicaSharding struct {
+	client.Client
+	// Reader lists the Leases from the API server, the cache could hide a dead replica
+	Reader client.Reader
+	Log    logr.Logger
+	// Namespace of the Leases of the replicas
+	Namespace string
+	// Identity of the replica, the pod name
+	Identity string
+	// Instance identifies the operator instance, the replicas of other instances sharing the
+	// namespace are ignored
+	Instance string
+	// LeaseDuration is how long a replica is a member without renewing its Lease
+	LeaseDuration time.Duration
+	// RenewInterval between two renewals of the Lease
+	RenewInterval time.Duration
+	// VirtualNodes is the number of points of a replica on the ring
+	VirtualNodes int
+
+	mu          sync.Mutex
+	ready       bool
+	members     []string
+	ring        *haegressiputil.HashRing
+	renewed     time.Time
+	owned       map[string]bool
+	subscribers []chan event.GenericEvent
+}
+
+// Owns returns true if the replica reconciles the policy, a nil sharding owns every policy.
+// Nothing is owned until the members are known, nor once the Lease of the replica has
+// expired: the other replicas may have taken its policies over.
+func (s *ReplicaSharding) Owns(policyName string) bool {
+	if s == nil {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ready && s.owned[policyName] && time.Since(s.renewed) < s.LeaseDuration
+}
+
+// Members returns the replicas sharing the policies
+func (s *ReplicaSharding) Members() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.members...)
+}
+
+// Subscribe returns a channel receiving the policies gained by the replica, it must be
+// called before the manager starts
+func (s *ReplicaSharding) Subscribe() <-chan event.GenericEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch := make(chan event.GenericEvent)
+	s.subscribers = append(s.subscribers, ch)
+	return ch
+}
+
+// ControllerOptions makes the controllers of the sharded objects run on every replica
+func (s *ReplicaSharding) ControllerOptions(options controller.Options) controller.Options {
+	if s != nil {
+		needLeaderElection := false
+		options.NeedLeaderElection = &needLeaderElection
+	}
+	return options
+}
+
+// +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch;create;update;delete
+
+// Start renews the Lease of the replica and follows the members until the context is
+// cancelled, then it releases the Lease. It implements manager.Runnable.
+func (s *ReplicaSharding) Start(ctx context.Context) error {
+	s.Log.Info("Starting replica sharding", "identity", s.Identity, "namespace", s.Namespace)
+	ticker := time.NewTicker(s.RenewInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.sync(ctx); err != nil {
+			s.Log.Error(err, "unable to update the replica sharding members")
+		}
+		select {
+		case <-ctx.Done():
+			s.release()
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection makes the sharding run on every replica
+func (s *ReplicaSharding) NeedLeaderElection() bool {
+	return false
+}
+
+func (s *ReplicaSharding) sync(ctx context.Context) error {
+	renewed := time.Now()
+	if err := s.renew(ctx, renewed); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.renewed = renewed
+	s.mu.Unlock()
+
+	leases := &coordinationv1.LeaseList{}
+	if err := s.Reader.List(ctx, leases, client.InNamespace(s.Namespace), client.MatchingLabels{haegressip.ReplicaShardingLabel: s.Instance}); err != nil {
+		return err
+	}
+	now := time.Now()
+	members := []string{}
+	for _, lease := range leases.Items {
+		if lease.Spec.HolderIdentity == nil || lease.Spec.RenewTime == nil {
+			continue
+		}
+		duration := s.LeaseDuration
+		if lease.Spec.LeaseDurationSeconds != nil {
+			duration = time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+		}
+		if *lease.Spec.HolderIdentity == s.Identity || lease.Spec.RenewTime.Add(duration).After(now) {
+			members = append(members, *lease.Spec.HolderIdentity)
+		}
+	}
+	sort.Strings(members)
+
+	s.mu.Lock()
+	changed := !s.ready || !reflect.DeepEqual(members, s.members)
+	if changed {
+		s.Log.Info("Replica sharding members changed", "members", members)
+		s.members = members
+		s.ring = haegressiputil.NewHashRing(members, s.VirtualNodes)
+		s.ready = true
+		metrics.ReplicaShardingMembers.Set(float64(len(members)))
+		metrics.ReplicaShardingRebalances.Inc()
+	}
+	s.mu.Unlock()
+
+	return s.updateOwned(ctx)
+}
+
+// updateOwned takes and releases the Leases of the policies, records the policies owned by
+// the replica and reconciles the gained ones
+func (s *ReplicaSharding) updateOwned(ctx context.Context) error {
+	policies := &haegressv2.HAEgressGatewayPolicyList{}
+	if err := s.List(ctx, policies); err != nil {
+		return err
+	}
+	leaseList := &coordinationv1.LeaseList{}
+	if err := s.Reader.List(ctx, leaseList, client.InNamespace(s.Namespace), client.MatchingLabels{haegressip.ReplicaShardingPolicyLabel: s.Instance}); err != nil {
+		return err
+	}
+	leases := map[string]*coordinationv1.Lease{}
+	for i := range leaseList.Items {
+		lease := &leaseList.Items[i]
+		leases[lease.Annotations[haegressip.HAEgressGatewayPolicyName]] = lease
+	}
+
+	s.mu.Lock()
+	members := map[string]bool{}
+	for _, member := range s.members {
+		members[member] = true
+	}
+	ring, previous := s.ring, s.owned
+	s.mu.Unlock()
+
+	owned := map[string]bool{}
+	gained := []client.Object{}
+	for i := range policies.Items {
+		policy := &policies.Items[i]
+		lease := leases[policy.Name]
+		delete(leases, policy.Name)
+
+		holder := ""
+		if lease != nil && lease.Spec.HolderIdentity != nil {
+			holder = *lease.Spec.HolderIdentity
+		}
+		if ring.Owner(policy.Name) != s.Identity {
+			// The policy is reconciled no more from now, the Lease is released at the next
+			// renewal so that the reconciles in progress end first
+			if holder == s.Identity && !previous[policy.Name] {
+				s.releasePolicyLease(ctx, lease)
+			}
+			continue
+		}
+		if holder != s.Identity {
+			if holder != "" && members[holder] {
+				// Still held by the previous owner, until it releases the Lease
+				continue
+			}
+			if err := s.acquirePolicyLease(ctx, policy.Name, lease); err != nil {
+				s.Log.V(1).Info("Unable to take the Lease of the policy", "policy", policy.Name, "holder", holder, "error", err.Error())
+				continue
+			}
+		}
+		owned[policy.Name] = true
+		if !previous[policy.Name] {
+			gained = append(gained, policy)
+			metrics.ReplicaShardingPolicy.WithLabelValues(policy.Name).Set(1)
+		}
+	}
+	for name := range previous {
+		if !owned[name] {
+			metrics.ReplicaShardingPolicy.DeleteLabelValues(name)
+		}
+	}
+	// The Leases of the deleted policies are removed by their holder, or by any replica
+	// once the holder is gone
+	for _, lease := range leases {
+		if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity == s.Identity || !members[*lease.Spec.HolderIdentity] {
+			s.releasePolicyLease(ctx, lease)
+		}
+	}
+
+	s.mu.Lock()
+	s.owned = owned
+	s.mu.Unlock()
+	metrics.ReplicaShardingOwnedPolicies.Set(float64(len(owned)))
+
+	for _, policy := range gained {
+		for _, subscriber := range s.subscribers {
+			select {
+			case subscriber <- event.GenericEvent{Object: policy}:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// acquirePolicyLease creates the Lease of the policy or takes it over from a released or gone
+// holder, the update fails if another replica changed it meanwhile
+func (s *ReplicaSharding) acquirePolicyLease(ctx context.Context, policyName string, lease *coordinationv1.Lease) error {
+	now := metav1.NewMicroTime(time.Now())
+	if lease == nil {
+		return s.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        policyLeaseName(policyName),
+				Namespace:   s.Namespace,
+				Labels:      map[string]string{haegressip.ReplicaShardingPolicyLabel: s.Instance},
+				Annotations: map[string]string{haegressip.HAEgressGatewayPolicyName: policyName},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity: &s.Identity,
+				AcquireTime:    &now,
+			},
+		})
+	}
+	lease = lease.DeepCopy()
+	lease.Spec.HolderIdentity = &s.Identity
+	lease.Spec.AcquireTime = &now
+	if lease.Spec.LeaseTransitions == nil {
+		lease.Spec.LeaseTransitions = new(int32)
+	}
+	*lease.Spec.LeaseTransitions++
+	return s.Update(ctx, lease)
+}
+
+// releasePolicyLease deletes the Lease of a policy unless it has changed since it was read
+func (s *ReplicaSharding) releasePolicyLease(ctx context.Context, lease *coordinationv1.Lease) {
+	if err := s.Delete(ctx, lease, client.Preconditions{ResourceVersion: &lease.ResourceVersion}); client.IgnoreNotFound(err) != nil && !apierrors.IsConflict(err) {
+		s.Log.Error(err, "unable to release the Lease of the policy", "lease", lease.Name)
+	}
+}
+
+// policyLeaseName returns the name of the Lease of a policy, the long names are truncated
+// and suffixed with their hash
+func policyLeaseName(policyName string) string {
+	name := haegressip.ReplicaShardingPolicyLeasePrefix + policyName
+	if len(name) > validation.DNS1123SubdomainMaxLength {
+		hash := fnv.New32a()
+		_, _ = hash.Write([]byte(policyName))
+		name = fmt.Sprintf("%s-%08x", strings.TrimRight(name[:validation.DNS1123SubdomainMaxLength-9], "-."), hash.Sum32())
+	}
+	return name
+}
+
+// renew creates or renews the Lease of the replica
+func (s *ReplicaSharding) renew(ctx context.Context, renewed time.Time) error {
+	now := metav1.NewMicroTime(renewed)
+	durationSeconds := int32(s.LeaseDuration.Seconds())
+	lease := &coordinationv1.Lease{}
+	err := s.Reader.Get(ctx, types.NamespacedName{Namespace: s.Namespace, Name: s.leaseName()}, lease)
+	if apierrors.IsNotFound(err) {
+		return s.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      s.leaseName(),
+				Namespace: s.Namespace,
+				Labels:    map[string]string{haegressip.ReplicaShardingLabel: s.Instance},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &s.Identity,
+				LeaseDurationSeconds: &durationSeconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		})
+	}
+	if err != nil {
+		return err
+	}
+	if lease.Labels == nil {
+		lease.Labels = map[string]string{}
+	}
+	lease.Labels[haegressip.ReplicaShardingLabel] = s.Instance
+	lease.Spec.HolderIdentity = &s.Identity
+	lease.Spec.LeaseDurationSeconds = &durationSeconds
+	lease.Spec.RenewTime = &now
+	return s.Update(ctx, lease)
+}
+
+// release deletes the Lease, so that the other replicas take over the policies at once
+func (s *ReplicaSharding) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	lease := &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Namespace: s.Namespace, Name: s.leaseName()}}
+	if err := s.Delete(ctx, lease); client.IgnoreNotFound(err) != nil {
+		s.Log.Error(err, "unable to release the replica sharding Lease")
+	}
+}
+
+func (s *ReplicaSharding) leaseName() string {
+	return haegressip.ReplicaShardingLeasePrefix + s.Identity
+}
+
+// SetupWithManager adds the sharding to the Manager.
+func (s *ReplicaSharding) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(s)
+}
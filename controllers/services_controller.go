@@ -3,9 +3,10 @@ package controllers
 import (
 	"context"
 	"fmt"
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
 	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
 	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
-	"github.com/cilium/cilium/pkg/hubble/relay/defaults"
 	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
@@ -13,8 +14,17 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
+	"reflect"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/ratelimiter"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 type ServicesController struct {
@@ -24,6 +34,17 @@ type ServicesController struct {
 	Recorder        record.EventRecorder
 	CiliumNamespace string
 	EgressNamespace string
+	Dampening       *haegressiputil.Dampening
+	Provider        provider.Provider
+	Cilium          *CiliumDiscovery
+	// RateLimiter retries the failed reconciles, nil uses the controller-runtime default
+	RateLimiter ratelimiter.RateLimiter
+	// MaxConcurrentReconciles is the number of services followed in parallel after a failover
+	MaxConcurrentReconciles int
+	// Failovers prioritizes the services whose VIP has moved, nil disables it
+	Failovers *FailoverQueue
+	// Sharding spreads the policies, and their services, across the replicas
+	Sharding *ReplicaSharding
 }
 
 // Reconcile handles a reconciliation request for a Lease with the
@@ -35,6 +56,51 @@ type ServicesController struct {
 // +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 func (r *ServicesController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	// The moves of the policies with a higher priority class are followed first
+	key := req.String()
+	if delay := r.Failovers.Defer(key); delay > 0 {
+		return ctrl.Result{RequeueAfter: delay}, nil
+	}
+	result, err := r.reconcileService(ctx, req)
+	if err != nil || !r.Failovers.Pending(key) {
+		return result, err
+	}
+	switch {
+	case r.vipFollowed(ctx, req):
+		r.Failovers.Followed(key)
+	case result.IsZero():
+		// The service is gone, not owned any more or its IP is contested, the move is not followed
+		r.Failovers.Forget(key)
+	}
+	return result, nil
+}
+
+// vipFollowed returns true if the CiliumEgressGatewayPolicy of the service points to the node
+// holding its VIP
+func (r *ServicesController) vipFollowed(ctx context.Context, req ctrl.Request) bool {
+	service := &corev1.Service{}
+	if err := r.Get(ctx, req.NamespacedName, service); err != nil {
+		return false
+	}
+	ciliumEgressGatewayPolicy := &ciliumv2.CiliumEgressGatewayPolicy{}
+	if err := r.Get(ctx, types.NamespacedName{Name: haegressiputil.CiliumEgressGatewayPolicyName(service.Namespace, service.Name)}, ciliumEgressGatewayPolicy); err != nil {
+		return false
+	}
+	currentHost := service.Annotations[haegressip.KubeVIPVipHostAnnotation]
+	return currentHost != "" && haegressiputil.CiliumEgressGatewayPolicyNode(ciliumEgressGatewayPolicy) == currentHost
+}
+
+// handleReconcileError drops the pending move of the service on a permanent error, it is not
+// followed until the error is fixed, and returns the result of the reconcile
+func (r *ServicesController) handleReconcileError(ctx context.Context, logger logr.Logger, req ctrl.Request, policyName string, err error) (ctrl.Result, error) {
+	if haegressiputil.ClassifyError(err) == haegressiputil.ErrorPermanent {
+		r.Failovers.Forget(req.String())
+	}
+	return haegressiputil.HandleReconcileError(ctx, r.Client, logger, policyName, err)
+}
+
+// reconcileService updates the CiliumEgressGatewayPolicies of a managed service
+func (r *ServicesController) reconcileService(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	var service = corev1.Service{}
 	var log = r.Log
 
@@ -43,10 +109,11 @@ func (r *ServicesController) Reconcile(ctx context.Context, req ctrl.Request) (c
 			// we'll ignore not-found errors, since they can't be fixed by an immediate
 			// requeue (we'll need to wait for a new notification), and we can get them
 			// on deleted requests.
+			r.Dampening.ForgetCiliumEgressGatewayPolicy(haegressiputil.CiliumEgressGatewayPolicyName(req.Namespace, req.Name))
 			return ctrl.Result{}, nil
 		}
 		log.Error(err, "unable to fetch the Service, check RBAC permissions")
-		return ctrl.Result{RequeueAfter: haegressip.HAEgressGatewayPolicyChcekRequeueAfter}, err
+		return ctrl.Result{}, err
 	}
 
 	logger := log.WithValues("namespace", service.Namespace, "service", service.Name)
@@ -56,28 +123,160 @@ func (r *ServicesController) Reconcile(ctx context.Context, req ctrl.Request) (c
 		return ctrl.Result{}, nil
 	}
 
+	// The policy may belong to another instance of the operator, with a different policy selector
+	policy := &haegressv2.HAEgressGatewayPolicy{}
+	if err := r.Get(ctx, types.NamespacedName{Name: service.Labels[haegressip.HAEgressGatewayPolicyName]}, policy); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.Dampening.Forget(service.Labels[haegressip.HAEgressGatewayPolicyName])
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	// The service is followed by the replica owning its policy
+	if !r.Sharding.Owns(policy.Name) {
+		return ctrl.Result{}, nil
+	}
+
+	// The CiliumEgressGatewayPolicy follows the VIP once its CRD is served
+	if !r.Cilium.Available() {
+		return ctrl.Result{RequeueAfter: haegressip.CiliumCheckRequeueAfter.Get()}, nil
+	}
+
 	// Update CiliumEgressGatewayPolicy with the LoadBalancerIP
 	ciliumEgressGatewayPolicy := &ciliumv2.CiliumEgressGatewayPolicy{}
-	err := r.Get(ctx, types.NamespacedName{Name: fmt.Sprintf("%s-%s",
+	err := r.Get(ctx, types.NamespacedName{Name: haegressiputil.CiliumEgressGatewayPolicyName(
 		service.Namespace, service.Name)}, ciliumEgressGatewayPolicy)
 
 	if err != nil {
 		if apierrors.IsNotFound(err) {
 			logger.Info(fmt.Sprintf("CiliumEgressGatewayPolicy %s-%s not found, we probably are waiting for automatic creation", service.Labels[haegressip.HAEgressGatewayPolicyNamespace], service.Labels[haegressip.HAEgressGatewayPolicyName]))
-			return ctrl.Result{RequeueAfter: defaults.HealthCheckInterval}, err
-		} else {
-			logger.Error(err, "unable to fetch the CiliumEgressGatewayPolicy, review RBAC permissions")
-			return ctrl.Result{}, err
+			return ctrl.Result{RequeueAfter: haegressip.CiliumCheckRequeueAfter.Get()}, nil
 		}
+		logger.Error(err, "unable to fetch the CiliumEgressGatewayPolicy, review RBAC permissions")
+		return r.handleReconcileError(ctx, logger, req, service.Labels[haegressip.HAEgressGatewayPolicyName], err)
 	}
 
-	return haegressiputil.SyncServiceWithCiliumEgressGatewayPolicy(ctx, r.Client, logger, r.Recorder, service, *ciliumEgressGatewayPolicy)
+	result, err := haegressiputil.SyncServiceWithCiliumEgressGatewayPolicy(ctx, r.Client, logger, r.Recorder, service, *ciliumEgressGatewayPolicy, r.Dampening, r.Provider)
+	if err != nil {
+		return r.handleReconcileError(ctx, logger, req, service.Labels[haegressip.HAEgressGatewayPolicyName], err)
+	}
+	return result, nil
+}
+
+// findManagedServicesSharingIPs maps a LoadBalancer service to the managed services that
+// request or hold one of its IPs, so that IP conflicts are re-evaluated when it changes
+func (r *ServicesController) findManagedServicesSharingIPs(ctx context.Context, obj client.Object) []reconcile.Request {
+	requests := []reconcile.Request{}
+
+	for _, ip := range haegressiputil.IndexServiceLoadBalancerIPs(obj) {
+		services := &corev1.ServiceList{}
+		if err := r.List(ctx, services, client.MatchingFields{haegressip.ServiceLoadBalancerIPIndex: ip}); err != nil {
+			r.Log.Error(err, "unable to list services by LoadBalancerIP", "LoadBalancerIP", ip)
+			continue
+		}
+		for _, service := range services.Items {
+			if service.Namespace == obj.GetNamespace() && service.Name == obj.GetName() {
+				continue
+			}
+			if service.Labels[haegressip.HAEgressGatewayPolicyName] == "" {
+				continue
+			}
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Name:      service.Name,
+					Namespace: service.Namespace,
+				},
+			})
+		}
+	}
+
+	return requests
+}
 
+// loadBalancerIPsChanged filters the events of the LoadBalancer services that can start or end
+// an IP conflict: created or deleted, or with new requested or assigned IPs
+func loadBalancerIPsChanged() predicate.Funcs {
+	hasIPs := func(obj client.Object) bool {
+		return len(haegressiputil.IndexServiceLoadBalancerIPs(obj)) > 0
+	}
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return hasIPs(e.Object)
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return hasIPs(e.Object)
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return !reflect.DeepEqual(haegressiputil.IndexServiceLoadBalancerIPs(e.ObjectOld), haegressiputil.IndexServiceLoadBalancerIPs(e.ObjectNew))
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	}
+}
+
+// recordMove records the VIP moves in the failover queue as soon as they are seen, before the
+// services wait in the workqueue. It never filters the events.
+func (r *ServicesController) recordMove(e event.UpdateEvent) bool {
+	oldService, oldOk := e.ObjectOld.(*corev1.Service)
+	newService, newOk := e.ObjectNew.(*corev1.Service)
+	if r.Failovers == nil || !oldOk || !newOk || newService.Labels[haegressip.HAEgressGatewayPolicyName] == "" ||
+		!r.Sharding.Owns(newService.Labels[haegressip.HAEgressGatewayPolicyName]) {
+		return true
+	}
+	currentHost := r.Provider.CurrentNode(newService)
+	if currentHost == "" || currentHost == r.Provider.CurrentNode(oldService) {
+		return true
+	}
+
+	// The policies of the other instances are not seen
+	policy := &haegressv2.HAEgressGatewayPolicy{}
+	if err := r.Get(context.Background(), types.NamespacedName{Name: newService.Labels[haegressip.HAEgressGatewayPolicyName]}, policy); err != nil {
+		return true
+	}
+	r.Failovers.Moved(types.NamespacedName{Namespace: newService.Namespace, Name: newService.Name}.String(), haegressiputil.PolicyPriorityClass(policy))
+	return true
+}
+
+// findServicesForPolicy maps a policy to its services, so that the services of the policies
+// gained from another replica are followed at once
+func (r *ServicesController) findServicesForPolicy(ctx context.Context, obj client.Object) []reconcile.Request {
+	requests := []reconcile.Request{}
+	services := &corev1.ServiceList{}
+	if err := r.List(ctx, services, client.MatchingLabels{haegressip.HAEgressGatewayPolicyName: obj.GetName()}); err != nil {
+		r.Log.Error(err, "unable to list the services of the HAEgressGatewayPolicy", "HAEgressGatewayPolicy", obj.GetName())
+		return requests
+	}
+	for _, service := range services.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{
+				Name:      service.Name,
+				Namespace: service.Namespace,
+			},
+		})
+	}
+	return requests
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ServicesController) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&corev1.Service{}).
-		Complete(r)
+	c, err := ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.Service{}, builder.WithPredicates(predicate.Funcs{UpdateFunc: r.recordMove})).
+		Watches(
+			&corev1.Service{},
+			handler.EnqueueRequestsFromMapFunc(r.findManagedServicesSharingIPs),
+			builder.WithPredicates(loadBalancerIPsChanged()),
+		).
+		WithOptions(r.Sharding.ControllerOptions(controller.Options{
+			RateLimiter:             r.RateLimiter,
+			MaxConcurrentReconciles: r.MaxConcurrentReconciles,
+		})).
+		Build(r)
+	if err != nil || r.Sharding == nil {
+		return err
+	}
+
+	// The services of the policies gained from a replica leaving the sharding are followed at once
+	return c.Watch(&source.Channel{Source: r.Sharding.Subscribe()}, handler.EnqueueRequestsFromMapFunc(r.findServicesForPolicy))
 }
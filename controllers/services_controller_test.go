@@ -0,0 +1,211 @@
+/*
+Copyright 2024 Angelo Conforti.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	haegressv2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestServicesControllerContestedIP(t *testing.T) {
+	// An unrelated LoadBalancer service already holding the IP wins the contention
+	holder := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "ingress",
+			Namespace:         "default",
+			CreationTimestamp: metav1.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+		},
+		Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+		Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{
+			Ingress: []corev1.LoadBalancerIngress{{IP: "10.0.0.10"}},
+		}},
+	}
+	tests := []struct {
+		name         string
+		contested    bool
+		egressIP     string
+		wantEgressIP string
+		wantNode     string
+		wantConflict metav1.ConditionStatus
+	}{
+		{
+			name:         "free IP is used and followed",
+			egressIP:     "",
+			wantEgressIP: "10.0.0.10",
+			wantNode:     "node-b",
+			wantConflict: metav1.ConditionFalse,
+		},
+		{
+			name:         "contested IP is removed and not followed",
+			contested:    true,
+			egressIP:     "10.0.0.10",
+			wantEgressIP: "",
+			wantNode:     "node-a",
+			wantConflict: metav1.ConditionTrue,
+		},
+		{
+			name:         "contested IP is never set",
+			contested:    true,
+			egressIP:     "",
+			wantEgressIP: "",
+			wantNode:     "node-a",
+			wantConflict: metav1.ConditionTrue,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := testPolicy("payments")
+			service := testService(policy, "10.0.0.10", "node-b")
+			if tt.contested {
+				// The service requests the IP but kube-vip did not assign it
+				service.Status.LoadBalancer.Ingress = nil
+			}
+			objects := []client.Object{policy, service, testNode("node-a", "zone-a"), testNode("node-b", "zone-b"),
+				testCiliumEgressGatewayPolicy(policy, service, tt.egressIP, "node-a")}
+			if tt.contested {
+				objects = append(objects, holder)
+			}
+			c := newTestClient(objects...)
+			r := &ServicesController{Client: c, Log: ctrl.Log, Scheme: c.Scheme(), Recorder: record.NewFakeRecorder(10)}
+
+			if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(service)}); err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+
+			ciliumEgressGatewayPolicy := &ciliumv2.CiliumEgressGatewayPolicy{}
+			if err := c.Get(context.Background(), types.NamespacedName{Name: haegressiputil.CiliumEgressGatewayPolicyName(service.Namespace, service.Name)}, ciliumEgressGatewayPolicy); err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if got := ciliumEgressGatewayPolicy.Spec.EgressGateway.EgressIP; got != tt.wantEgressIP {
+				t.Errorf("EgressIP = %q, want %q", got, tt.wantEgressIP)
+			}
+			if got := haegressiputil.CiliumEgressGatewayPolicyNode(ciliumEgressGatewayPolicy); got != tt.wantNode {
+				t.Errorf("gateway node = %q, want %q", got, tt.wantNode)
+			}
+
+			updated := &haegressv2.HAEgressGatewayPolicy{}
+			if err := c.Get(context.Background(), client.ObjectKeyFromObject(policy), updated); err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			condition := meta.FindStatusCondition(updated.Status.Conditions, haegressip.ConditionIPConflict)
+			if condition == nil || condition.Status != tt.wantConflict {
+				t.Errorf("IPConflict condition = %+v, want %s", condition, tt.wantConflict)
+			}
+		})
+	}
+}
+
+func TestServicesControllerFailovers(t *testing.T) {
+	resource := schema.GroupResource{Group: "cilium.io", Resource: "ciliumegressgatewaypolicies"}
+	tests := []struct {
+		name         string
+		deleted      bool
+		oldNodeReady bool
+		minDwell     time.Duration
+		getError     error
+		wantPending  bool
+		wantNode     string
+	}{
+		{
+			name:     "move is followed",
+			wantNode: "node-b",
+		},
+		{
+			name:         "dampened move is kept pending",
+			oldNodeReady: true,
+			minDwell:     time.Hour,
+			wantPending:  true,
+			wantNode:     "node-a",
+		},
+		{
+			name:    "deleted service is dropped",
+			deleted: true,
+		},
+		{
+			name:     "permanent error is dropped",
+			getError: apierrors.NewForbidden(resource, "egress-system-payments", nil),
+		},
+		{
+			name:        "transient error is kept pending",
+			getError:    apierrors.NewServerTimeout(resource, "get", 1),
+			wantPending: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := testPolicy("payments")
+			service := testService(policy, "10.0.0.10", "node-b")
+			objects := []client.Object{policy, testNode("node-b", "zone-b"),
+				testCiliumEgressGatewayPolicy(policy, service, "10.0.0.10", "node-a")}
+			if !tt.deleted {
+				objects = append(objects, service)
+			}
+			if tt.oldNodeReady {
+				objects = append(objects, testNode("node-a", "zone-a"))
+			}
+			builder := newTestClientBuilder(objects...)
+			if tt.getError != nil {
+				// The CiliumEgressGatewayPolicy cannot be read
+				builder = builder.WithInterceptorFuncs(interceptor.Funcs{Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+					if _, ok := obj.(*ciliumv2.CiliumEgressGatewayPolicy); ok {
+						return tt.getError
+					}
+					return c.Get(ctx, key, obj, opts...)
+				}})
+			}
+			c := builder.Build()
+			r := &ServicesController{Client: c, Log: ctrl.Log, Scheme: c.Scheme(), Recorder: record.NewFakeRecorder(10),
+				Dampening: &haegressiputil.Dampening{MinDwell: tt.minDwell},
+				Failovers: &FailoverQueue{MaxDeferral: time.Minute, RetryAfter: time.Second}}
+			req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(service)}
+			r.Failovers.Moved(req.String(), haegressiputil.PriorityClassNormal)
+
+			if _, err := r.Reconcile(context.Background(), req); err != nil && !tt.wantPending {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+			if got := r.Failovers.Pending(req.String()); got != tt.wantPending {
+				t.Errorf("Pending() = %v, want %v", got, tt.wantPending)
+			}
+			if tt.wantNode == "" {
+				return
+			}
+			ciliumEgressGatewayPolicy := &ciliumv2.CiliumEgressGatewayPolicy{}
+			if err := c.Get(context.Background(), types.NamespacedName{Name: haegressiputil.CiliumEgressGatewayPolicyName(service.Namespace, service.Name)}, ciliumEgressGatewayPolicy); err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if got := haegressiputil.CiliumEgressGatewayPolicyNode(ciliumEgressGatewayPolicy); got != tt.wantNode {
+				t.Errorf("gateway node = %q, want %q", got, tt.wantNode)
+			}
+		})
+	}
+}
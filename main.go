@@ -18,21 +18,30 @@ package main
 
 import (
 	"flag"
+	"net/http"
 	"os"
+	"time"
 
 	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
 	//log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/discovery"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
 	ciliumv1alpha1 "github.com/angeloxx/cilium-haegress-operator/api/v2"
 	"github.com/angeloxx/cilium-haegress-operator/controllers"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/config"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	haegressiputil "github.com/angeloxx/cilium-haegress-operator/util"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -43,11 +52,7 @@ var (
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
-	err := ciliumv2.AddToScheme(scheme)
-	if err != nil {
-		return
-	}
-
+	utilruntime.Must(ciliumv2.AddToScheme(scheme))
 	utilruntime.Must(ciliumv1alpha1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
@@ -60,12 +65,114 @@ func main() {
 	var loadBalancerClass string
 	var k8sClientQPS int
 	var k8sClientBurst int
+	var overlapDetection string
+	var rebalance bool
+	var rebalanceInterval time.Duration
+	var rebalanceMaxVIPsPerNode int
+	var rebalanceWindow string
+	var rebalanceDisruptionBudget int
+	var failbackInterval time.Duration
+	var moveHold time.Duration
+	var failoverMinDwell time.Duration
+	var failoverMaxMoves int
+	var failoverMovesWindow time.Duration
+	var maintenanceMigration bool
+	var drainGuard bool
+	var autoscalerProtection bool
+	var kubeVIPNamespace string
+	var kubeVIPSelector string
+	var selfCheck bool
+	var ciliumNamespace string
+	var ciliumConfigMap string
+	var retryBaseDelay time.Duration
+	var retryMaxDelay time.Duration
+	var retryJitter float64
+	var maxConcurrentReconciles int
+	var failoverMaxConcurrentReconciles int
+	var failoverPriority bool
+	var replicaSharding bool
+	var replicaShardingNamespace string
+	var replicaShardingIdentity string
+	var policySelector string
+	var serviceNamespaces string
+	var leaderElectionID string
+	var configFile string
+	var configReloadInterval time.Duration
 
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.StringVar(&haegressNamespace, "egress-default-namespace", "egress-system", "The namespace where the services will be created if no namespaces were specified")
 	flag.StringVar(&loadBalancerClass, "load-balancer-class", "kube-vip.io/kube-vip-class", "The LoadBalancer class to use for the services")
 
+	flag.StringVar(&overlapDetection, "overlap-detection", haegressip.OverlapDetectionManaged,
+		"Detect egress policies selecting the same pods for overlapping destinations: "+
+			"'disabled', 'managed' (HAEgressGatewayPolicies only) or 'all' (unmanaged CiliumEgressGatewayPolicies too)")
+	flag.BoolVar(&rebalance, "rebalance", false, "Spread the egress VIPs across the gateway nodes")
+	flag.DurationVar(&rebalanceInterval, "rebalance-interval", 5*time.Minute, "The interval between two evaluations of the VIP distribution")
+	flag.IntVar(&rebalanceMaxVIPsPerNode, "rebalance-max-vips-per-node", 0, "The maximum number of egress VIPs held by a node, 0 means no limit")
+	flag.StringVar(&rebalanceWindow, "rebalance-window", "", "The daily HH:MM-HH:MM window where the VIPs can be moved, empty means always")
+	flag.IntVar(&rebalanceDisruptionBudget, "rebalance-disruption-budget", 0,
+		"The maximum number of VIPs moved in a rebalance window (or in a day without window), 0 means no limit")
+	flag.DurationVar(&failbackInterval, "failback-interval", 30*time.Second,
+		"The interval between two evaluations of the preferred nodes of the policies")
+	flag.DurationVar(&moveHold, "move-hold", haegressip.DefaultMoveHold,
+		"How long the rebalancer and the failback leave a policy alone after a move requested with the move-to annotation, 0 disables the hold")
+	flag.DurationVar(&failoverMinDwell, "failover-min-dwell", 0,
+		"How long a VIP must stay on a new node before the CiliumEgressGatewayPolicy follows it, unless the previous node failed, below 2m")
+	flag.IntVar(&failoverMaxMoves, "failover-max-moves", 0,
+		"The maximum number of VIP moves followed in --failover-moves-window before the VIP is considered flapping, 0 means no limit")
+	flag.DurationVar(&failoverMovesWindow, "failover-moves-window", 10*time.Minute, "The window used to count the VIP moves")
+	flag.BoolVar(&maintenanceMigration, "maintenance-migration", true,
+		"Move the egress VIPs off the nodes that are cordoned or have the maintenance annotation or taint")
+	flag.StringVar(&haegressiputil.MaintenanceAnnotation, "maintenance-annotation", haegressip.DefaultMaintenanceAnnotation,
+		"The Node annotation marking a node going into maintenance, empty to disable")
+	flag.StringVar(&haegressiputil.MaintenanceTaint, "maintenance-taint", haegressip.DefaultMaintenanceTaint,
+		"The Node taint key marking a node going into maintenance, empty to disable")
+	flag.BoolVar(&drainGuard, "drain-guard", true,
+		"Protect the kube-vip pod of a node in maintenance with a PodDisruptionBudget until its egress VIPs have moved")
+	flag.BoolVar(&autoscalerProtection, "autoscaler-protection", true,
+		"Disable the cluster-autoscaler and Karpenter scale-down of the nodes holding egress VIPs")
+	flag.StringVar(&kubeVIPNamespace, "kube-vip-namespace", "kube-system", "The namespace of the kube-vip pods")
+	flag.StringVar(&kubeVIPSelector, "kube-vip-selector", "app.kubernetes.io/name=kube-vip-ds", "The label selector of the kube-vip pods")
+	flag.BoolVar(&selfCheck, "self-check", false,
+		"Check the Cilium, kube-vip, CRD and RBAC prerequisites at startup and log the problems found")
+	flag.StringVar(&ciliumNamespace, "cilium-namespace", "kube-system", "The namespace of the Cilium ConfigMap, used by the self-check")
+	flag.StringVar(&ciliumConfigMap, "cilium-configmap", "cilium-config", "The name of the Cilium ConfigMap, used by the self-check")
+	flag.DurationVar(&retryBaseDelay, "retry-base-delay", haegressip.DefaultRetryBaseDelay,
+		"The delay before retrying a reconcile failed with a transient error, doubled at every consecutive failure")
+	flag.DurationVar(&retryMaxDelay, "retry-max-delay", haegressip.DefaultRetryMaxDelay, "The maximum delay before retrying a failed reconcile")
+	flag.Float64Var(&retryJitter, "retry-jitter", haegressip.DefaultRetryJitter,
+		"The fraction of the retry delay randomly added to it, so that the failed reconciles are not retried together")
+	flag.Var(haegressip.PermanentErrorRequeueAfter, "permanent-error-retry-interval",
+		"The interval between two attempts of a reconcile failed with a permanent error, such as a forbidden or invalid request")
+	flag.Var(haegressip.LeaseCheckRequeueAfter, "lease-check-interval",
+		"The interval between two checks of a VIP being moved or of a node being evacuated")
+	flag.Var(haegressip.OverlapCheckRequeueAfter, "overlap-check-interval",
+		"The interval between two evaluations of the overlaps and of the zones of a policy")
+	flag.Var(haegressip.AffinityCheckRequeueAfter, "affinity-check-interval",
+		"The interval between two evaluations of the affinity rules of a policy")
+	flag.Var(haegressip.CiliumCheckRequeueAfter, "cilium-check-interval",
+		"The interval between two lookups of the CiliumEgressGatewayPolicy CRD while it is not served")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1, "The number of policies and nodes reconciled in parallel")
+	flag.IntVar(&failoverMaxConcurrentReconciles, "failover-max-concurrent-reconciles", 4,
+		"The number of services whose VIP move is followed in parallel, raise it with --k8s-client-qps for nodes holding many VIPs")
+	flag.BoolVar(&failoverPriority, "failover-priority", true,
+		"Follow the VIP moves before the routine reconciles, and the policies by their priority-class annotation")
+	flag.BoolVar(&replicaSharding, "replica-sharding", false,
+		"Spread the HAEgressGatewayPolicies across the replicas with a consistent hash, requires --leader-elect for the other controllers")
+	flag.StringVar(&replicaShardingNamespace, "replica-sharding-namespace", "",
+		"The namespace of the Leases of the replicas, empty uses --egress-default-namespace")
+	flag.StringVar(&replicaShardingIdentity, "replica-sharding-identity", "", "The identity of the replica, empty uses the hostname")
+	flag.StringVar(&policySelector, "policy-selector", "",
+		"The label selector of the HAEgressGatewayPolicies handled by this instance, empty handles every policy")
+	flag.StringVar(&serviceNamespaces, "service-namespaces", "",
+		"The comma separated namespaces of the services handled by this instance, empty handles every namespace")
+	flag.StringVar(&leaderElectionID, "leader-election-id", "",
+		"The leader election ID, empty derives it from --policy-selector and --service-namespaces")
+	flag.StringVar(&configFile, "config", "",
+		"The OperatorConfiguration file, its settings override the flags and are reloaded when it changes")
+	flag.DurationVar(&configReloadInterval, "config-reload-interval", haegressip.ConfigReloadInterval,
+		"The interval between two reads of the --config file")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
@@ -77,22 +184,107 @@ func main() {
 	opts.BindFlags(flag.CommandLine)
 	flag.Parse()
 
+	switch overlapDetection {
+	case haegressip.OverlapDetectionDisabled, haegressip.OverlapDetectionManaged, haegressip.OverlapDetectionAll:
+	default:
+		setupLog.Error(nil, "invalid --overlap-detection value", "value", overlapDetection)
+		os.Exit(1)
+	}
+	window, err := haegressiputil.ParseTimeWindow(rebalanceWindow)
+	if err != nil {
+		setupLog.Error(err, "invalid --rebalance-window value")
+		os.Exit(1)
+	}
+
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	// The configuration file overrides the flags, the flags are the defaults of the fields
+	// missing from it
+	configWatcher := &config.Watcher{
+		Path: configFile,
+		Defaults: &config.OperatorConfiguration{
+			EgressDefaultNamespace: haegressNamespace,
+			LoadBalancerClass:      loadBalancerClass,
+			Provider: config.ProviderConfiguration{
+				KubeVIP: config.KubeVIPConfiguration{Namespace: kubeVIPNamespace, Selector: kubeVIPSelector},
+			},
+			Requeue: config.RequeueConfiguration{
+				LeaseCheckInterval:     metav1.Duration{Duration: haegressip.LeaseCheckRequeueAfter.Get()},
+				OverlapCheckInterval:   metav1.Duration{Duration: haegressip.OverlapCheckRequeueAfter.Get()},
+				AffinityCheckInterval:  metav1.Duration{Duration: haegressip.AffinityCheckRequeueAfter.Get()},
+				CiliumCheckInterval:    metav1.Duration{Duration: haegressip.CiliumCheckRequeueAfter.Get()},
+				PermanentErrorInterval: metav1.Duration{Duration: haegressip.PermanentErrorRequeueAfter.Get()},
+			},
+			Backoff: config.BackoffConfiguration{
+				BaseDelay: metav1.Duration{Duration: retryBaseDelay},
+				MaxDelay:  metav1.Duration{Duration: retryMaxDelay},
+				Jitter:    retryJitter,
+			},
+			FailoverDampening: config.DampeningConfiguration{
+				MinDwell:    metav1.Duration{Duration: failoverMinDwell},
+				MaxMoves:    failoverMaxMoves,
+				MovesWindow: metav1.Duration{Duration: failoverMovesWindow},
+			},
+		},
+		Interval: configReloadInterval,
+		Log:      ctrl.Log.WithName("config"),
+	}
+	if err = configWatcher.Load(); err != nil {
+		setupLog.Error(err, "invalid configuration", "config", configFile)
+		os.Exit(1)
+	}
+	operatorConfig := configWatcher.Current()
+	haegressNamespace = operatorConfig.EgressDefaultNamespace
+	loadBalancerClass = operatorConfig.LoadBalancerClass
+	kubeVIPNamespace = operatorConfig.Provider.KubeVIP.Namespace
+	kubeVIPPodSelector, err := labels.Parse(operatorConfig.Provider.KubeVIP.Selector)
+	if err != nil {
+		setupLog.Error(err, "invalid kube-vip selector")
+		os.Exit(1)
+	}
+	scope, err := haegressiputil.ParseScope(policySelector, serviceNamespaces)
+	if err != nil {
+		setupLog.Error(err, "invalid --policy-selector value")
+		os.Exit(1)
+	}
+	if !scope.ServiceNamespaceAllowed(haegressNamespace) {
+		setupLog.Error(nil, "--service-namespaces must include --egress-default-namespace", "namespace", haegressNamespace)
+		os.Exit(1)
+	}
+	if leaderElectionID == "" {
+		leaderElectionID = scope.InstanceID()
+	}
+	if replicaSharding && !enableLeaderElection {
+		setupLog.Error(nil, "--replica-sharding requires --leader-elect, the nodes, rebalancer and failback controllers run on the leader")
+		os.Exit(1)
+	}
+
 	ctrl.Log.V(1).Info("Test debug")
 
-	config := ctrl.GetConfigOrDie()
-	config.QPS = float32(k8sClientQPS)
-	config.Burst = k8sClientBurst
+	restConfig := ctrl.GetConfigOrDie()
+	restConfig.QPS = float32(k8sClientQPS)
+	restConfig.Burst = k8sClientBurst
 
-	mgr, err := ctrl.NewManager(config, ctrl.Options{
+	// The leadership is served on the metrics endpoint, the checks are set up with the manager
+	health := &controllers.Health{
+		Log:          ctrl.Log.WithName("health"),
+		ListInterval: haegressip.HealthListInterval,
+		StallTimeout: haegressip.WorkqueueStallTimeout,
+	}
+	mgr, err := ctrl.NewManager(restConfig, ctrl.Options{
 		Scheme: scheme,
 		Metrics: metricsserver.Options{
 			BindAddress: metricsAddr,
+			ExtraHandlers: map[string]http.Handler{
+				"/debug/config": configWatcher,
+				"/debug/leader": health,
+			},
 		},
 		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "cilium-haegress-operator.angeloxx.ch",
+		// Several instances can share the cluster, each one sees its policies and services only
+		Cache:            scope.CacheOptions(),
+		LeaderElection:   enableLeaderElection,
+		LeaderElectionID: leaderElectionID,
 
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
@@ -111,41 +303,225 @@ func main() {
 		os.Exit(1)
 	}
 
+	ctx := ctrl.SetupSignalHandler()
+
+	vipProvider := provider.NewKubeVIP(mgr.GetClient())
+
+	// The settings reloaded from the configuration file are applied to the running
+	// controllers, the zero dampening follows every move immediately
+	dampening := &haegressiputil.Dampening{}
+	policyBackoff := haegressiputil.NewBackoff(retryBaseDelay, retryMaxDelay, retryJitter)
+	servicesBackoff := haegressiputil.NewBackoff(retryBaseDelay, retryMaxDelay, retryJitter)
+	nodesBackoff := haegressiputil.NewBackoff(retryBaseDelay, retryMaxDelay, retryJitter)
+	applyConfig := func(c *config.OperatorConfiguration) {
+		haegressip.LeaseCheckRequeueAfter.Store(c.Requeue.LeaseCheckInterval.Duration)
+		haegressip.OverlapCheckRequeueAfter.Store(c.Requeue.OverlapCheckInterval.Duration)
+		haegressip.AffinityCheckRequeueAfter.Store(c.Requeue.AffinityCheckInterval.Duration)
+		haegressip.CiliumCheckRequeueAfter.Store(c.Requeue.CiliumCheckInterval.Duration)
+		haegressip.PermanentErrorRequeueAfter.Store(c.Requeue.PermanentErrorInterval.Duration)
+		for _, backoff := range []*haegressiputil.Backoff{policyBackoff, servicesBackoff, nodesBackoff} {
+			backoff.Configure(c.Backoff.BaseDelay.Duration, c.Backoff.MaxDelay.Duration, c.Backoff.Jitter)
+		}
+		dampening.Configure(c.FailoverDampening.MinDwell.Duration, c.FailoverDampening.MaxMoves, c.FailoverDampening.MovesWindow.Duration)
+	}
+	applyConfig(operatorConfig)
+	configWatcher.OnChange(applyConfig)
+	if err = mgr.Add(configWatcher); err != nil {
+		setupLog.Error(err, "unable to add the configuration watcher")
+		os.Exit(1)
+	}
+
+	var failovers *controllers.FailoverQueue
+	if failoverPriority {
+		failovers = &controllers.FailoverQueue{
+			MaxDeferral: haegressip.FailoverMaxDeferral,
+			RetryAfter:  haegressip.FailoverDeferralRetry,
+		}
+	}
+
+	// With the replica sharding every replica reconciles its share of the policies
+	var sharding *controllers.ReplicaSharding
+	if replicaSharding {
+		if replicaShardingNamespace == "" {
+			replicaShardingNamespace = haegressNamespace
+		}
+		if replicaShardingIdentity == "" {
+			if replicaShardingIdentity, err = os.Hostname(); err != nil {
+				setupLog.Error(err, "unable to get the hostname, set --replica-sharding-identity")
+				os.Exit(1)
+			}
+		}
+		sharding = &controllers.ReplicaSharding{
+			Client:        mgr.GetClient(),
+			Reader:        mgr.GetAPIReader(),
+			Log:           ctrl.Log.WithName("controllers").WithName("ReplicaSharding"),
+			Namespace:     replicaShardingNamespace,
+			Identity:      replicaShardingIdentity,
+			Instance:      leaderElectionID,
+			LeaseDuration: haegressip.ReplicaShardingLeaseDuration,
+			RenewInterval: haegressip.ReplicaShardingRenewInterval,
+			VirtualNodes:  haegressip.ReplicaShardingVirtualNodes,
+		}
+		if err = sharding.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "ReplicaSharding")
+			os.Exit(1)
+		}
+	}
+
+	// The operator runs in a degraded mode until the CiliumEgressGatewayPolicy CRD is served
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(mgr.GetConfig())
+	if err != nil {
+		setupLog.Error(err, "unable to create the discovery client")
+		os.Exit(1)
+	}
+	ciliumDiscovery := &controllers.CiliumDiscovery{
+		Discovery: discoveryClient,
+		Log:       ctrl.Log.WithName("controllers").WithName("CiliumDiscovery"),
+		Interval:  haegressip.CiliumCheckRequeueAfter.Get(),
+	}
+	if err = ciliumDiscovery.Discover(); err != nil {
+		setupLog.Error(err, "unable to discover the CiliumEgressGatewayPolicy CRD")
+		os.Exit(1)
+	}
+	if err = ciliumDiscovery.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "CiliumDiscovery")
+		os.Exit(1)
+	}
+
+	if err = controllers.SetupIndexes(ctx, mgr); err != nil {
+		setupLog.Error(err, "unable to set up cache indexes")
+		os.Exit(1)
+	}
+
 	if err = (&controllers.HAEgressGatewayPolicyReconciler{
-		Client:            mgr.GetClient(),
-		Log:               ctrl.Log.WithName("controllers").WithName("HAEgressGatewayPolicy"),
-		Scheme:            mgr.GetScheme(),
-		Recorder:          mgr.GetEventRecorderFor("cilium-haegress-operator"),
-		EgressNamespace:   haegressNamespace,
-		LoadBalancerClass: loadBalancerClass,
+		Client:                  mgr.GetClient(),
+		Log:                     ctrl.Log.WithName("controllers").WithName("HAEgressGatewayPolicy"),
+		Scheme:                  mgr.GetScheme(),
+		Recorder:                mgr.GetEventRecorderFor("cilium-haegress-operator"),
+		EgressNamespace:         haegressNamespace,
+		LoadBalancerClass:       loadBalancerClass,
+		OverlapDetection:        overlapDetection,
+		Dampening:               dampening,
+		Provider:                vipProvider,
+		Cilium:                  ciliumDiscovery,
+		RateLimiter:             policyBackoff,
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+		Failovers:               failovers,
+		Sharding:                sharding,
+		Scope:                   scope,
+		Config:                  configWatcher,
+		MoveHold:                moveHold,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "HAEgressGatewayPolicy")
 		os.Exit(1)
 	}
 	if err = (&controllers.ServicesController{
+		Client:                  mgr.GetClient(),
+		Log:                     ctrl.Log.WithName("controllers").WithName("Services"),
+		Scheme:                  mgr.GetScheme(),
+		Recorder:                mgr.GetEventRecorderFor("cilium-haegress-operator"),
+		EgressNamespace:         haegressNamespace,
+		Dampening:               dampening,
+		Provider:                vipProvider,
+		Cilium:                  ciliumDiscovery,
+		RateLimiter:             servicesBackoff,
+		MaxConcurrentReconciles: failoverMaxConcurrentReconciles,
+		Failovers:               failovers,
+		Sharding:                sharding,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Services")
+		os.Exit(1)
+	}
+
+	if rebalance {
+		if err = (&controllers.Rebalancer{
+			Client:           mgr.GetClient(),
+			Log:              ctrl.Log.WithName("controllers").WithName("Rebalancer"),
+			Recorder:         mgr.GetEventRecorderFor("cilium-haegress-operator"),
+			Provider:         vipProvider,
+			Interval:         rebalanceInterval,
+			MaxVIPsPerNode:   rebalanceMaxVIPsPerNode,
+			Window:           window,
+			DisruptionBudget: rebalanceDisruptionBudget,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "Rebalancer")
+			os.Exit(1)
+		}
+	}
+
+	if err = (&controllers.NodesController{
+		Client:                  mgr.GetClient(),
+		Log:                     ctrl.Log.WithName("controllers").WithName("Nodes"),
+		Recorder:                mgr.GetEventRecorderFor("cilium-haegress-operator"),
+		Provider:                vipProvider,
+		Migration:               maintenanceMigration,
+		AutoscalerProtection:    autoscalerProtection,
+		DrainGuard:              drainGuard,
+		KubeVIPNamespace:        kubeVIPNamespace,
+		KubeVIPSelector:         kubeVIPPodSelector,
+		RateLimiter:             nodesBackoff,
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Nodes")
+		os.Exit(1)
+	}
+	if err = (&controllers.Failback{
 		Client:          mgr.GetClient(),
-		Log:             ctrl.Log.WithName("controllers").WithName("Services"),
-		Scheme:          mgr.GetScheme(),
+		Log:             ctrl.Log.WithName("controllers").WithName("Failback"),
 		Recorder:        mgr.GetEventRecorderFor("cilium-haegress-operator"),
+		Provider:        vipProvider,
 		EgressNamespace: haegressNamespace,
+		Interval:        failbackInterval,
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "Services")
+		setupLog.Error(err, "unable to create controller", "controller", "Failback")
 		os.Exit(1)
 	}
 
 	//+kubebuilder:scaffold:builder
 
-	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
-		setupLog.Error(err, "Unable to set up health check")
+	health.Reader = mgr.GetAPIReader()
+	health.Cache = mgr.GetCache()
+	if enableLeaderElection {
+		health.Elected = mgr.Elected()
+	}
+	if err := health.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "Unable to set up health checks")
 		os.Exit(1)
 	}
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+	if err := mgr.AddReadyzCheck("cilium", ciliumDiscovery.ReadyzCheck); err != nil {
 		setupLog.Error(err, "Unable to set up ready check")
 		os.Exit(1)
 	}
 
+	// The self-check uses a direct client, the cache is not started yet
+	if selfCheck {
+		checkClient, err := client.New(mgr.GetConfig(), client.Options{Scheme: mgr.GetScheme(), Mapper: mgr.GetRESTMapper()})
+		if err != nil {
+			setupLog.Error(err, "unable to create the self-check client")
+			os.Exit(1)
+		}
+		findings := haegressiputil.Doctor(ctx, checkClient, haegressiputil.DoctorOptions{
+			CiliumNamespace:   ciliumNamespace,
+			CiliumConfigMap:   ciliumConfigMap,
+			KubeVIPNamespace:  kubeVIPNamespace,
+			KubeVIPSelector:   kubeVIPPodSelector,
+			LoadBalancerClass: loadBalancerClass,
+		})
+		findings = append(findings, haegressiputil.CheckPermissions(ctx, checkClient)...)
+		for _, finding := range findings {
+			switch finding.Severity {
+			case haegressiputil.FindingOK:
+				setupLog.Info("self-check passed", "check", finding.Check, "finding", finding.Message)
+			case haegressiputil.FindingWarning:
+				setupLog.Info("self-check warning", "check", finding.Check, "finding", finding.Message, "hint", finding.Hint)
+			default:
+				setupLog.Error(nil, "self-check failed", "check", finding.Check, "finding", finding.Message, "hint", finding.Hint)
+			}
+		}
+	}
+
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	if err := mgr.Start(ctx); err != nil {
 		setupLog.Error(err, "Problem running manager")
 		os.Exit(1)
 	}
@@ -0,0 +1,324 @@
+package config
+
+import (
+	"fmt"
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"time"
+)
+
+const (
+	// APIVersion is the version of the configuration file
+	APIVersion = "config.cilium.angeloxx.ch/v1alpha1"
+	// Kind is the kind of the configuration file
+	Kind = "OperatorConfiguration"
+)
+
+// OperatorConfiguration is the configuration file of the operator. The fields missing from
+// the file keep the values of the command line flags. The rebalancer, failback, overlap
+// detection and maintenance settings are command line flags only.
+type OperatorConfiguration struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// EgressDefaultNamespace is the namespace of the services of the policies without
+	// namespace, a change requires a restart
+	EgressDefaultNamespace string `json:"egressDefaultNamespace"`
+	// LoadBalancerClass of the services, a change requires a restart
+	LoadBalancerClass string `json:"loadBalancerClass"`
+	// Provider configures the load balancer announcing the egress IPs, a change requires a
+	// restart
+	Provider ProviderConfiguration `json:"provider"`
+	// Requeue configures the periodic checks of the controllers
+	Requeue RequeueConfiguration `json:"requeue"`
+	// Backoff configures the retries of the failed reconciles
+	Backoff BackoffConfiguration `json:"backoff"`
+	// FailoverDampening configures the hysteresis of the CiliumEgressGatewayPolicies
+	// following the VIPs
+	FailoverDampening DampeningConfiguration `json:"failoverDampening"`
+	// Propagation configures the labels and annotations copied from the policies
+	Propagation PropagationConfiguration `json:"propagation"`
+	// ServiceTemplate customizes every Service generated by the operator
+	ServiceTemplate v2.ServiceTemplate `json:"serviceTemplate"`
+	// ServiceClasses are the Service templates selected by the policies with
+	// spec.serviceTemplate.className, applied after ServiceTemplate
+	ServiceClasses map[string]v2.ServiceTemplate `json:"serviceClasses,omitempty"`
+}
+
+// ProviderConfiguration configures the load balancer implementation
+type ProviderConfiguration struct {
+	KubeVIP KubeVIPConfiguration `json:"kubeVIP"`
+}
+
+// KubeVIPConfiguration finds the kube-vip pods
+type KubeVIPConfiguration struct {
+	Namespace string `json:"namespace"`
+	Selector  string `json:"selector"`
+}
+
+// RequeueConfiguration configures the periodic checks of the controllers
+type RequeueConfiguration struct {
+	LeaseCheckInterval     metav1.Duration `json:"leaseCheckInterval"`
+	OverlapCheckInterval   metav1.Duration `json:"overlapCheckInterval"`
+	AffinityCheckInterval  metav1.Duration `json:"affinityCheckInterval"`
+	CiliumCheckInterval    metav1.Duration `json:"ciliumCheckInterval"`
+	PermanentErrorInterval metav1.Duration `json:"permanentErrorInterval"`
+}
+
+// BackoffConfiguration configures the retries of the reconciles failed with a transient error
+type BackoffConfiguration struct {
+	BaseDelay metav1.Duration `json:"baseDelay"`
+	MaxDelay  metav1.Duration `json:"maxDelay"`
+	Jitter    float64         `json:"jitter"`
+}
+
+// DampeningConfiguration configures the failover dampening, zero values follow every move
+// immediately
+type DampeningConfiguration struct {
+	MinDwell    metav1.Duration `json:"minDwell"`
+	MaxMoves    int             `json:"maxMoves"`
+	MovesWindow metav1.Duration `json:"movesWindow"`
+}
+
+// PropagationConfiguration configures the labels and annotations copied from the policies to
+// the generated objects
+type PropagationConfiguration struct {
+	Service                   PropagationRules `json:"service"`
+	CiliumEgressGatewayPolicy PropagationRules `json:"ciliumEgressGatewayPolicy"`
+}
+
+// PropagationRules filters the labels and annotations copied to an object
+type PropagationRules struct {
+	Labels      FilterRules `json:"labels"`
+	Annotations FilterRules `json:"annotations"`
+}
+
+// FilterRules selects the labels or annotations copied to an object. A rule is a key, a key
+// prefix ending with '/' or a prefix ending with '*'. Without include rules every key not
+// excluded is copied.
+type FilterRules struct {
+	// Include copies only the matching keys
+	Include []string `json:"include,omitempty"`
+	// Exclude skips the matching keys, it wins over Include
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// WellKnownExclude are the keys of the tools applying the policies and of the operator, they
+// are not copied unless included by their exact key: the generated objects would look applied
+// or owned by the same tools, and the operator sets its own keys
+var WellKnownExclude = []string{
+	"kubectl.kubernetes.io/",
+	"argocd.argoproj.io/",
+	"app.kubernetes.io/instance",
+	"app.kubernetes.io/managed-by",
+	"meta.helm.sh/",
+	"kustomize.toolkit.fluxcd.io/",
+	"helm.toolkit.fluxcd.io/",
+	"cilium.angeloxx.ch/",
+}
+
+// loadBalancerStatusKeys are written by the load balancer and read by the operator, they are
+// never copied from the policies: the operator and the load balancer would overwrite each other
+var loadBalancerStatusKeys = []string{haegressip.KubeVIPVipHostAnnotation}
+
+// requiredAnnotations are always copied to the services, the load balancer needs them
+var requiredAnnotations = []string{haegressip.KubeVIPLoadBalancerIPsAnnotation}
+
+// Parse reads the configuration over the defaults and validates it
+func Parse(data []byte, defaults *OperatorConfiguration) (*OperatorConfiguration, error) {
+	typeMeta := metav1.TypeMeta{}
+	if err := yaml.Unmarshal(data, &typeMeta); err != nil {
+		return nil, err
+	}
+	if typeMeta.APIVersion != APIVersion || typeMeta.Kind != Kind {
+		return nil, fmt.Errorf("unsupported configuration %s, %s is expected", strings.TrimSpace(typeMeta.APIVersion+" "+typeMeta.Kind), APIVersion+" "+Kind)
+	}
+
+	config := defaults.DeepCopy()
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Validate returns the invalid fields of the configuration
+func (c *OperatorConfiguration) Validate() error {
+	errs := field.ErrorList{}
+	for _, msg := range validation.IsDNS1123Label(c.EgressDefaultNamespace) {
+		errs = append(errs, field.Invalid(field.NewPath("egressDefaultNamespace"), c.EgressDefaultNamespace, msg))
+	}
+	if c.LoadBalancerClass == "" {
+		errs = append(errs, field.Required(field.NewPath("loadBalancerClass"), ""))
+	}
+
+	kubeVIP := field.NewPath("provider", "kubeVIP")
+	for _, msg := range validation.IsDNS1123Label(c.Provider.KubeVIP.Namespace) {
+		errs = append(errs, field.Invalid(kubeVIP.Child("namespace"), c.Provider.KubeVIP.Namespace, msg))
+	}
+	if _, err := labels.Parse(c.Provider.KubeVIP.Selector); err != nil {
+		errs = append(errs, field.Invalid(kubeVIP.Child("selector"), c.Provider.KubeVIP.Selector, err.Error()))
+	}
+
+	requeue := field.NewPath("requeue")
+	for name, interval := range map[string]metav1.Duration{
+		"leaseCheckInterval":     c.Requeue.LeaseCheckInterval,
+		"overlapCheckInterval":   c.Requeue.OverlapCheckInterval,
+		"affinityCheckInterval":  c.Requeue.AffinityCheckInterval,
+		"ciliumCheckInterval":    c.Requeue.CiliumCheckInterval,
+		"permanentErrorInterval": c.Requeue.PermanentErrorInterval,
+	} {
+		if interval.Duration < time.Second {
+			errs = append(errs, field.Invalid(requeue.Child(name), interval.Duration.String(), "must be at least 1s"))
+		}
+	}
+
+	backoff := field.NewPath("backoff")
+	if c.Backoff.BaseDelay.Duration <= 0 {
+		errs = append(errs, field.Invalid(backoff.Child("baseDelay"), c.Backoff.BaseDelay.Duration.String(), "must be positive"))
+	}
+	if c.Backoff.MaxDelay.Duration < c.Backoff.BaseDelay.Duration {
+		errs = append(errs, field.Invalid(backoff.Child("maxDelay"), c.Backoff.MaxDelay.Duration.String(), "must not be below baseDelay"))
+	}
+	if c.Backoff.Jitter < 0 || c.Backoff.Jitter > 1 {
+		errs = append(errs, field.Invalid(backoff.Child("jitter"), c.Backoff.Jitter, "must be between 0 and 1"))
+	}
+
+	dampening := field.NewPath("failoverDampening")
+	if c.FailoverDampening.MinDwell.Duration < 0 {
+		errs = append(errs, field.Invalid(dampening.Child("minDwell"), c.FailoverDampening.MinDwell.Duration.String(), "must not be negative"))
+	}
+	// The failbacks and the rebalance moves are dampened too, they would time out before being followed
+	if c.FailoverDampening.MinDwell.Duration >= haegressip.VIPMoveTimeout {
+		errs = append(errs, field.Invalid(dampening.Child("minDwell"), c.FailoverDampening.MinDwell.Duration.String(),
+			fmt.Sprintf("must be below %s, the time given to a failback or a rebalance move to be followed", haegressip.VIPMoveTimeout)))
+	}
+	if c.FailoverDampening.MaxMoves < 0 {
+		errs = append(errs, field.Invalid(dampening.Child("maxMoves"), c.FailoverDampening.MaxMoves, "must not be negative"))
+	}
+	if c.FailoverDampening.MaxMoves > 0 && c.FailoverDampening.MovesWindow.Duration <= 0 {
+		errs = append(errs, field.Invalid(dampening.Child("movesWindow"), c.FailoverDampening.MovesWindow.Duration.String(), "must be positive with maxMoves"))
+	}
+
+	service := field.NewPath("propagation", "service")
+	errs = append(errs, c.Propagation.Service.Labels.validate(service.Child("labels"), nil)...)
+	errs = append(errs, c.Propagation.Service.Annotations.validate(service.Child("annotations"), requiredAnnotations)...)
+	ciliumEgressGatewayPolicy := field.NewPath("propagation", "ciliumEgressGatewayPolicy")
+	errs = append(errs, c.Propagation.CiliumEgressGatewayPolicy.Labels.validate(ciliumEgressGatewayPolicy.Child("labels"), nil)...)
+	errs = append(errs, c.Propagation.CiliumEgressGatewayPolicy.Annotations.validate(ciliumEgressGatewayPolicy.Child("annotations"), nil)...)
+
+	errs = append(errs, ValidateServiceTemplate(&c.ServiceTemplate, field.NewPath("serviceTemplate"))...)
+	for name, template := range c.ServiceClasses {
+		serviceClass := field.NewPath("serviceClasses").Key(name)
+		for _, msg := range validation.IsDNS1123Label(name) {
+			errs = append(errs, field.Invalid(serviceClass, name, msg))
+		}
+		errs = append(errs, ValidateServiceTemplate(&template, serviceClass)...)
+	}
+	return errs.ToAggregate()
+}
+
+// Propagated returns true if the key is copied: it must not match an exclude rule or a status
+// key of the load balancer nor, unless included by its exact key, a well-known one, and it must
+// match an include rule if any
+func (f FilterRules) Propagated(key string) bool {
+	if matchAny(f.Exclude, key) || matchAny(loadBalancerStatusKeys, key) {
+		return false
+	}
+	for _, rule := range f.Include {
+		if rule == key {
+			return true
+		}
+	}
+	if matchAny(WellKnownExclude, key) {
+		return false
+	}
+	return len(f.Include) == 0 || matchAny(f.Include, key)
+}
+
+func (f FilterRules) validate(path *field.Path, required []string) field.ErrorList {
+	errs := field.ErrorList{}
+	for i, rule := range f.Include {
+		if strings.TrimSuffix(rule, "*") == "" {
+			errs = append(errs, field.Invalid(path.Child("include").Index(i), rule, "must not be empty or match every key"))
+		}
+	}
+	for i, rule := range f.Exclude {
+		if strings.TrimSuffix(rule, "*") == "" {
+			errs = append(errs, field.Invalid(path.Child("exclude").Index(i), rule, "must not be empty or match every key"))
+			continue
+		}
+		for _, key := range required {
+			if matchKey(rule, key) {
+				errs = append(errs, field.Forbidden(path.Child("exclude").Index(i), fmt.Sprintf("%s is required by the operator", key)))
+			}
+		}
+	}
+	for _, key := range required {
+		if len(f.Include) > 0 && !matchAny(f.Include, key) {
+			errs = append(errs, field.Required(path.Child("include"), fmt.Sprintf("%s is required by the operator", key)))
+		}
+	}
+	return errs
+}
+
+// matchAny returns true if the key matches one of the rules
+func matchAny(rules []string, key string) bool {
+	for _, rule := range rules {
+		if matchKey(rule, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchKey matches a key with a rule: the rules ending with '*' match the keys with the
+// prefix, the rules ending with '/' match the keys of the prefix, other rules match the key
+func matchKey(rule string, key string) bool {
+	switch {
+	case strings.HasSuffix(rule, "*"):
+		return strings.HasPrefix(key, strings.TrimSuffix(rule, "*"))
+	case strings.HasSuffix(rule, "/"):
+		return strings.HasPrefix(key, rule)
+	default:
+		return key == rule
+	}
+}
+
+// DeepCopy returns a copy of the configuration
+func (c *OperatorConfiguration) DeepCopy() *OperatorConfiguration {
+	out := *c
+	out.Propagation.Service = c.Propagation.Service.DeepCopy()
+	out.Propagation.CiliumEgressGatewayPolicy = c.Propagation.CiliumEgressGatewayPolicy.DeepCopy()
+	c.ServiceTemplate.DeepCopyInto(&out.ServiceTemplate)
+	if c.ServiceClasses != nil {
+		out.ServiceClasses = make(map[string]v2.ServiceTemplate, len(c.ServiceClasses))
+		for name, template := range c.ServiceClasses {
+			out.ServiceClasses[name] = *template.DeepCopy()
+		}
+	}
+	return &out
+}
+
+// DeepCopy returns a copy of the rules
+func (p PropagationRules) DeepCopy() PropagationRules {
+	return PropagationRules{
+		Labels:      p.Labels.DeepCopy(),
+		Annotations: p.Annotations.DeepCopy(),
+	}
+}
+
+// DeepCopy returns a copy of the rules
+func (f FilterRules) DeepCopy() FilterRules {
+	return FilterRules{
+		Include: append([]string(nil), f.Include...),
+		Exclude: append([]string(nil), f.Exclude...),
+	}
+}
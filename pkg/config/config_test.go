@@ -0,0 +1,209 @@
+package config
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testDefaults() *OperatorConfiguration {
+	return &OperatorConfiguration{
+		EgressDefaultNamespace: "egress-system",
+		LoadBalancerClass:      "kube-vip.io/kube-vip-class",
+		Provider: ProviderConfiguration{
+			KubeVIP: KubeVIPConfiguration{Namespace: "kube-system", Selector: "app.kubernetes.io/name=kube-vip"},
+		},
+		Requeue: RequeueConfiguration{
+			LeaseCheckInterval:     metav1.Duration{Duration: 10 * time.Second},
+			OverlapCheckInterval:   metav1.Duration{Duration: time.Minute},
+			AffinityCheckInterval:  metav1.Duration{Duration: 30 * time.Second},
+			CiliumCheckInterval:    metav1.Duration{Duration: 30 * time.Second},
+			PermanentErrorInterval: metav1.Duration{Duration: 5 * time.Minute},
+		},
+		Backoff: BackoffConfiguration{
+			BaseDelay: metav1.Duration{Duration: time.Second},
+			MaxDelay:  metav1.Duration{Duration: 5 * time.Minute},
+			Jitter:    0.2,
+		},
+	}
+}
+
+func TestParse(t *testing.T) {
+	header := "apiVersion: config.cilium.angeloxx.ch/v1alpha1\nkind: OperatorConfiguration\n"
+	tests := []struct {
+		name    string
+		data    string
+		check   func(*OperatorConfiguration) bool
+		wantErr string
+	}{
+		{
+			name: "defaults kept",
+			data: header,
+			check: func(c *OperatorConfiguration) bool {
+				return c.EgressDefaultNamespace == "egress-system" && c.Backoff.Jitter == 0.2
+			},
+		},
+		{
+			name: "fields overridden",
+			data: header + "egressDefaultNamespace: egress\nfailoverDampening:\n  minDwell: 30s\n  maxMoves: 3\n  movesWindow: 10m\n",
+			check: func(c *OperatorConfiguration) bool {
+				return c.EgressDefaultNamespace == "egress" && c.FailoverDampening.MaxMoves == 3 &&
+					c.FailoverDampening.MinDwell.Duration == 30*time.Second && c.LoadBalancerClass == "kube-vip.io/kube-vip-class"
+			},
+		},
+		{
+			name: "nested field overridden",
+			data: header + "requeue:\n  overlapCheckInterval: 2m\n",
+			check: func(c *OperatorConfiguration) bool {
+				return c.Requeue.OverlapCheckInterval.Duration == 2*time.Minute && c.Requeue.LeaseCheckInterval.Duration == 10*time.Second
+			},
+		},
+		{name: "missing header", data: "loadBalancerClass: other\n", wantErr: "unsupported configuration"},
+		{name: "wrong version", data: "apiVersion: config.cilium.angeloxx.ch/v1\nkind: OperatorConfiguration\n", wantErr: "unsupported configuration"},
+		{name: "wrong kind", data: "apiVersion: config.cilium.angeloxx.ch/v1alpha1\nkind: Configuration\n", wantErr: "unsupported configuration"},
+		{name: "unknown field", data: header + "loadBalancerClas: other\n", wantErr: "unknown field"},
+		{name: "malformed", data: header + "requeue: [\n", wantErr: "yaml"},
+		{name: "invalid value", data: header + "backoff:\n  jitter: 2\n", wantErr: "backoff.jitter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaults := testDefaults()
+			config, err := Parse([]byte(tt.data), defaults)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if !tt.check(config) {
+				t.Errorf("Parse() = %+v", config)
+			}
+			if defaults.EgressDefaultNamespace != "egress-system" || defaults.FailoverDampening.MaxMoves != 0 {
+				t.Errorf("Parse() changed the defaults: %+v", defaults)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(*OperatorConfiguration)
+		wantErr []string
+	}{
+		{name: "defaults", change: func(c *OperatorConfiguration) {}},
+		{
+			name:    "invalid namespaces",
+			change:  func(c *OperatorConfiguration) { c.EgressDefaultNamespace, c.Provider.KubeVIP.Namespace = "Egress", "" },
+			wantErr: []string{"egressDefaultNamespace", "provider.kubeVIP.namespace"},
+		},
+		{
+			name:    "missing load balancer class",
+			change:  func(c *OperatorConfiguration) { c.LoadBalancerClass = "" },
+			wantErr: []string{"loadBalancerClass: Required"},
+		},
+		{
+			name:    "invalid selector",
+			change:  func(c *OperatorConfiguration) { c.Provider.KubeVIP.Selector = "app in (kube-vip" },
+			wantErr: []string{"provider.kubeVIP.selector"},
+		},
+		{
+			name:    "short interval",
+			change:  func(c *OperatorConfiguration) { c.Requeue.CiliumCheckInterval.Duration = 100 * time.Millisecond },
+			wantErr: []string{"requeue.ciliumCheckInterval", "must be at least 1s"},
+		},
+		{
+			name: "invalid backoff",
+			change: func(c *OperatorConfiguration) {
+				c.Backoff = BackoffConfiguration{BaseDelay: metav1.Duration{Duration: time.Minute}, MaxDelay: metav1.Duration{Duration: time.Second}, Jitter: -0.1}
+			},
+			wantErr: []string{"backoff.maxDelay", "backoff.jitter"},
+		},
+		{
+			name:    "max moves without window",
+			change:  func(c *OperatorConfiguration) { c.FailoverDampening.MaxMoves = 3 },
+			wantErr: []string{"failoverDampening.movesWindow"},
+		},
+		{
+			name: "negative dampening",
+			change: func(c *OperatorConfiguration) {
+				c.FailoverDampening.MinDwell.Duration, c.FailoverDampening.MaxMoves = -time.Second, -1
+			},
+			wantErr: []string{"failoverDampening.minDwell", "failoverDampening.maxMoves"},
+		},
+		{
+			name:    "dwell longer than a move",
+			change:  func(c *OperatorConfiguration) { c.FailoverDampening.MinDwell.Duration = 5 * time.Minute },
+			wantErr: []string{"failoverDampening.minDwell", "must be below 2m0s"},
+		},
+		{
+			name:    "rule matching every key",
+			change:  func(c *OperatorConfiguration) { c.Propagation.CiliumEgressGatewayPolicy.Labels.Include = []string{"*"} },
+			wantErr: []string{"propagation.ciliumEgressGatewayPolicy.labels.include[0]"},
+		},
+		{
+			name:    "required annotation excluded",
+			change:  func(c *OperatorConfiguration) { c.Propagation.Service.Annotations.Exclude = []string{"kube-vip.io/"} },
+			wantErr: []string{"propagation.service.annotations.exclude[0]", "is required by the operator"},
+		},
+		{
+			name:    "required annotation not included",
+			change:  func(c *OperatorConfiguration) { c.Propagation.Service.Annotations.Include = []string{"example.com/"} },
+			wantErr: []string{"propagation.service.annotations.include: Required"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testDefaults()
+			tt.change(config)
+			err := config.Validate()
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate() error = nil, want %q", tt.wantErr)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Validate() error = %v, want %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFilterRulesPropagated(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules FilterRules
+		key   string
+		want  bool
+	}{
+		{name: "no rules", key: "team", want: true},
+		{name: "well-known prefix", key: "argocd.argoproj.io/instance", want: false},
+		{name: "well-known key", key: "app.kubernetes.io/managed-by", want: false},
+		{name: "operator key", key: "cilium.angeloxx.ch/replicas", want: false},
+		{name: "load balancer status key", rules: FilterRules{Include: []string{"kube-vip.io/vipHost"}}, key: "kube-vip.io/vipHost", want: false},
+		{name: "well-known included by exact key", rules: FilterRules{Include: []string{"app.kubernetes.io/managed-by"}}, key: "app.kubernetes.io/managed-by", want: true},
+		{name: "well-known not included by prefix", rules: FilterRules{Include: []string{"app.kubernetes.io/"}}, key: "app.kubernetes.io/managed-by", want: false},
+		{name: "included by prefix", rules: FilterRules{Include: []string{"example.com/"}}, key: "example.com/team", want: true},
+		{name: "included by wildcard", rules: FilterRules{Include: []string{"example*"}}, key: "example.org/team", want: true},
+		{name: "not included", rules: FilterRules{Include: []string{"example.com/"}}, key: "team", want: false},
+		{name: "exclude wins over include", rules: FilterRules{Include: []string{"team"}, Exclude: []string{"team"}}, key: "team", want: false},
+		{name: "excluded by prefix", rules: FilterRules{Exclude: []string{"example.com/"}}, key: "example.com/team", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rules.Propagated(tt.key); got != tt.want {
+				t.Errorf("Propagated(%q) = %v, want %v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"net"
+)
+
+// reservedServiceKeys are the labels and annotations of the Services set by the operator or
+// by the load balancer and read by the operator, a Service template cannot change them
+var reservedServiceKeys = append([]string{
+	"cilium.angeloxx.ch/",
+	haegressip.KubernetesServiceProxyNameAnnotation,
+	haegressip.KubeVIPLoadBalancerIPsAnnotation,
+}, loadBalancerStatusKeys...)
+
+// ValidateServiceTemplate returns the invalid fields of a Service template
+func ValidateServiceTemplate(template *v2.ServiceTemplate, path *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	metadata := path.Child("metadata")
+	for key, value := range template.Metadata.Labels {
+		errs = append(errs, validateServiceKey(metadata.Child("labels").Key(key), key)...)
+		for _, msg := range validation.IsValidLabelValue(value) {
+			errs = append(errs, field.Invalid(metadata.Child("labels").Key(key), value, msg))
+		}
+	}
+	for key := range template.Metadata.Annotations {
+		errs = append(errs, validateServiceKey(metadata.Child("annotations").Key(key), key)...)
+	}
+
+	spec := path.Child("spec")
+	names := map[string]bool{}
+	for i, port := range template.Spec.Ports {
+		portPath := spec.Child("ports").Index(i)
+		if len(template.Spec.Ports) > 1 && port.Name == "" {
+			errs = append(errs, field.Required(portPath.Child("name"), "required with more ports"))
+		}
+		if port.Name != "" && names[port.Name] {
+			errs = append(errs, field.Duplicate(portPath.Child("name"), port.Name))
+		}
+		names[port.Name] = true
+		for _, msg := range validation.IsValidPortNum(int(port.Port)) {
+			errs = append(errs, field.Invalid(portPath.Child("port"), port.Port, msg))
+		}
+		switch port.Protocol {
+		case "", corev1.ProtocolTCP, corev1.ProtocolUDP, corev1.ProtocolSCTP:
+		default:
+			errs = append(errs, field.NotSupported(portPath.Child("protocol"), port.Protocol, []string{"TCP", "UDP", "SCTP"}))
+		}
+		// Every shard of a policy gets the ports, a fixed node port would collide
+		if port.NodePort != 0 {
+			errs = append(errs, field.Forbidden(portPath.Child("nodePort"), "the node ports are allocated by Kubernetes"))
+		}
+	}
+	for i, cidr := range template.Spec.LoadBalancerSourceRanges {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			errs = append(errs, field.Invalid(spec.Child("loadBalancerSourceRanges").Index(i), cidr, err.Error()))
+		}
+	}
+	return errs
+}
+
+// validateServiceKey checks a label or annotation key of a Service template
+func validateServiceKey(path *field.Path, key string) field.ErrorList {
+	errs := field.ErrorList{}
+	for _, msg := range validation.IsQualifiedName(key) {
+		errs = append(errs, field.Invalid(path, key, msg))
+	}
+	if matchAny(reservedServiceKeys, key) {
+		errs = append(errs, field.Forbidden(path, fmt.Sprintf("%s is set by the operator", key)))
+	}
+	return errs
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"testing"
+)
+
+func TestValidateServiceTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template v2.ServiceTemplate
+		wantErrs int
+	}{
+		{"empty", v2.ServiceTemplate{}, 0},
+		{
+			"load balancer options",
+			v2.ServiceTemplate{
+				Metadata: v2.ServiceTemplateMetadata{
+					Labels:      map[string]string{"example.com/team": "payments"},
+					Annotations: map[string]string{"kube-vip.io/egress": "false"},
+				},
+				Spec: v2.ServiceTemplateSpec{
+					Ports:                    []corev1.ServicePort{{Name: "dns", Protocol: corev1.ProtocolUDP, Port: 53}, {Name: "https", Port: 443}},
+					LoadBalancerSourceRanges: []string{"10.0.0.0/8"},
+				},
+			},
+			0,
+		},
+		{"operator label", v2.ServiceTemplate{Metadata: v2.ServiceTemplateMetadata{Labels: map[string]string{"cilium.angeloxx.ch/shard": "1"}}}, 1},
+		{"service proxy name", v2.ServiceTemplate{Metadata: v2.ServiceTemplateMetadata{Labels: map[string]string{"service.kubernetes.io/service-proxy-name": "x"}}}, 1},
+		{"requested IPs", v2.ServiceTemplate{Metadata: v2.ServiceTemplateMetadata{Annotations: map[string]string{"kube-vip.io/loadbalancerIPs": "10.0.0.1"}}}, 1},
+		{"kube-vip host", v2.ServiceTemplate{Metadata: v2.ServiceTemplateMetadata{Annotations: map[string]string{"kube-vip.io/vipHost": "node-1"}}}, 1},
+		{"invalid label value", v2.ServiceTemplate{Metadata: v2.ServiceTemplateMetadata{Labels: map[string]string{"team": "not valid"}}}, 1},
+		{"invalid key", v2.ServiceTemplate{Metadata: v2.ServiceTemplateMetadata{Annotations: map[string]string{"-bad": ""}}}, 1},
+		{"unnamed ports", v2.ServiceTemplate{Spec: v2.ServiceTemplateSpec{Ports: []corev1.ServicePort{{Port: 80}, {Name: "b", Port: 81}}}}, 1},
+		{"duplicate port names", v2.ServiceTemplate{Spec: v2.ServiceTemplateSpec{Ports: []corev1.ServicePort{{Name: "a", Port: 80}, {Name: "a", Port: 81}}}}, 1},
+		{"invalid port", v2.ServiceTemplate{Spec: v2.ServiceTemplateSpec{Ports: []corev1.ServicePort{{Port: 0}}}}, 1},
+		{"invalid protocol", v2.ServiceTemplate{Spec: v2.ServiceTemplateSpec{Ports: []corev1.ServicePort{{Port: 80, Protocol: "ICMP"}}}}, 1},
+		{"node port", v2.ServiceTemplate{Spec: v2.ServiceTemplateSpec{Ports: []corev1.ServicePort{{Port: 80, NodePort: 30080}}}}, 1},
+		{"invalid source range", v2.ServiceTemplate{Spec: v2.ServiceTemplateSpec{LoadBalancerSourceRanges: []string{"10.0.0.1"}}}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if errs := ValidateServiceTemplate(&tt.template, field.NewPath("serviceTemplate")); len(errs) != tt.wantErrs {
+				t.Errorf("ValidateServiceTemplate() = %v, want %d errors", errs, tt.wantErrs)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/metrics"
+	"github.com/go-logr/logr"
+	"net/http"
+	"os"
+	"sigs.k8s.io/yaml"
+	"sync"
+	"time"
+)
+
+// Watcher loads the configuration file and reloads it when it changes. The file is polled,
+// so that the atomic updates of a mounted ConfigMap are seen. An invalid file is rejected
+// and the previous configuration kept. The settings requiring a restart keep their value
+// until then.
+type Watcher struct {
+	// Path of the configuration file, empty uses the defaults only
+	Path string
+	// Defaults are the values of the fields missing from the file, from the command line flags
+	Defaults *OperatorConfiguration
+	// Interval between two reads of the file
+	Interval time.Duration
+	Log      logr.Logger
+
+	mu        sync.Mutex
+	current   *OperatorConfiguration
+	data      []byte
+	listeners []func(*OperatorConfiguration)
+}
+
+// Load reads the configuration for the first time, it fails when the file is invalid
+func (w *Watcher) Load() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.Path == "" {
+		w.current = w.Defaults.DeepCopy()
+		w.current.APIVersion, w.current.Kind = APIVersion, Kind
+		return w.current.Validate()
+	}
+	data, err := os.ReadFile(w.Path)
+	if err != nil {
+		return err
+	}
+	config, err := Parse(data, w.Defaults)
+	if err != nil {
+		return err
+	}
+	w.current, w.data = config, data
+	return nil
+}
+
+// Current returns the effective configuration, it must not be modified
+func (w *Watcher) Current() *OperatorConfiguration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.current
+}
+
+// OnChange registers a callback run with the new configuration after a reload
+func (w *Watcher) OnChange(listener func(*OperatorConfiguration)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.listeners = append(w.listeners, listener)
+}
+
+// Start polls the configuration file until the context is cancelled, it implements
+// manager.Runnable
+func (w *Watcher) Start(ctx context.Context) error {
+	if w.Path == "" {
+		return nil
+	}
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			w.reload()
+		}
+	}
+}
+
+// NeedLeaderElection makes every replica reload the configuration
+func (w *Watcher) NeedLeaderElection() bool {
+	return false
+}
+
+func (w *Watcher) reload() {
+	data, err := os.ReadFile(w.Path)
+	if err != nil {
+		w.Log.Error(err, "unable to read the configuration file", "path", w.Path)
+		metrics.ConfigReloads.WithLabelValues("failure").Inc()
+		return
+	}
+
+	w.mu.Lock()
+	if bytes.Equal(data, w.data) {
+		w.mu.Unlock()
+		return
+	}
+	config, err := Parse(data, w.Defaults)
+	if err != nil {
+		w.data = data
+		w.mu.Unlock()
+		w.Log.Error(err, "invalid configuration file, the previous configuration is kept", "path", w.Path)
+		metrics.ConfigReloads.WithLabelValues("failure").Inc()
+		return
+	}
+
+	// The objects built at startup keep the settings requiring a restart
+	restartRequired := restartRequiredChanges(w.current, config)
+	config.EgressDefaultNamespace = w.current.EgressDefaultNamespace
+	config.LoadBalancerClass = w.current.LoadBalancerClass
+	config.Provider = w.current.Provider
+	w.current, w.data = config, data
+	listeners := append([]func(*OperatorConfiguration){}, w.listeners...)
+	w.mu.Unlock()
+
+	if len(restartRequired) > 0 {
+		w.Log.Error(nil, "Settings changed in the configuration file are kept until the next restart", "path", w.Path, "fields", restartRequired)
+	}
+	w.Log.Info("Configuration reloaded", "path", w.Path)
+	metrics.ConfigReloads.WithLabelValues("success").Inc()
+	for _, listener := range listeners {
+		listener(config)
+	}
+}
+
+// restartRequiredChanges returns the fields changed in next that are applied only at the
+// next restart
+func restartRequiredChanges(current *OperatorConfiguration, next *OperatorConfiguration) []string {
+	changed := []string{}
+	if next.EgressDefaultNamespace != current.EgressDefaultNamespace {
+		changed = append(changed, "egressDefaultNamespace")
+	}
+	if next.LoadBalancerClass != current.LoadBalancerClass {
+		changed = append(changed, "loadBalancerClass")
+	}
+	if next.Provider != current.Provider {
+		changed = append(changed, "provider")
+	}
+	return changed
+}
+
+// ServeHTTP writes the effective configuration as YAML, for the debug endpoint
+func (w *Watcher) ServeHTTP(rw http.ResponseWriter, _ *http.Request) {
+	data, err := yaml.Marshal(w.Current())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/yaml")
+	_, _ = rw.Write(data)
+}
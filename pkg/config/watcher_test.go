@@ -0,0 +1,80 @@
+package config
+
+import (
+	"github.com/go-logr/logr"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWatcherReload(t *testing.T) {
+	header := "apiVersion: config.cilium.angeloxx.ch/v1alpha1\nkind: OperatorConfiguration\n"
+	tests := []struct {
+		name        string
+		data        string
+		wantApplied bool
+		check       func(*OperatorConfiguration) bool
+	}{
+		{
+			name:        "reloadable field applied",
+			data:        header + "requeue:\n  leaseCheckInterval: 20s\n",
+			wantApplied: true,
+			check: func(c *OperatorConfiguration) bool {
+				return c.Requeue.LeaseCheckInterval.Duration == 20*time.Second
+			},
+		},
+		{
+			name:        "restart field kept",
+			data:        header + "egressDefaultNamespace: egress\nloadBalancerClass: other\nrequeue:\n  leaseCheckInterval: 20s\n",
+			wantApplied: true,
+			check: func(c *OperatorConfiguration) bool {
+				return c.EgressDefaultNamespace == "egress-system" && c.LoadBalancerClass == "kube-vip.io/kube-vip-class" &&
+					c.Requeue.LeaseCheckInterval.Duration == 20*time.Second
+			},
+		},
+		{
+			name: "invalid file rejected",
+			data: header + "requeue:\n  leaseCheckInterval: -1s\n",
+			check: func(c *OperatorConfiguration) bool {
+				return c.Requeue.LeaseCheckInterval.Duration == 10*time.Second
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(header), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			w := &Watcher{Path: path, Defaults: testDefaults(), Log: logr.Discard()}
+			if err := w.Load(); err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			applied := false
+			w.OnChange(func(*OperatorConfiguration) { applied = true })
+
+			if err := os.WriteFile(path, []byte(tt.data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			w.reload()
+			if applied != tt.wantApplied {
+				t.Errorf("listener called = %v, want %v", applied, tt.wantApplied)
+			}
+			if !tt.check(w.Current()) {
+				t.Errorf("Current() = %+v", w.Current())
+			}
+		})
+	}
+}
+
+func TestRestartRequiredChanges(t *testing.T) {
+	next := testDefaults()
+	next.LoadBalancerClass = "other"
+	next.Provider.KubeVIP.Namespace = "kube-vip"
+	next.Backoff.Jitter = 0.5
+	if got, want := restartRequiredChanges(testDefaults(), next), []string{"loadBalancerClass", "provider"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("restartRequiredChanges() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,47 @@
+package haegressip
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Interval is a duration set by the command line flags or the configuration file, it can be
+// changed while the controllers read it
+type Interval struct {
+	d atomic.Int64
+}
+
+// NewInterval returns an Interval set to d
+func NewInterval(d time.Duration) *Interval {
+	i := &Interval{}
+	i.Store(d)
+	return i
+}
+
+// Get returns the duration
+func (i *Interval) Get() time.Duration {
+	return time.Duration(i.d.Load())
+}
+
+// Store changes the duration
+func (i *Interval) Store(d time.Duration) {
+	i.d.Store(int64(d))
+}
+
+// String implements flag.Value
+func (i *Interval) String() string {
+	if i == nil {
+		return "0s"
+	}
+	return i.Get().String()
+}
+
+// Set implements flag.Value
+func (i *Interval) Set(value string) error {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	i.Store(d)
+	return nil
+}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// VIPFlapping is 1 while the VIP of a CiliumEgressGatewayPolicy moves more often than
+	// allowed and the operator stops following it
+	VIPFlapping = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cilium_haegress_vip_flapping",
+		Help: "Whether the VIP followed by a CiliumEgressGatewayPolicy is flapping (1) or not (0)",
+	}, []string{"policy", "ciliumegressgatewaypolicy"})
+
+	// VIPMovesDampened counts the VIP moves not followed, or followed late, because of the
+	// failover dampening
+	VIPMovesDampened = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cilium_haegress_vip_moves_dampened_total",
+		Help: "Number of VIP moves delayed by the failover dampening",
+	}, []string{"policy", "ciliumegressgatewaypolicy", "reason"})
+
+	// FailoversPending is the number of moved VIPs not followed yet by their
+	// CiliumEgressGatewayPolicies
+	FailoversPending = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cilium_haegress_failovers_pending",
+		Help: "Number of VIP moves waiting to be followed by the CiliumEgressGatewayPolicies",
+	}, []string{"priority_class"})
+
+	// FailoverRecoverySeconds is the time from a VIP move to the end of its reconcile
+	FailoverRecoverySeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cilium_haegress_failover_recovery_seconds",
+		Help:    "Time from a VIP move to the CiliumEgressGatewayPolicy following it",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 12),
+	}, []string{"priority_class"})
+
+	// FailoverStormSeconds is the time from the first VIP move of a batch, for example the
+	// VIPs of a failed node, to the last one followed
+	FailoverStormSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "cilium_haegress_failover_storm_duration_seconds",
+		Help:    "Time to follow every VIP of a batch of moves, from the first move to the last one followed",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 12),
+	})
+
+	// FailoverStormSize is the number of VIPs moved in a batch
+	FailoverStormSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "cilium_haegress_failover_storm_size",
+		Help:    "Number of VIP moves followed in a batch",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 8),
+	})
+
+	// ReplicaShardingMembers is the number of operator replicas sharing the policies
+	ReplicaShardingMembers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "cilium_haegress_replica_sharding_members",
+		Help: "Number of operator replicas sharing the HAEgressGatewayPolicies",
+	})
+
+	// ReplicaShardingOwnedPolicies is the number of policies reconciled by this replica
+	ReplicaShardingOwnedPolicies = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "cilium_haegress_replica_sharding_owned_policies",
+		Help: "Number of HAEgressGatewayPolicies reconciled by this replica",
+	})
+
+	// ReplicaShardingPolicy is 1 for every policy reconciled by this replica
+	ReplicaShardingPolicy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cilium_haegress_replica_sharding_policy",
+		Help: "HAEgressGatewayPolicies reconciled by this replica (1)",
+	}, []string{"policy"})
+
+	// ReplicaShardingRebalances counts the changes of the replicas sharing the policies
+	ReplicaShardingRebalances = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "cilium_haegress_replica_sharding_rebalances_total",
+		Help: "Number of times the HAEgressGatewayPolicies have been redistributed across the replicas",
+	})
+
+	// ConfigReloads counts the reloads of the configuration file by result
+	ConfigReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cilium_haegress_config_reloads_total",
+		Help: "Number of reloads of the configuration file, by result (success or failure)",
+	}, []string{"result"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(VIPFlapping, VIPMovesDampened,
+		FailoversPending, FailoverRecoverySeconds, FailoverStormSeconds, FailoverStormSize,
+		ReplicaShardingMembers, ReplicaShardingOwnedPolicies, ReplicaShardingPolicy, ReplicaShardingRebalances,
+		ConfigReloads)
+}
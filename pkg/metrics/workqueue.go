@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// WorkqueueStats is the state of a controller workqueue read from its metrics
+type WorkqueueStats struct {
+	// Depth is the number of items waiting
+	Depth float64
+	// Done is the number of items processed so far
+	Done uint64
+	// LongestRunning is how long, in seconds, the oldest item in progress has been processed
+	LongestRunning float64
+}
+
+// Workqueues returns the state of the controller-runtime workqueues by name
+func Workqueues() (map[string]WorkqueueStats, error) {
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		return nil, err
+	}
+	queues := map[string]WorkqueueStats{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			name := ""
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "name" {
+					name = label.GetValue()
+				}
+			}
+			if name == "" {
+				continue
+			}
+			stats := queues[name]
+			switch family.GetName() {
+			case metrics.WorkQueueSubsystem + "_" + metrics.DepthKey:
+				stats.Depth = metric.GetGauge().GetValue()
+			case metrics.WorkQueueSubsystem + "_" + metrics.WorkDurationKey:
+				stats.Done = metric.GetHistogram().GetSampleCount()
+			case metrics.WorkQueueSubsystem + "_" + metrics.LongestRunningProcessorKey:
+				stats.LongestRunning = metric.GetGauge().GetValue()
+			default:
+				continue
+			}
+			queues[name] = stats
+		}
+	}
+	return queues, nil
+}
@@ -0,0 +1,106 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// KubeVIPLeasePrefix is the prefix of the Lease used by kube-vip to elect the node
+// announcing a service when service election is enabled
+const KubeVIPLeasePrefix = "kubevip-"
+
+// KubeVIP moves the VIPs announced by kube-vip with service election: the holder of the
+// service Lease is handed over to the target node, the kube-vip instance running there
+// finds itself as holder and starts announcing the VIP while the previous holder fails
+// to renew the Lease and stops. The kube-vip identity must be the node name, the default.
+//
+// The handover is not atomic: the previous holder keeps announcing the VIP until it tries
+// to renew the Lease, at most the kube-vip renew deadline, so both nodes can announce the
+// VIP for a few seconds. The CiliumEgressGatewayPolicy follows once the vipHost changes.
+type KubeVIP struct {
+	Client client.Client
+}
+
+// HolderIdentityError is returned when the holder of a kube-vip Lease is not a node name
+type HolderIdentityError struct {
+	Lease  string
+	Holder string
+}
+
+func (e *HolderIdentityError) Error() string {
+	return fmt.Sprintf("the kube-vip Lease %s is held by %q, which is not a node: the kube-vip identity must be the node name", e.Lease, e.Holder)
+}
+
+// NewKubeVIP returns a Provider for kube-vip
+func NewKubeVIP(c client.Client) *KubeVIP {
+	return &KubeVIP{Client: c}
+}
+
+func (k *KubeVIP) Name() string {
+	return "kube-vip"
+}
+
+func (k *KubeVIP) CurrentNode(service *corev1.Service) string {
+	return service.Annotations[haegressip.KubeVIPVipHostAnnotation]
+}
+
+func (k *KubeVIP) ElectedNode(ctx context.Context, service *corev1.Service) (string, error) {
+	lease := &coordinationv1.Lease{}
+	if err := k.Client.Get(ctx, types.NamespacedName{
+		Name:      KubeVIPLeasePrefix + service.Name,
+		Namespace: service.Namespace,
+	}, lease); err != nil {
+		return "", client.IgnoreNotFound(err)
+	}
+	if lease.Spec.HolderIdentity == nil {
+		return "", nil
+	}
+	return *lease.Spec.HolderIdentity, nil
+}
+
+func (k *KubeVIP) MoveVIP(ctx context.Context, service *corev1.Service, node string) error {
+	lease := &coordinationv1.Lease{}
+	if err := k.Client.Get(ctx, types.NamespacedName{
+		Name:      KubeVIPLeasePrefix + service.Name,
+		Namespace: service.Namespace,
+	}, lease); err != nil {
+		return fmt.Errorf("unable to get the kube-vip Lease of service %s/%s: %w", service.Namespace, service.Name, err)
+	}
+
+	if lease.Spec.HolderIdentity != nil && *lease.Spec.HolderIdentity == node {
+		return nil
+	}
+	// A holder that is not a node means kube-vip runs with another identity, the node name
+	// would never be renewed and the VIP would be announced by nobody
+	if lease.Spec.HolderIdentity != nil && *lease.Spec.HolderIdentity != "" {
+		holder := &corev1.Node{}
+		if err := k.Client.Get(ctx, types.NamespacedName{Name: *lease.Spec.HolderIdentity}, holder); err != nil {
+			if apierrors.IsNotFound(err) {
+				return &HolderIdentityError{Lease: lease.Namespace + "/" + lease.Name, Holder: *lease.Spec.HolderIdentity}
+			}
+			return fmt.Errorf("unable to get the holder of the kube-vip Lease of service %s/%s: %w", service.Namespace, service.Name, err)
+		}
+	}
+
+	now := metav1.NewMicroTime(metav1.Now().Time)
+	transitions := int32(1)
+	if lease.Spec.LeaseTransitions != nil {
+		transitions = *lease.Spec.LeaseTransitions + 1
+	}
+	lease.Spec.HolderIdentity = &node
+	lease.Spec.AcquireTime = &now
+	lease.Spec.RenewTime = &now
+	lease.Spec.LeaseTransitions = &transitions
+
+	if err := k.Client.Update(ctx, lease); err != nil {
+		return fmt.Errorf("unable to hand over the kube-vip Lease of service %s/%s to %s: %w", service.Namespace, service.Name, node, err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"testing"
+)
+
+func TestKubeVIPMoveVIP(t *testing.T) {
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "egress", Namespace: "egress-system"}}
+	lease := func(holder string) *coordinationv1.Lease {
+		lease := &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Name: KubeVIPLeasePrefix + "egress", Namespace: "egress-system"}}
+		if holder != "" {
+			lease.Spec.HolderIdentity = &holder
+		}
+		return lease
+	}
+	tests := []struct {
+		name         string
+		lease        *coordinationv1.Lease
+		wantHolder   string
+		wantErr      bool
+		wantIdentErr bool
+	}{
+		{name: "handed over", lease: lease("node-a"), wantHolder: "node-b"},
+		{name: "already held by the node", lease: lease("node-b"), wantHolder: "node-b"},
+		{name: "not held yet", lease: lease(""), wantHolder: "node-b"},
+		{name: "held by an identity that is not a node", lease: lease("kube-vip-7f9c"), wantHolder: "kube-vip-7f9c", wantErr: true, wantIdentErr: true},
+		{name: "no Lease", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := []client.Object{
+				&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}},
+				&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-b"}},
+			}
+			if tt.lease != nil {
+				objects = append(objects, tt.lease)
+			}
+			c := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(objects...).Build()
+			k := NewKubeVIP(c)
+
+			err := k.MoveVIP(context.Background(), service, "node-b")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MoveVIP() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var identityErr *HolderIdentityError
+			if errors.As(err, &identityErr) != tt.wantIdentErr {
+				t.Errorf("MoveVIP() error = %v, want a HolderIdentityError %v", err, tt.wantIdentErr)
+			}
+			if tt.lease == nil {
+				return
+			}
+			if got, err := k.ElectedNode(context.Background(), service); err != nil || got != tt.wantHolder {
+				t.Errorf("ElectedNode() = %q, %v, want %q", got, err, tt.wantHolder)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package provider
+
+import (
+	"context"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Provider moves the virtual IP of a LoadBalancer service between nodes, it abstracts the
+// load balancer implementation announcing the egress IPs
+type Provider interface {
+	// Name returns the name of the load balancer implementation
+	Name() string
+
+	// CurrentNode returns the node currently announcing the VIP of the service, empty if
+	// the VIP is not assigned yet
+	CurrentNode(service *corev1.Service) string
+
+	// ElectedNode returns the node elected to announce the VIP of the service, it differs
+	// from CurrentNode while a move is in progress, empty if no node is elected
+	ElectedNode(ctx context.Context, service *corev1.Service) (string, error)
+
+	// MoveVIP asks the load balancer implementation to move the VIP of the service to the
+	// node, the move is asynchronous and completes when CurrentNode returns the node
+	MoveVIP(ctx context.Context, service *corev1.Service, node string) error
+}
@@ -9,7 +9,129 @@ const (
 	EventEgressUpdateReason              = "Updated"
 	KubeVIPVipHostAnnotation             = "kube-vip.io/vipHost"
 	KubernetesServiceProxyNameAnnotation = "service.kubernetes.io/service-proxy-name"
+	KubeVIPLoadBalancerIPsAnnotation     = "kube-vip.io/loadbalancerIPs"
+	IPFamiliesAnnotation                 = "cilium.angeloxx.ch/ip-families"
+	IPFamilyPolicyAnnotation             = "cilium.angeloxx.ch/ip-family-policy"
+	ReplicasAnnotation                   = "cilium.angeloxx.ch/replicas"
+	ShardIndexLabel                      = "cilium.angeloxx.ch/shard"
+	ShardLabelPrefix                     = "shard.cilium.angeloxx.ch/"
+	PreferredNodesAnnotation             = "cilium.angeloxx.ch/preferred-nodes"
+	PreferredZoneAnnotation              = "cilium.angeloxx.ch/preferred-zone"
+	FailbackStableForAnnotation          = "cilium.angeloxx.ch/failback-stable-for"
+	FailbackWindowAnnotation             = "cilium.angeloxx.ch/failback-window"
+	ZoneAwareAnnotation                  = "cilium.angeloxx.ch/zone-aware"
+	EvacuateAnnotation                   = "cilium.angeloxx.ch/egress-evacuate"
+	MoveToAnnotation                     = "cilium.angeloxx.ch/move-to"
+	HoldUntilAnnotation                  = "cilium.angeloxx.ch/hold-until"
+	RebalanceMoveAnnotation              = "cilium.angeloxx.ch/rebalance-move"
+	PriorityClassAnnotation              = "cilium.angeloxx.ch/priority-class"
+	DefaultMaintenanceAnnotation         = "cilium.angeloxx.ch/maintenance"
+	DefaultMaintenanceTaint              = "cilium.angeloxx.ch/maintenance"
+	DrainGuardLabel                      = "cilium.angeloxx.ch/drain-guard"
+	DrainGuardPrefix                     = "haegress-drain-guard-"
+	ScaleDownDisabledAnnotation          = "cluster-autoscaler.kubernetes.io/scale-down-disabled"
+	KarpenterDoNotDisruptAnnotation      = "karpenter.sh/do-not-disrupt"
+	ScaleDownProtectedAnnotation         = "cilium.angeloxx.ch/scale-down-protected"
+	KarpenterDisruptionTaint             = "karpenter.sh/disruption"
+	KarpenterDisruptedTaint              = "karpenter.sh/disrupted"
+	ClusterAutoscalerToBeDeletedTaint    = "ToBeDeletedByClusterAutoscaler"
+	ReplicaShardingLabel                 = "cilium.angeloxx.ch/replica-sharding"
+	ReplicaShardingLeasePrefix           = "cilium-haegress-replica-"
+	ReplicaShardingPolicyLabel           = "cilium.angeloxx.ch/replica-sharding-policy"
+	ReplicaShardingPolicyLeasePrefix     = "cilium-haegress-policy-"
+	PropagatedLabelsAnnotation           = "cilium.angeloxx.ch/propagated-labels"
+	PropagatedAnnotationsAnnotation      = "cilium.angeloxx.ch/propagated-annotations"
 
-	LeaseCheckRequeueAfter                 = 10 * time.Second
-	HAEgressGatewayPolicyChcekRequeueAfter = 10 * time.Second
+	// MaxReplicas limits the number of egress IPs, services and policies generated per policy
+	MaxReplicas = 16
+
+	// ServiceLoadBalancerIPIndex indexes LoadBalancer services by requested and assigned IPs
+	ServiceLoadBalancerIPIndex = "loadBalancerIPs"
+
+	EventIPConflictReason = "IPConflict"
+	EventRebalanceReason  = "Rebalance"
+	EventFailbackReason   = "Failback"
+	EventAffinityReason   = "Affinity"
+	EventEvacuationReason = "Evacuation"
+	EventMoveReason       = "Move"
+	EventDrainGuardReason = "DrainGuard"
+	EventAnnotationReason = "InvalidAnnotation"
+
+	// ConditionIPConflict is set when another Service requests or holds the policy IP
+	ConditionIPConflict = "IPConflict"
+	// ConditionOverlapping is set when another policy selects the same pods for overlapping destinations
+	ConditionOverlapping = "Overlapping"
+	// ConditionPreferredNode reports whether the VIPs are held by the preferred nodes and why not
+	ConditionPreferredNode = "PreferredNode"
+	// ConditionFlapping is set when the VIPs move too often and the moves are not followed
+	ConditionFlapping = "Flapping"
+	// ConditionAffinityViolated is set when the egress IP sits on a node breaking the affinity rules
+	ConditionAffinityViolated = "AffinityViolated"
+	// ConditionMoved reports the outcome of the last manual move requested with the move-to annotation
+	ConditionMoved = "Moved"
+	// ConditionCiliumUnavailable is set while the CiliumEgressGatewayPolicy CRD is not served
+	ConditionCiliumUnavailable = "CiliumUnavailable"
+	// ConditionInvalidAnnotations is set when a tuning annotation is malformed and its default is used
+	ConditionInvalidAnnotations = "InvalidAnnotations"
+	// ConditionReconcileError is set when the policy cannot be reconciled without a change of
+	// the policy, of the RBAC rules or of the cluster
+	ConditionReconcileError = "ReconcileError"
+
+	OverlapDetectionDisabled = "disabled"
+	OverlapDetectionManaged  = "managed"
+	OverlapDetectionAll      = "all"
+
+	// DefaultRetryBaseDelay, DefaultRetryMaxDelay and DefaultRetryJitter configure the backoff
+	// of the reconciles failing with a transient error
+	DefaultRetryBaseDelay = 1 * time.Second
+	DefaultRetryMaxDelay  = 5 * time.Minute
+	DefaultRetryJitter    = 0.2
+
+	// FailoverMaxDeferral bounds how long a reconcile is postponed by more urgent failovers
+	FailoverMaxDeferral = 30 * time.Second
+	// FailoverDeferralRetry is the delay of the reconciles postponed by more urgent failovers
+	FailoverDeferralRetry = 500 * time.Millisecond
+
+	// ReplicaShardingLeaseDuration is how long a replica is a member of the replica sharding
+	// without renewing its Lease
+	ReplicaShardingLeaseDuration = 15 * time.Second
+	// ReplicaShardingRenewInterval is the interval between two renewals of the Lease of a replica
+	ReplicaShardingRenewInterval = 5 * time.Second
+	// ReplicaShardingVirtualNodes is the number of points of a replica on the hash ring
+	ReplicaShardingVirtualNodes = 64
+
+	// ConfigReloadInterval is the default interval between two reads of the configuration file
+	ConfigReloadInterval = 10 * time.Second
+
+	// HealthListInterval is the interval between two lists of the policies of the readiness check
+	HealthListInterval = 30 * time.Second
+	// WorkqueueStallTimeout is how long a workqueue can hold items without progress before the
+	// liveness check fails
+	WorkqueueStallTimeout = 5 * time.Minute
+
+	// VIPMoveTimeout is the time given to the CiliumEgressGatewayPolicy to follow a moved VIP
+	VIPMoveTimeout = 2 * time.Minute
+	// DefaultMoveHold is how long the rebalancer and the failback leave a policy alone after a
+	// move requested with the move-to annotation
+	DefaultMoveHold = 1 * time.Hour
+	// DefaultFailbackStableFor is how long a preferred node must be ready before failing back
+	DefaultFailbackStableFor = 5 * time.Minute
+	// PreferredZoneMargin is the share of pods another zone must run above the preferred zone
+	// of a zone-aware policy to replace it
+	PreferredZoneMargin = 0.2
+	// PreferredZoneStableFor is how long another zone must be elected before replacing the
+	// preferred zone of a zone-aware policy
+	PreferredZoneStableFor = 5 * time.Minute
+)
+
+// The periodic checks of the controllers, they are configured with the command line flags or
+// the configuration file
+var (
+	LeaseCheckRequeueAfter    = NewInterval(10 * time.Second)
+	OverlapCheckRequeueAfter  = NewInterval(60 * time.Second)
+	AffinityCheckRequeueAfter = NewInterval(30 * time.Second)
+	CiliumCheckRequeueAfter   = NewInterval(30 * time.Second)
+	// PermanentErrorRequeueAfter is the retry interval of the reconciles failing with a
+	// permanent error, such as a forbidden or invalid request
+	PermanentErrorRequeueAfter = NewInterval(5 * time.Minute)
 )
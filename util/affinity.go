@@ -0,0 +1,175 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+)
+
+// PlacementViolation is an affinity or anti-affinity rule broken by placing the egress IPs
+// of a policy on a node
+type PlacementViolation struct {
+	// Policy is the other HAEgressGatewayPolicy involved
+	Policy  string
+	Message string
+}
+
+// PolicyHasAffinity returns true if the policy declares affinity or anti-affinity rules
+func PolicyHasAffinity(haEgressGatewayPolicy *v2.HAEgressGatewayPolicy) bool {
+	affinity := haEgressGatewayPolicy.Spec.Affinity
+	return affinity != nil && (len(affinity.PolicyAffinity) > 0 || len(affinity.PolicyAntiAffinity) > 0)
+}
+
+// PolicyExitNodes returns the nodes holding the egress IPs of the policy, one per shard
+func PolicyExitNodes(haEgressGatewayPolicy *v2.HAEgressGatewayPolicy) []string {
+	nodes := []string{}
+	if haEgressGatewayPolicy.Status.ExitNode != "" {
+		nodes = append(nodes, haEgressGatewayPolicy.Status.ExitNode)
+	}
+	for _, shard := range haEgressGatewayPolicy.Status.Shards {
+		if shard.ExitNode != "" && !containsString(nodes, shard.ExitNode) {
+			nodes = append(nodes, shard.ExitNode)
+		}
+	}
+	return nodes
+}
+
+// PlacementViolations returns the rules of the policy broken when its egress IP is placed
+// on the node, given the other policies and the nodes indexed by name
+func PlacementViolations(haEgressGatewayPolicy *v2.HAEgressGatewayPolicy, node *corev1.Node, policies []v2.HAEgressGatewayPolicy, nodes map[string]*corev1.Node) []PlacementViolation {
+	violations := []PlacementViolation{}
+	if !PolicyHasAffinity(haEgressGatewayPolicy) {
+		return violations
+	}
+
+	for _, term := range haEgressGatewayPolicy.Spec.Affinity.PolicyAntiAffinity {
+		for _, other := range placedPolicies(haEgressGatewayPolicy, term, policies) {
+			for _, exitNode := range PolicyExitNodes(other) {
+				if sameTopologyDomain(node, nodes[exitNode], topologyKey(term)) {
+					violations = append(violations, PlacementViolation{
+						Policy:  other.Name,
+						Message: fmt.Sprintf("anti-affinity with %s on %s %s", other.Name, topologyKey(term), node.Labels[topologyKey(term)]),
+					})
+					break
+				}
+			}
+		}
+	}
+
+	for _, term := range haEgressGatewayPolicy.Spec.Affinity.PolicyAffinity {
+		others := placedPolicies(haEgressGatewayPolicy, term, policies)
+		if len(others) == 0 {
+			// Nothing to be close to yet
+			continue
+		}
+		satisfied := false
+		names := []string{}
+		for _, other := range others {
+			names = append(names, other.Name)
+			for _, exitNode := range PolicyExitNodes(other) {
+				if sameTopologyDomain(node, nodes[exitNode], topologyKey(term)) {
+					satisfied = true
+				}
+			}
+		}
+		if !satisfied {
+			sort.Strings(names)
+			violations = append(violations, PlacementViolation{
+				Policy:  names[0],
+				Message: fmt.Sprintf("affinity with %v on %s", names, topologyKey(term)),
+			})
+		}
+	}
+	return violations
+}
+
+// AllowedNodes returns the eligible gateway nodes of the policy where its egress IP can be
+// placed without breaking its affinity and anti-affinity rules
+func AllowedNodes(ctx context.Context, r client.Reader, haEgressGatewayPolicy *v2.HAEgressGatewayPolicy) ([]corev1.Node, error) {
+	eligible, err := EligibleNodes(ctx, r, haEgressGatewayPolicy)
+	if err != nil || !PolicyHasAffinity(haEgressGatewayPolicy) {
+		return eligible, err
+	}
+	policies, nodes, err := placementState(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	allowed := []corev1.Node{}
+	for i := range eligible {
+		if len(PlacementViolations(haEgressGatewayPolicy, &eligible[i], policies, nodes)) == 0 {
+			allowed = append(allowed, eligible[i])
+		}
+	}
+	return allowed, nil
+}
+
+// NodePlacementViolations returns the rules of the policy broken when its egress IP is
+// placed on the named node
+func NodePlacementViolations(ctx context.Context, r client.Reader, haEgressGatewayPolicy *v2.HAEgressGatewayPolicy, nodeName string) ([]PlacementViolation, error) {
+	if !PolicyHasAffinity(haEgressGatewayPolicy) {
+		return []PlacementViolation{}, nil
+	}
+	policies, nodes, err := placementState(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	node, ok := nodes[nodeName]
+	if !ok {
+		return []PlacementViolation{}, nil
+	}
+	return PlacementViolations(haEgressGatewayPolicy, node, policies, nodes), nil
+}
+
+func placementState(ctx context.Context, r client.Reader) ([]v2.HAEgressGatewayPolicy, map[string]*corev1.Node, error) {
+	policies := &v2.HAEgressGatewayPolicyList{}
+	if err := r.List(ctx, policies); err != nil {
+		return nil, nil, err
+	}
+	nodeList := &corev1.NodeList{}
+	if err := r.List(ctx, nodeList); err != nil {
+		return nil, nil, err
+	}
+	nodes := map[string]*corev1.Node{}
+	for i := range nodeList.Items {
+		nodes[nodeList.Items[i].Name] = &nodeList.Items[i]
+	}
+	return policies.Items, nodes, nil
+}
+
+// placedPolicies returns the other policies selected by the term
+func placedPolicies(haEgressGatewayPolicy *v2.HAEgressGatewayPolicy, term v2.PolicyAffinityTerm, policies []v2.HAEgressGatewayPolicy) []*v2.HAEgressGatewayPolicy {
+	selected := []*v2.HAEgressGatewayPolicy{}
+	selector, err := metav1.LabelSelectorAsSelector(term.LabelSelector)
+	if err != nil || term.LabelSelector == nil {
+		return selected
+	}
+	for i := range policies {
+		if policies[i].Name == haEgressGatewayPolicy.Name || !selector.Matches(labels.Set(policies[i].Labels)) {
+			continue
+		}
+		if len(PolicyExitNodes(&policies[i])) > 0 {
+			selected = append(selected, &policies[i])
+		}
+	}
+	return selected
+}
+
+func topologyKey(term v2.PolicyAffinityTerm) string {
+	if term.TopologyKey == "" {
+		return corev1.LabelHostname
+	}
+	return term.TopologyKey
+}
+
+func sameTopologyDomain(a *corev1.Node, b *corev1.Node, key string) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	value, ok := a.Labels[key]
+	return ok && value != "" && b.Labels[key] == value
+}
@@ -0,0 +1,93 @@
+package util
+
+import (
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"reflect"
+	"testing"
+)
+
+func TestPolicyExitNodes(t *testing.T) {
+	tests := []struct {
+		name   string
+		status v2.HAEgressGatewayPolicyStatus
+		want   []string
+	}{
+		{"not placed", v2.HAEgressGatewayPolicyStatus{}, []string{}},
+		{"single shard", v2.HAEgressGatewayPolicyStatus{ExitNode: "node-a"}, []string{"node-a"}},
+		{
+			name: "shards",
+			status: v2.HAEgressGatewayPolicyStatus{ExitNode: "node-a", Shards: []v2.HAEgressGatewayPolicyShardStatus{
+				{Shard: 0, ExitNode: "node-a"}, {Shard: 1, ExitNode: "node-b"}, {Shard: 2}, {Shard: 3, ExitNode: "node-b"},
+			}},
+			want: []string{"node-a", "node-b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &v2.HAEgressGatewayPolicy{Status: tt.status}
+			if got := PolicyExitNodes(policy); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PolicyExitNodes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlacementViolations(t *testing.T) {
+	node := func(name string, zone string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{
+			corev1.LabelHostname: name, corev1.LabelTopologyZone: zone,
+		}}}
+	}
+	nodes := map[string]*corev1.Node{
+		"node-a1": node("node-a1", "zone-a"),
+		"node-a2": node("node-a2", "zone-a"),
+		"node-b1": node("node-b1", "zone-b"),
+	}
+	placed := func(name string, team string, exitNode string) v2.HAEgressGatewayPolicy {
+		return v2.HAEgressGatewayPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"team": team}},
+			Status:     v2.HAEgressGatewayPolicyStatus{ExitNode: exitNode},
+		}
+	}
+	policies := []v2.HAEgressGatewayPolicy{
+		placed("payments", "payments", "node-a1"),
+		placed("billing", "billing", "node-b1"),
+		placed("reports", "reports", ""),
+	}
+	term := func(team string, topologyKey string) []v2.PolicyAffinityTerm {
+		return []v2.PolicyAffinityTerm{{
+			LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": team}},
+			TopologyKey:   topologyKey,
+		}}
+	}
+	tests := []struct {
+		name     string
+		affinity *v2.HAEgressGatewayPolicyAffinity
+		node     string
+		want     []string
+	}{
+		{"no rules", nil, "node-a1", []string{}},
+		{"anti-affinity on the same node", &v2.HAEgressGatewayPolicyAffinity{PolicyAntiAffinity: term("payments", "")}, "node-a1", []string{"payments"}},
+		{"anti-affinity on another node", &v2.HAEgressGatewayPolicyAffinity{PolicyAntiAffinity: term("payments", "")}, "node-a2", []string{}},
+		{"anti-affinity on the same zone", &v2.HAEgressGatewayPolicyAffinity{PolicyAntiAffinity: term("payments", corev1.LabelTopologyZone)}, "node-a2", []string{"payments"}},
+		{"affinity satisfied", &v2.HAEgressGatewayPolicyAffinity{PolicyAffinity: term("billing", corev1.LabelTopologyZone)}, "node-b1", []string{}},
+		{"affinity broken", &v2.HAEgressGatewayPolicyAffinity{PolicyAffinity: term("billing", corev1.LabelTopologyZone)}, "node-a1", []string{"billing"}},
+		{"affinity with a policy not placed", &v2.HAEgressGatewayPolicyAffinity{PolicyAffinity: term("reports", "")}, "node-a1", []string{}},
+		{"anti-affinity with itself", &v2.HAEgressGatewayPolicyAffinity{PolicyAntiAffinity: term("orders", "")}, "node-a1", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := placed("orders", "orders", "node-a1")
+			policy.Spec.Affinity = tt.affinity
+			got := []string{}
+			for _, violation := range PlacementViolations(&policy, nodes[tt.node], append(policies, policy), nodes) {
+				got = append(got, violation.Policy)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PlacementViolations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
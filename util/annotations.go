@@ -0,0 +1,51 @@
+package util
+
+import (
+	"fmt"
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InvalidAnnotations returns a message for every malformed tuning annotation of the policy,
+// the operator falls back to the default of these annotations and the message says which
+func InvalidAnnotations(haEgressGatewayPolicy *v2.HAEgressGatewayPolicy) []string {
+	invalid := []string{}
+	annotations := haEgressGatewayPolicy.Annotations
+	if value, ok := annotations[haegressip.ReplicasAnnotation]; ok {
+		if replicas, err := strconv.Atoi(value); err != nil || replicas < 1 || replicas > haegressip.MaxReplicas {
+			invalid = append(invalid, fmt.Sprintf("%s: %q is not a number between 1 and %d, %d is used",
+				haegressip.ReplicasAnnotation, value, haegressip.MaxReplicas, PolicyReplicas(haEgressGatewayPolicy)))
+		}
+	}
+	if value, ok := annotations[haegressip.FailbackStableForAnnotation]; ok {
+		if stableFor, err := time.ParseDuration(value); err != nil || stableFor < 0 {
+			invalid = append(invalid, fmt.Sprintf("%s: %q is not a positive duration, %s is used",
+				haegressip.FailbackStableForAnnotation, value, haegressip.DefaultFailbackStableFor))
+		}
+	}
+	if value, ok := annotations[haegressip.FailbackWindowAnnotation]; ok {
+		if _, err := ParseTimeWindow(value); err != nil {
+			invalid = append(invalid, fmt.Sprintf("%s: %s, the VIPs do not fail back", haegressip.FailbackWindowAnnotation, err))
+		}
+	}
+	if value, ok := annotations[haegressip.ZoneAwareAnnotation]; ok {
+		if _, err := strconv.ParseBool(value); err != nil {
+			invalid = append(invalid, fmt.Sprintf("%s: %q is not a boolean, false is used", haegressip.ZoneAwareAnnotation, value))
+		}
+	}
+	if value, ok := annotations[haegressip.PriorityClassAnnotation]; ok {
+		if class := PriorityClass(strings.ToLower(strings.TrimSpace(value))); class != PriorityClassNormal && PolicyPriorityClass(haEgressGatewayPolicy) == PriorityClassNormal {
+			invalid = append(invalid, fmt.Sprintf("%s: %q is not one of %s, %s, %s or %s, %s is used", haegressip.PriorityClassAnnotation, value,
+				PriorityClassCritical, PriorityClassHigh, PriorityClassNormal, PriorityClassLow, PriorityClassNormal))
+		}
+	}
+	if value, ok := annotations[haegressip.HoldUntilAnnotation]; ok {
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			invalid = append(invalid, fmt.Sprintf("%s: %q is not an RFC 3339 time, the policy is not held", haegressip.HoldUntilAnnotation, value))
+		}
+	}
+	return invalid
+}
@@ -0,0 +1,75 @@
+package util
+
+import (
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"reflect"
+	"testing"
+)
+
+func TestInvalidAnnotations(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        []string
+	}{
+		{
+			name:        "no annotations",
+			annotations: nil,
+			want:        []string{},
+		},
+		{
+			name: "valid",
+			annotations: map[string]string{
+				haegressip.ReplicasAnnotation:          "3",
+				haegressip.FailbackStableForAnnotation: "10m",
+				haegressip.FailbackWindowAnnotation:    "02:00-04:00",
+				haegressip.ZoneAwareAnnotation:         "true",
+				haegressip.PriorityClassAnnotation:     "Critical",
+				haegressip.HoldUntilAnnotation:         "2024-03-01T12:00:00Z",
+			},
+			want: []string{},
+		},
+		{
+			name:        "normal priority class",
+			annotations: map[string]string{haegressip.PriorityClassAnnotation: "normal"},
+			want:        []string{},
+		},
+		{
+			name:        "replicas not a number",
+			annotations: map[string]string{haegressip.ReplicasAnnotation: "two"},
+			want:        []string{`cilium.angeloxx.ch/replicas: "two" is not a number between 1 and 16, 1 is used`},
+		},
+		{
+			name:        "too many replicas",
+			annotations: map[string]string{haegressip.ReplicasAnnotation: "20"},
+			want:        []string{`cilium.angeloxx.ch/replicas: "20" is not a number between 1 and 16, 16 is used`},
+		},
+		{
+			name: "malformed",
+			annotations: map[string]string{
+				haegressip.FailbackStableForAnnotation: "-1m",
+				haegressip.FailbackWindowAnnotation:    "02:00",
+				haegressip.ZoneAwareAnnotation:         "yes",
+				haegressip.PriorityClassAnnotation:     "urgent",
+				haegressip.HoldUntilAnnotation:         "tomorrow",
+			},
+			want: []string{
+				`cilium.angeloxx.ch/failback-stable-for: "-1m" is not a positive duration, 5m0s is used`,
+				`cilium.angeloxx.ch/failback-window: invalid time window "02:00", expected HH:MM-HH:MM, the VIPs do not fail back`,
+				`cilium.angeloxx.ch/zone-aware: "yes" is not a boolean, false is used`,
+				`cilium.angeloxx.ch/priority-class: "urgent" is not one of critical, high, normal or low, normal is used`,
+				`cilium.angeloxx.ch/hold-until: "tomorrow" is not an RFC 3339 time, the policy is not held`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &v2.HAEgressGatewayPolicy{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations}}
+			if got := InvalidAnnotations(policy); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("InvalidAnnotations() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package util
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Backoff is a per-item exponential rate limiter with jitter for the controller workqueues:
+// the n-th consecutive failure of an item is retried after BaseDelay*2^n plus up to Jitter
+// times the delay, so that the items failing together, for example on an API server outage,
+// are not retried together. The delay, jitter included, is capped at MaxDelay.
+type Backoff struct {
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Jitter is the fraction of the delay randomly added to it, between 0 and 1
+	Jitter float64
+
+	mu       sync.Mutex
+	failures map[interface{}]int
+}
+
+// NewBackoff returns a Backoff, a workqueue.RateLimiter
+func NewBackoff(baseDelay time.Duration, maxDelay time.Duration, jitter float64) *Backoff {
+	return &Backoff{
+		BaseDelay: baseDelay,
+		MaxDelay:  maxDelay,
+		Jitter:    jitter,
+		failures:  map[interface{}]int{},
+	}
+}
+
+// Configure changes the delays, the failures counted so far are kept
+func (b *Backoff) Configure(baseDelay time.Duration, maxDelay time.Duration, jitter float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.BaseDelay, b.MaxDelay, b.Jitter = baseDelay, maxDelay, jitter
+}
+
+// When returns how long the item waits before being retried and counts the failure
+func (b *Backoff) When(item interface{}) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	exp := b.failures[item]
+	b.failures[item] = exp + 1
+
+	delay := math.Min(float64(b.BaseDelay)*math.Pow(2, float64(exp)), float64(b.MaxDelay))
+	if b.Jitter > 0 {
+		delay = math.Min(delay+delay*b.Jitter*rand.Float64(), float64(b.MaxDelay))
+	}
+	return time.Duration(delay)
+}
+
+// NumRequeues returns the number of consecutive failures of the item
+func (b *Backoff) NumRequeues(item interface{}) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures[item]
+}
+
+// Forget resets the failures of the item, it is called after a successful reconcile
+func (b *Backoff) Forget(item interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.failures, item)
+}
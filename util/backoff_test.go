@@ -0,0 +1,54 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffWhen(t *testing.T) {
+	tests := []struct {
+		name     string
+		jitter   float64
+		failures int
+		min      time.Duration
+		max      time.Duration
+	}{
+		{"first failure", 0, 0, time.Second, time.Second},
+		{"third failure", 0, 2, 4 * time.Second, 4 * time.Second},
+		{"capped", 0, 20, time.Minute, time.Minute},
+		{"jitter", 0.5, 2, 4 * time.Second, 6 * time.Second},
+		{"jitter capped", 1, 5, 32 * time.Second, time.Minute},
+		{"jitter on the cap", 0.5, 20, time.Minute, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backoff := NewBackoff(time.Second, time.Minute, tt.jitter)
+			for i := 0; i < tt.failures; i++ {
+				backoff.When("policy")
+			}
+			if got := backoff.When("policy"); got < tt.min || got > tt.max {
+				t.Errorf("When() = %s, want between %s and %s", got, tt.min, tt.max)
+			}
+			if got := backoff.NumRequeues("policy"); got != tt.failures+1 {
+				t.Errorf("NumRequeues() = %d, want %d", got, tt.failures+1)
+			}
+		})
+	}
+}
+
+func TestBackoffForget(t *testing.T) {
+	backoff := NewBackoff(time.Second, time.Minute, 0)
+	backoff.When("policy-a")
+	backoff.When("policy-a")
+	backoff.When("policy-b")
+	backoff.Forget("policy-a")
+	if got := backoff.NumRequeues("policy-a"); got != 0 {
+		t.Errorf("NumRequeues(policy-a) = %d after Forget, want 0", got)
+	}
+	if got := backoff.When("policy-a"); got != time.Second {
+		t.Errorf("When(policy-a) = %s after Forget, want 1s", got)
+	}
+	if got := backoff.NumRequeues("policy-b"); got != 1 {
+		t.Errorf("NumRequeues(policy-b) = %d, want 1", got)
+	}
+}
@@ -0,0 +1,47 @@
+package util
+
+import (
+	"fmt"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/discovery"
+	"sort"
+)
+
+const (
+	// CiliumGroup is the API group of the CiliumEgressGatewayPolicies
+	CiliumGroup = "cilium.io"
+	// CiliumEgressGatewayPolicyResource is the resource served by the Cilium CRD
+	CiliumEgressGatewayPolicyResource = "ciliumegressgatewaypolicies"
+)
+
+// CiliumEgressGatewayPolicyVersions returns the versions of the cilium.io group serving the
+// CiliumEgressGatewayPolicies, none when the CRD is not installed
+func CiliumEgressGatewayPolicyVersions(d discovery.DiscoveryInterface) ([]string, error) {
+	groups, err := d.ServerGroups()
+	if err != nil {
+		return nil, err
+	}
+	versions := []string{}
+	for _, group := range groups.Groups {
+		if group.Name != CiliumGroup {
+			continue
+		}
+		for _, version := range group.Versions {
+			resources, err := d.ServerResourcesForGroupVersion(version.GroupVersion)
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("unable to discover %s: %w", version.GroupVersion, err)
+			}
+			for _, resource := range resources.APIResources {
+				if resource.Name == CiliumEgressGatewayPolicyResource {
+					versions = append(versions, version.Version)
+					break
+				}
+			}
+		}
+	}
+	sort.Strings(versions)
+	return versions, nil
+}
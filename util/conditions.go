@@ -0,0 +1,25 @@
+package util
+
+import (
+	"context"
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// UpdatePolicyCondition sets a condition on the HAEgressGatewayPolicy status and writes it
+// only if something has changed. It returns true when the condition has been changed.
+func UpdatePolicyCondition(ctx context.Context, r client.Client, haEgressGatewayPolicy *v2.HAEgressGatewayPolicy, conditionType string, status metav1.ConditionStatus, reason string, message string) (bool, error) {
+	changed := meta.SetStatusCondition(&haEgressGatewayPolicy.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: haEgressGatewayPolicy.Generation,
+	})
+	if !changed {
+		return false, nil
+	}
+	return true, r.Status().Update(ctx, haEgressGatewayPolicy)
+}
@@ -0,0 +1,109 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"strings"
+)
+
+// RequestedLoadBalancerIPs returns the IPs a LoadBalancer service asks for, either with
+// the kube-vip annotation or with the deprecated spec.loadBalancerIP field
+func RequestedLoadBalancerIPs(service *corev1.Service) []string {
+	ips := []string{}
+	for _, ip := range strings.Split(service.Annotations[haegressip.KubeVIPLoadBalancerIPsAnnotation], ",") {
+		if ip = strings.TrimSpace(ip); ip != "" {
+			ips = append(ips, ip)
+		}
+	}
+	if service.Spec.LoadBalancerIP != "" {
+		ips = append(ips, service.Spec.LoadBalancerIP)
+	}
+	return ips
+}
+
+// AssignedLoadBalancerIPs returns the IPs reported in the service status
+func AssignedLoadBalancerIPs(service *corev1.Service) []string {
+	ips := []string{}
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			ips = append(ips, ingress.IP)
+		}
+	}
+	return ips
+}
+
+// IndexServiceLoadBalancerIPs is the field indexer for haegressip.ServiceLoadBalancerIPIndex,
+// every LoadBalancer service is indexed by both requested and assigned IPs
+func IndexServiceLoadBalancerIPs(obj client.Object) []string {
+	service, ok := obj.(*corev1.Service)
+	if !ok || service.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		return nil
+	}
+	seen := map[string]bool{}
+	ips := []string{}
+	for _, ip := range append(RequestedLoadBalancerIPs(service), AssignedLoadBalancerIPs(service)...) {
+		if !seen[ip] {
+			seen[ip] = true
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// LoadBalancerIPConflicts returns a description of every other LoadBalancer service that
+// wins the contention for an IP requested or assigned to the given service. A service
+// holding the IP in its status always wins, otherwise the oldest service wins.
+func LoadBalancerIPConflicts(ctx context.Context, r client.Reader, service *corev1.Service) ([]string, error) {
+	conflicts := []string{}
+	for _, ip := range IndexServiceLoadBalancerIPs(service) {
+		services := &corev1.ServiceList{}
+		if err := r.List(ctx, services, client.MatchingFields{haegressip.ServiceLoadBalancerIPIndex: ip}); err != nil {
+			return nil, err
+		}
+		for i := range services.Items {
+			other := &services.Items[i]
+			if other.Namespace == service.Namespace && other.Name == service.Name {
+				continue
+			}
+			if loadBalancerIPWinner(ip, service, other) == other {
+				conflicts = append(conflicts, fmt.Sprintf("%s held by %s/%s", ip, other.Namespace, other.Name))
+			}
+		}
+	}
+	sort.Strings(conflicts)
+	return conflicts, nil
+}
+
+func loadBalancerIPWinner(ip string, a, b *corev1.Service) *corev1.Service {
+	aHolds := containsString(AssignedLoadBalancerIPs(a), ip)
+	bHolds := containsString(AssignedLoadBalancerIPs(b), ip)
+	if aHolds != bHolds {
+		if aHolds {
+			return a
+		}
+		return b
+	}
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		if a.CreationTimestamp.Before(&b.CreationTimestamp) {
+			return a
+		}
+		return b
+	}
+	if a.Namespace+"/"+a.Name < b.Namespace+"/"+b.Name {
+		return a
+	}
+	return b
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+package util
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Dampening adds hysteresis to the CiliumEgressGatewayPolicies following the VIPs: a move
+// is followed only once the VIP has stayed on the new node for MinDwell, and no more than
+// MaxMoves times in MovesWindow. A nil Dampening follows every move immediately.
+type Dampening struct {
+	MinDwell    time.Duration
+	MaxMoves    int
+	MovesWindow time.Duration
+
+	mu       sync.Mutex
+	observed map[DampeningKey]*observedHost
+	moves    map[DampeningKey][]time.Time
+}
+
+// DampeningKey identifies a CiliumEgressGatewayPolicy of an HAEgressGatewayPolicy
+type DampeningKey struct {
+	Policy                    string
+	CiliumEgressGatewayPolicy string
+}
+
+type observedHost struct {
+	host     string
+	since    time.Time
+	dampened bool
+}
+
+// DampeningReason explains why a move is not followed yet
+type DampeningReason string
+
+const (
+	DampeningNone     DampeningReason = ""
+	DampeningDwell    DampeningReason = "MinDwell"
+	DampeningFlapping DampeningReason = "Flapping"
+)
+
+// DampeningDecision is the outcome of the evaluation of a VIP move
+type DampeningDecision struct {
+	// Reason is empty when the move can be followed
+	Reason DampeningReason
+	// RetryAfter is when the move should be evaluated again
+	RetryAfter time.Duration
+	// FirstDelay is true the first time the move is delayed
+	FirstDelay bool
+}
+
+// Configure changes the dampening settings, zero values follow every move immediately
+func (d *Dampening) Configure(minDwell time.Duration, maxMoves int, movesWindow time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.MinDwell, d.MaxMoves, d.MovesWindow = minDwell, maxMoves, movesWindow
+}
+
+// Limits returns the maximum number of moves in the moves window, zero without limit
+func (d *Dampening) Limits() (int, time.Duration) {
+	if d == nil {
+		return 0, 0
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.MaxMoves, d.MovesWindow
+}
+
+// Observe records the node currently holding the VIP, it must be called on every
+// evaluation so that the dwell time restarts when the VIP comes back
+func (d *Dampening) Observe(key DampeningKey, host string, now time.Time) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.observed == nil {
+		d.observed = map[DampeningKey]*observedHost{}
+	}
+	if d.observed[key] == nil || d.observed[key].host != host {
+		d.observed[key] = &observedHost{host: host, since: now}
+	}
+}
+
+// Follow returns whether the move of the VIP to the observed host can be followed now. A
+// hard failure of the node in use bypasses the dampening. Followed moves are recorded.
+func (d *Dampening) Follow(key DampeningKey, hardFailure bool, now time.Time) DampeningDecision {
+	if d == nil {
+		return DampeningDecision{}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.moves == nil {
+		d.moves = map[DampeningKey][]time.Time{}
+	}
+
+	moves := d.recentMoves(key, now)
+	observed := d.observed[key]
+	if !hardFailure && observed != nil {
+		decision := DampeningDecision{FirstDelay: !observed.dampened}
+		if dwell := now.Sub(observed.since); dwell < d.MinDwell {
+			decision.Reason, decision.RetryAfter = DampeningDwell, d.MinDwell-dwell
+		} else if d.MaxMoves > 0 && len(moves) >= d.MaxMoves {
+			decision.Reason, decision.RetryAfter = DampeningFlapping, moves[0].Add(d.MovesWindow).Sub(now)
+		}
+		if decision.Reason != DampeningNone {
+			observed.dampened = true
+			return decision
+		}
+	}
+	d.moves[key] = append(moves, now)
+	return DampeningDecision{}
+}
+
+// Flapping returns the CiliumEgressGatewayPolicies of the policy whose VIP has moved
+// MaxMoves times in the window
+func (d *Dampening) Flapping(policy string, now time.Time) []string {
+	flapping := []string{}
+	if d == nil {
+		return flapping
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.MaxMoves <= 0 {
+		return flapping
+	}
+	for key := range d.moves {
+		if key.Policy == policy && len(d.recentMoves(key, now)) >= d.MaxMoves {
+			flapping = append(flapping, key.CiliumEgressGatewayPolicy)
+		}
+	}
+	sort.Strings(flapping)
+	return flapping
+}
+
+// Forget drops the observations and the moves recorded for the CiliumEgressGatewayPolicies
+// of a deleted policy
+func (d *Dampening) Forget(policy string) {
+	d.forget(func(key DampeningKey) bool { return key.Policy == policy })
+}
+
+// ForgetCiliumEgressGatewayPolicy drops the observations and the moves recorded for a
+// CiliumEgressGatewayPolicy, for example when its service is deleted
+func (d *Dampening) ForgetCiliumEgressGatewayPolicy(name string) {
+	d.forget(func(key DampeningKey) bool { return key.CiliumEgressGatewayPolicy == name })
+}
+
+func (d *Dampening) forget(match func(DampeningKey) bool) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key := range d.observed {
+		if match(key) {
+			delete(d.observed, key)
+		}
+	}
+	for key := range d.moves {
+		if match(key) {
+			delete(d.moves, key)
+		}
+	}
+}
+
+func (d *Dampening) recentMoves(key DampeningKey, now time.Time) []time.Time {
+	recent := []time.Time{}
+	for _, move := range d.moves[key] {
+		if now.Sub(move) < d.MovesWindow {
+			recent = append(recent, move)
+		}
+	}
+	if len(recent) == 0 {
+		delete(d.moves, key)
+	} else {
+		d.moves[key] = recent
+	}
+	return recent
+}
@@ -0,0 +1,155 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDampeningFollow(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	key := DampeningKey{Policy: "policy", CiliumEgressGatewayPolicy: "egress-ns-svc"}
+	tests := []struct {
+		name        string
+		minDwell    time.Duration
+		maxMoves    int
+		movesWindow time.Duration
+		// moves already followed, minutes after start
+		followed    []int
+		observedAt  int
+		evaluatedAt int
+		hardFailure bool
+		want        DampeningDecision
+	}{
+		{
+			name:        "no dampening",
+			evaluatedAt: 0,
+			want:        DampeningDecision{},
+		},
+		{
+			name:        "dwell not elapsed",
+			minDwell:    5 * time.Minute,
+			observedAt:  0,
+			evaluatedAt: 2,
+			want:        DampeningDecision{Reason: DampeningDwell, RetryAfter: 3 * time.Minute, FirstDelay: true},
+		},
+		{
+			name:        "dwell elapsed",
+			minDwell:    5 * time.Minute,
+			observedAt:  0,
+			evaluatedAt: 5,
+			want:        DampeningDecision{},
+		},
+		{
+			name:        "hard failure bypasses the dwell",
+			minDwell:    5 * time.Minute,
+			observedAt:  0,
+			evaluatedAt: 1,
+			hardFailure: true,
+			want:        DampeningDecision{},
+		},
+		{
+			name:        "flapping",
+			maxMoves:    2,
+			movesWindow: 10 * time.Minute,
+			followed:    []int{0, 4},
+			observedAt:  6,
+			evaluatedAt: 6,
+			want:        DampeningDecision{Reason: DampeningFlapping, RetryAfter: 4 * time.Minute, FirstDelay: true},
+		},
+		{
+			name:        "moves out of the window",
+			maxMoves:    2,
+			movesWindow: 10 * time.Minute,
+			followed:    []int{0, 4},
+			observedAt:  12,
+			evaluatedAt: 12,
+			want:        DampeningDecision{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Dampening{}
+			d.Configure(tt.minDwell, tt.maxMoves, tt.movesWindow)
+			for _, minute := range tt.followed {
+				d.Follow(key, true, start.Add(time.Duration(minute)*time.Minute))
+			}
+			d.Observe(key, "node-b", start.Add(time.Duration(tt.observedAt)*time.Minute))
+			if got := d.Follow(key, tt.hardFailure, start.Add(time.Duration(tt.evaluatedAt)*time.Minute)); got != tt.want {
+				t.Errorf("Follow() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDampeningNil(t *testing.T) {
+	var d *Dampening
+	now := time.Now()
+	d.Observe(DampeningKey{}, "node", now)
+	if got := d.Follow(DampeningKey{}, false, now); got != (DampeningDecision{}) {
+		t.Errorf("Follow() = %+v, want an immediate follow", got)
+	}
+	if got := d.Flapping("policy", now); len(got) != 0 {
+		t.Errorf("Flapping() = %v, want none", got)
+	}
+	d.Forget("policy")
+	d.ForgetCiliumEgressGatewayPolicy("egress-ns-svc")
+}
+
+func TestDampeningForget(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	keys := []DampeningKey{
+		{Policy: "a", CiliumEgressGatewayPolicy: "egress-ns-a"},
+		{Policy: "a", CiliumEgressGatewayPolicy: "egress-ns-a-1"},
+		{Policy: "b", CiliumEgressGatewayPolicy: "egress-ns-b"},
+	}
+	tests := []struct {
+		name   string
+		forget func(d *Dampening)
+		want   map[string][]string
+	}{
+		{
+			name:   "policy",
+			forget: func(d *Dampening) { d.Forget("a") },
+			want:   map[string][]string{"a": {}, "b": {"egress-ns-b"}},
+		},
+		{
+			name:   "CiliumEgressGatewayPolicy",
+			forget: func(d *Dampening) { d.ForgetCiliumEgressGatewayPolicy("egress-ns-a-1") },
+			want:   map[string][]string{"a": {"egress-ns-a"}, "b": {"egress-ns-b"}},
+		},
+		{
+			name:   "unknown policy",
+			forget: func(d *Dampening) { d.Forget("c") },
+			want:   map[string][]string{"a": {"egress-ns-a", "egress-ns-a-1"}, "b": {"egress-ns-b"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Dampening{}
+			d.Configure(time.Minute, 1, time.Hour)
+			for _, key := range keys {
+				d.Observe(key, "node-a", now)
+				d.Follow(key, true, now)
+			}
+			tt.forget(d)
+			for policy, want := range tt.want {
+				if got := d.Flapping(policy, now); !reflect.DeepEqual(got, want) {
+					t.Errorf("Flapping(%q) = %v, want %v", policy, got, want)
+				}
+			}
+			for _, key := range keys {
+				forgotten := true
+				for _, flapping := range tt.want[key.Policy] {
+					forgotten = forgotten && flapping != key.CiliumEgressGatewayPolicy
+				}
+				// A forgotten observation restarts the dwell, a kept one has already elapsed
+				d.Observe(key, "node-a", now.Add(time.Minute))
+				decision := d.Follow(key, false, now.Add(time.Minute))
+				if gotForgotten := decision.Reason == DampeningDwell; gotForgotten != forgotten {
+					t.Errorf("key %v forgotten = %v, want %v", key, gotForgotten, forgotten)
+				}
+			}
+		})
+	}
+}
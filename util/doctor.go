@@ -0,0 +1,277 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	appsv1 "k8s.io/api/apps/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+)
+
+// FindingSeverity tells whether a doctor check passed
+type FindingSeverity string
+
+const (
+	FindingOK      FindingSeverity = "OK"
+	FindingWarning FindingSeverity = "Warning"
+	FindingError   FindingSeverity = "Error"
+)
+
+// Finding is the outcome of a doctor check, the hint tells how to fix a problem
+type Finding struct {
+	Check    string
+	Severity FindingSeverity
+	Message  string
+	Hint     string
+}
+
+// DoctorOptions are the operator settings the environment is checked against
+type DoctorOptions struct {
+	// CiliumNamespace and CiliumConfigMap locate the Cilium agent configuration
+	CiliumNamespace string
+	CiliumConfigMap string
+	// KubeVIPNamespace and KubeVIPSelector locate the kube-vip DaemonSet
+	KubeVIPNamespace string
+	KubeVIPSelector  labels.Selector
+	// LoadBalancerClass is the class of the services
+	LoadBalancerClass string
+}
+
+// Permission is an access the operator needs, checked cluster-wide
+type Permission struct {
+	Group    string
+	Resource string
+	Verb     string
+}
+
+// DefaultKubeVIPLoadBalancerClass is the class served by kube-vip when lb_class_name is not set
+const DefaultKubeVIPLoadBalancerClass = "kube-vip.io/kube-vip-class"
+
+// RequiredPermissions lists the accesses needed by the controllers, it follows the rbac markers
+var RequiredPermissions = []Permission{
+	{"cilium.angeloxx.ch", "haegressgatewaypolicies", "list"},
+	{"cilium.angeloxx.ch", "haegressgatewaypolicies", "watch"},
+	{"cilium.angeloxx.ch", "haegressgatewaypolicies", "patch"},
+	{"cilium.angeloxx.ch", "haegressgatewaypolicies/status", "update"},
+	{"cilium.io", "ciliumegressgatewaypolicies", "list"},
+	{"cilium.io", "ciliumegressgatewaypolicies", "watch"},
+	{"cilium.io", "ciliumegressgatewaypolicies", "create"},
+	{"cilium.io", "ciliumegressgatewaypolicies", "update"},
+	{"cilium.io", "ciliumegressgatewaypolicies", "patch"},
+	{"cilium.io", "ciliumegressgatewaypolicies", "delete"},
+	{"", "services", "list"},
+	{"", "services", "watch"},
+	{"", "services", "create"},
+	{"", "services", "update"},
+	{"", "services", "delete"},
+	{"", "pods", "list"},
+	{"", "pods", "patch"},
+	{"", "namespaces", "list"},
+	{"", "nodes", "list"},
+	{"", "nodes", "patch"},
+	{"", "events", "create"},
+	{"coordination.k8s.io", "leases", "get"},
+	{"coordination.k8s.io", "leases", "update"},
+	{"policy", "poddisruptionbudgets", "create"},
+	{"policy", "poddisruptionbudgets", "delete"},
+}
+
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get
+// +kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list
+
+// Doctor checks the prerequisites of the operator: the Cilium egress gateway configuration,
+// the CRDs, the kube-vip DaemonSet and the LoadBalancer class
+func Doctor(ctx context.Context, c client.Client, options DoctorOptions) []Finding {
+	findings := checkCiliumConfig(ctx, c, options)
+	findings = append(findings, checkCRDs(c)...)
+	kubeVIPFindings, kubeVIPClass := checkKubeVIP(ctx, c, options)
+	findings = append(findings, kubeVIPFindings...)
+	return append(findings, checkLoadBalancerClass(ctx, c, options, kubeVIPClass)...)
+}
+
+// CheckPermissions checks the RequiredPermissions of the identity of the client with
+// SelfSubjectAccessReviews
+func CheckPermissions(ctx context.Context, c client.Client) []Finding {
+	missing := []string{}
+	for _, permission := range RequiredPermissions {
+		resource, subresource, _ := strings.Cut(permission.Resource, "/")
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Group:       permission.Group,
+					Resource:    resource,
+					Subresource: subresource,
+					Verb:        permission.Verb,
+				},
+			},
+		}
+		if err := c.Create(ctx, review); err != nil {
+			return []Finding{{"RBAC", FindingWarning, fmt.Sprintf("Unable to review the permissions: %v", err), ""}}
+		}
+		if !review.Status.Allowed {
+			group := permission.Group
+			if group == "" {
+				group = "core"
+			}
+			missing = append(missing, fmt.Sprintf("%s %s.%s", permission.Verb, permission.Resource, group))
+		}
+	}
+	if len(missing) > 0 {
+		return []Finding{{"RBAC", FindingError, fmt.Sprintf("Missing permissions: %s", strings.Join(missing, ", ")),
+			"Grant the permissions of config/rbac/role.yaml (or the chart ClusterRole) to the operator service account"}}
+	}
+	return []Finding{{"RBAC", FindingOK, "The operator has the required permissions", ""}}
+}
+
+func checkCiliumConfig(ctx context.Context, c client.Client, options DoctorOptions) []Finding {
+	check := "Cilium"
+	config := &corev1.ConfigMap{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: options.CiliumNamespace, Name: options.CiliumConfigMap}, config); err != nil {
+		return []Finding{{check, FindingWarning,
+			fmt.Sprintf("Unable to read ConfigMap %s/%s: %v", options.CiliumNamespace, options.CiliumConfigMap, err),
+			"Check the Cilium namespace and ConfigMap name"}}
+	}
+
+	findings := []Finding{}
+	if config.Data["enable-ipv4-egress-gateway"] != "true" {
+		findings = append(findings, Finding{check, FindingError, "The egress gateway is disabled (enable-ipv4-egress-gateway)",
+			"Install Cilium with egressGateway.enabled=true and restart the agents"})
+	}
+	if config.Data["enable-bpf-masquerade"] != "true" {
+		findings = append(findings, Finding{check, FindingError, "BPF masquerading is disabled (enable-bpf-masquerade), the egress gateway requires it",
+			"Install Cilium with bpf.masquerade=true"})
+	}
+	switch config.Data["kube-proxy-replacement"] {
+	case "true", "strict":
+	default:
+		findings = append(findings, Finding{check, FindingWarning, "kube-proxy replacement is not enabled (kube-proxy-replacement)",
+			"Install Cilium with kubeProxyReplacement=true, the egress gateway needs it on most setups"})
+	}
+	if len(findings) == 0 {
+		findings = append(findings, Finding{check, FindingOK, "The egress gateway is enabled", ""})
+	}
+	return findings
+}
+
+func checkCRDs(c client.Client) []Finding {
+	findings := []Finding{}
+	for _, crd := range []struct {
+		kind    schema.GroupVersionKind
+		install string
+	}{
+		{schema.GroupVersionKind{Group: "cilium.io", Version: "v2", Kind: "CiliumEgressGatewayPolicy"},
+			"Upgrade Cilium to a version serving CiliumEgressGatewayPolicy cilium.io/v2 (1.12 or later) with the egress gateway enabled"},
+		{schema.GroupVersionKind{Group: "cilium.angeloxx.ch", Version: "v2", Kind: "HAEgressGatewayPolicy"},
+			"Install the CRDs of the chart or of config/crd"},
+	} {
+		check := "CRD " + crd.kind.Kind
+		mappings, err := c.RESTMapper().RESTMappings(crd.kind.GroupKind())
+		if err != nil {
+			if meta.IsNoMatchError(err) {
+				findings = append(findings, Finding{check, FindingError, fmt.Sprintf("%s is not installed", crd.kind.GroupKind()), crd.install})
+			} else {
+				findings = append(findings, Finding{check, FindingWarning, fmt.Sprintf("Unable to discover %s: %v", crd.kind.GroupKind(), err), ""})
+			}
+			continue
+		}
+		versions := []string{}
+		served := false
+		for _, mapping := range mappings {
+			versions = append(versions, mapping.GroupVersionKind.Version)
+			served = served || mapping.GroupVersionKind.Version == crd.kind.Version
+		}
+		if !served {
+			findings = append(findings, Finding{check, FindingError,
+				fmt.Sprintf("%s is served as %s, %s is required", crd.kind.GroupKind(), strings.Join(versions, ", "), crd.kind.Version), crd.install})
+			continue
+		}
+		findings = append(findings, Finding{check, FindingOK, fmt.Sprintf("%s is served as %s", crd.kind.GroupKind(), strings.Join(versions, ", ")), ""})
+	}
+	return findings
+}
+
+// checkKubeVIP checks that kube-vip announces the services and elects a leader per service,
+// the provider moves the VIPs through the per-service leases. It returns the class served by
+// kube-vip, empty when unknown.
+func checkKubeVIP(ctx context.Context, c client.Client, options DoctorOptions) ([]Finding, string) {
+	check := "kube-vip"
+	daemonSets := &appsv1.DaemonSetList{}
+	if err := c.List(ctx, daemonSets, client.InNamespace(options.KubeVIPNamespace),
+		client.MatchingLabelsSelector{Selector: options.KubeVIPSelector}); err != nil {
+		return []Finding{{check, FindingWarning, fmt.Sprintf("Unable to list the kube-vip DaemonSets: %v", err), ""}}, ""
+	}
+	if len(daemonSets.Items) == 0 {
+		return []Finding{{check, FindingError,
+			fmt.Sprintf("No kube-vip DaemonSet matching %s in namespace %s", options.KubeVIPSelector, options.KubeVIPNamespace),
+			"Install kube-vip as a DaemonSet on the gateway nodes, or set --kube-vip-namespace and --kube-vip-selector"}}, ""
+	}
+
+	findings := []Finding{}
+	class := ""
+	for _, daemonSet := range daemonSets.Items {
+		env := map[string]string{}
+		for _, container := range daemonSet.Spec.Template.Spec.Containers {
+			for _, variable := range container.Env {
+				env[variable.Name] = variable.Value
+			}
+		}
+		name := daemonSet.Namespace + "/" + daemonSet.Name
+		if env["svc_enable"] != "true" {
+			findings = append(findings, Finding{check, FindingError, fmt.Sprintf("DaemonSet %s does not announce the services (svc_enable)", name),
+				"Set svc_enable=true in the kube-vip environment"})
+		}
+		if env["svc_election"] != "true" {
+			findings = append(findings, Finding{check, FindingError, fmt.Sprintf("DaemonSet %s has no per-service leader election (svc_election)", name),
+				"Set svc_election=true in the kube-vip environment, the VIPs are moved through the per-service leases"})
+		}
+		class = env["lb_class_name"]
+		if class == "" {
+			class = DefaultKubeVIPLoadBalancerClass
+		}
+		if env["lb_class_only"] != "true" {
+			findings = append(findings, Finding{check, FindingWarning, fmt.Sprintf("DaemonSet %s also serves the services without class (lb_class_only)", name),
+				"Set lb_class_only=true if another LoadBalancer implementation runs in the cluster"})
+		}
+	}
+	if len(findings) == 0 {
+		findings = append(findings, Finding{check, FindingOK, "kube-vip announces the services with per-service leader election", ""})
+	}
+	return findings, class
+}
+
+func checkLoadBalancerClass(ctx context.Context, c client.Client, options DoctorOptions, kubeVIPClass string) []Finding {
+	check := "LoadBalancer class"
+	findings := []Finding{}
+	if kubeVIPClass != "" && kubeVIPClass != options.LoadBalancerClass {
+		findings = append(findings, Finding{check, FindingError,
+			fmt.Sprintf("The operator uses class %s while kube-vip serves %s", options.LoadBalancerClass, kubeVIPClass),
+			"Set --load-balancer-class, or lb_class_name in the kube-vip environment, to the same class"})
+	}
+
+	services := &corev1.ServiceList{}
+	if err := c.List(ctx, services, client.HasLabels{haegressip.HAEgressGatewayPolicyName}); err != nil {
+		return append(findings, Finding{check, FindingWarning, fmt.Sprintf("Unable to list the egress services: %v", err), ""})
+	}
+	for _, service := range services.Items {
+		if service.Spec.LoadBalancerClass == nil || *service.Spec.LoadBalancerClass != options.LoadBalancerClass {
+			class := "<none>"
+			if service.Spec.LoadBalancerClass != nil {
+				class = *service.Spec.LoadBalancerClass
+			}
+			findings = append(findings, Finding{check, FindingWarning,
+				fmt.Sprintf("Service %s/%s has class %s instead of %s", service.Namespace, service.Name, class, options.LoadBalancerClass),
+				"The class cannot be changed, delete the service and the operator will recreate it"})
+		}
+	}
+	if len(findings) == 0 {
+		findings = append(findings, Finding{check, FindingOK, fmt.Sprintf("The egress services use class %s", options.LoadBalancerClass), ""})
+	}
+	return findings
+}
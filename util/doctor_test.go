@@ -0,0 +1,153 @@
+package util
+
+import (
+	"context"
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"strings"
+	"testing"
+)
+
+func TestDoctor(t *testing.T) {
+	ciliumConfig := func(data map[string]string) *corev1.ConfigMap {
+		config := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "cilium-config", Namespace: "kube-system"},
+			Data:       map[string]string{"enable-ipv4-egress-gateway": "true", "enable-bpf-masquerade": "true", "kube-proxy-replacement": "true"},
+		}
+		for key, value := range data {
+			config.Data[key] = value
+		}
+		return config
+	}
+	kubeVIP := func(env map[string]string) *appsv1.DaemonSet {
+		variables := []corev1.EnvVar{}
+		for name, value := range map[string]string{"svc_enable": "true", "svc_election": "true", "lb_class_only": "true"} {
+			if override, ok := env[name]; ok {
+				value = override
+			}
+			variables = append(variables, corev1.EnvVar{Name: name, Value: value})
+		}
+		if class, ok := env["lb_class_name"]; ok {
+			variables = append(variables, corev1.EnvVar{Name: "lb_class_name", Value: class})
+		}
+		return &appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "kube-vip-ds", Namespace: "kube-system", Labels: map[string]string{"app.kubernetes.io/name": "kube-vip-ds"}},
+			Spec: appsv1.DaemonSetSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "kube-vip", Env: variables}},
+			}}},
+		}
+	}
+	egressService := func(class string) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "payments", Namespace: "egress-system", Labels: map[string]string{haegressip.HAEgressGatewayPolicyName: "payments"}},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, LoadBalancerClass: &class},
+		}
+	}
+	ciliumEgressGatewayPolicyV2 := schema.GroupVersionKind{Group: "cilium.io", Version: "v2", Kind: "CiliumEgressGatewayPolicy"}
+	haEgressGatewayPolicyV2 := v2.GroupVersion.WithKind("HAEgressGatewayPolicy")
+
+	tests := []struct {
+		name    string
+		objects []client.Object
+		crds    []schema.GroupVersionKind
+		// want maps the checks to the expected severity, the other checks must pass
+		want map[string]FindingSeverity
+	}{
+		{
+			name:    "ready",
+			objects: []client.Object{ciliumConfig(nil), kubeVIP(nil), egressService(DefaultKubeVIPLoadBalancerClass)},
+			crds:    []schema.GroupVersionKind{ciliumEgressGatewayPolicyV2, haEgressGatewayPolicyV2},
+		},
+		{
+			name:    "egress gateway disabled",
+			objects: []client.Object{ciliumConfig(map[string]string{"enable-ipv4-egress-gateway": "false"}), kubeVIP(nil)},
+			crds:    []schema.GroupVersionKind{ciliumEgressGatewayPolicyV2, haEgressGatewayPolicyV2},
+			want:    map[string]FindingSeverity{"Cilium": FindingError},
+		},
+		{
+			name:    "Cilium CRD missing",
+			objects: []client.Object{ciliumConfig(nil), kubeVIP(nil)},
+			crds:    []schema.GroupVersionKind{haEgressGatewayPolicyV2},
+			want:    map[string]FindingSeverity{"CRD CiliumEgressGatewayPolicy": FindingError},
+		},
+		{
+			name:    "Cilium CRD served at another version",
+			objects: []client.Object{ciliumConfig(nil), kubeVIP(nil)},
+			crds:    []schema.GroupVersionKind{{Group: "cilium.io", Version: "v2alpha1", Kind: "CiliumEgressGatewayPolicy"}, haEgressGatewayPolicyV2},
+			want:    map[string]FindingSeverity{"CRD CiliumEgressGatewayPolicy": FindingError},
+		},
+		{
+			name:    "kube-vip without service election",
+			objects: []client.Object{ciliumConfig(nil), kubeVIP(map[string]string{"svc_election": "false"})},
+			crds:    []schema.GroupVersionKind{ciliumEgressGatewayPolicyV2, haEgressGatewayPolicyV2},
+			want:    map[string]FindingSeverity{"kube-vip": FindingError},
+		},
+		{
+			name:    "kube-vip missing",
+			objects: []client.Object{ciliumConfig(nil)},
+			crds:    []schema.GroupVersionKind{ciliumEgressGatewayPolicyV2, haEgressGatewayPolicyV2},
+			want:    map[string]FindingSeverity{"kube-vip": FindingError},
+		},
+		{
+			name:    "kube-vip serving another class",
+			objects: []client.Object{ciliumConfig(nil), kubeVIP(map[string]string{"lb_class_name": "example.com/egress"})},
+			crds:    []schema.GroupVersionKind{ciliumEgressGatewayPolicyV2, haEgressGatewayPolicyV2},
+			want:    map[string]FindingSeverity{"LoadBalancer class": FindingError},
+		},
+		{
+			name:    "service with another class",
+			objects: []client.Object{ciliumConfig(nil), kubeVIP(nil), egressService("example.com/egress")},
+			crds:    []schema.GroupVersionKind{ciliumEgressGatewayPolicyV2, haEgressGatewayPolicyV2},
+			want:    map[string]FindingSeverity{"LoadBalancer class": FindingWarning},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			versions := []schema.GroupVersion{}
+			for _, crd := range tt.crds {
+				versions = append(versions, crd.GroupVersion())
+			}
+			mapper := meta.NewDefaultRESTMapper(versions)
+			for _, crd := range tt.crds {
+				mapper.Add(crd, meta.RESTScopeRoot)
+			}
+			c := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithRESTMapper(mapper).WithObjects(tt.objects...).Build()
+
+			findings := Doctor(context.Background(), c, DoctorOptions{
+				CiliumNamespace:   "kube-system",
+				CiliumConfigMap:   "cilium-config",
+				KubeVIPNamespace:  "kube-system",
+				KubeVIPSelector:   labels.SelectorFromSet(labels.Set{"app.kubernetes.io/name": "kube-vip-ds"}),
+				LoadBalancerClass: DefaultKubeVIPLoadBalancerClass,
+			})
+			checks := map[string]bool{}
+			for _, finding := range findings {
+				checks[finding.Check] = true
+				want, ok := tt.want[finding.Check]
+				if !ok {
+					want = FindingOK
+				}
+				if finding.Severity != want {
+					t.Errorf("Doctor() check %s = %s %q, want %s", finding.Check, finding.Severity, finding.Message, want)
+				}
+				if finding.Severity != FindingOK && finding.Hint == "" && !strings.HasPrefix(finding.Message, "Unable") {
+					t.Errorf("Doctor() check %s reports %q without a hint", finding.Check, finding.Message)
+				}
+			}
+			for check := range tt.want {
+				if !checks[check] {
+					t.Errorf("Doctor() did not run check %s", check)
+				}
+			}
+		})
+	}
+}
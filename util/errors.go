@@ -0,0 +1,129 @@
+package util
+
+import (
+	"context"
+	"errors"
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ErrorClass tells how a reconcile error is retried
+type ErrorClass string
+
+const (
+	// ErrorTransient is retried with a per-item exponential backoff
+	ErrorTransient ErrorClass = "Transient"
+	// ErrorConflict is requeued without reporting an error, the object has changed in the meanwhile
+	ErrorConflict ErrorClass = "Conflict"
+	// ErrorPermanent is not fixed by a retry, it is reported in the policy conditions
+	ErrorPermanent ErrorClass = "Permanent"
+)
+
+// permanentError marks an error as not fixed by a retry
+type permanentError struct {
+	reason string
+	err    error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// NewPermanentError marks err as permanent, it is reported with the reason instead of being
+// retried
+func NewPermanentError(reason string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{reason: reason, err: err}
+}
+
+// ClassifyError returns the class of a reconcile error. Forbidden, invalid and bad requests
+// and missing kinds need a change of the RBAC rules, of the policy or of the cluster, the
+// conflicts need a fresh copy of the object, everything else is assumed transient.
+func ClassifyError(err error) ErrorClass {
+	var permanent *permanentError
+	var holderIdentity *provider.HolderIdentityError
+	switch {
+	case errors.As(err, &permanent), errors.As(err, &holderIdentity):
+		return ErrorPermanent
+	case apierrors.IsConflict(err), apierrors.IsAlreadyExists(err):
+		return ErrorConflict
+	case apierrors.IsForbidden(err), apierrors.IsUnauthorized(err), apierrors.IsInvalid(err),
+		apierrors.IsBadRequest(err), apierrors.IsMethodNotSupported(err), apierrors.IsRequestEntityTooLargeError(err),
+		meta.IsNoMatchError(err):
+		return ErrorPermanent
+	default:
+		return ErrorTransient
+	}
+}
+
+// HandleReconcileError turns a reconcile error into the result of the reconcile: transient
+// errors are returned to be retried with backoff, conflicts are requeued without logging an
+// error and permanent errors set the ReconcileError condition of the policy, when known, and
+// are retried after haegressip.PermanentErrorRequeueAfter.
+func HandleReconcileError(ctx context.Context, r client.Client, logger logr.Logger, policyName string, err error) (ctrl.Result, error) {
+	class := ClassifyError(err)
+	switch class {
+	case ErrorConflict:
+		logger.V(1).Info("Conflict while reconciling, retrying", "error", err.Error())
+		return ctrl.Result{Requeue: true}, nil
+	case ErrorPermanent:
+		logger.Error(err, "Permanent error while reconciling, retrying later", "retryAfter", haegressip.PermanentErrorRequeueAfter.String())
+		if policyName != "" {
+			policy := &v2.HAEgressGatewayPolicy{}
+			if getErr := r.Get(ctx, types.NamespacedName{Name: policyName}, policy); getErr != nil {
+				logger.Error(getErr, "unable to fetch the HAEgressGatewayPolicy to report the error")
+			} else if _, updateErr := UpdatePolicyCondition(ctx, r, policy, haegressip.ConditionReconcileError,
+				metav1.ConditionTrue, permanentErrorReason(err), err.Error()); updateErr != nil {
+				logger.Error(updateErr, "unable to update the HAEgressGatewayPolicy conditions")
+			}
+		}
+		return ctrl.Result{RequeueAfter: haegressip.PermanentErrorRequeueAfter.Get()}, nil
+	default:
+		return ctrl.Result{}, err
+	}
+}
+
+// ClearReconcileError resets the ReconcileError condition after a successful reconcile, the
+// condition is not added to policies that never failed
+func ClearReconcileError(ctx context.Context, r client.Client, policy *v2.HAEgressGatewayPolicy) error {
+	if meta.FindStatusCondition(policy.Status.Conditions, haegressip.ConditionReconcileError) == nil {
+		return nil
+	}
+	_, err := UpdatePolicyCondition(ctx, r, policy, haegressip.ConditionReconcileError,
+		metav1.ConditionFalse, "Reconciled", "The policy has been reconciled")
+	return err
+}
+
+// permanentErrorReason returns the condition reason of a permanent error
+func permanentErrorReason(err error) string {
+	var permanent *permanentError
+	var holderIdentity *provider.HolderIdentityError
+	switch {
+	case errors.As(err, &permanent) && permanent.reason != "":
+		return permanent.reason
+	case errors.As(err, &holderIdentity):
+		return "KubeVIPIdentity"
+	case apierrors.IsForbidden(err), apierrors.IsUnauthorized(err):
+		return "Forbidden"
+	case apierrors.IsInvalid(err), apierrors.IsBadRequest(err), apierrors.IsRequestEntityTooLargeError(err):
+		return "Invalid"
+	case meta.IsNoMatchError(err):
+		return "KindNotServed"
+	default:
+		return "PermanentError"
+	}
+}
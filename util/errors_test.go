@@ -0,0 +1,57 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	resource := schema.GroupResource{Group: "cilium.io", Resource: "ciliumegressgatewaypolicies"}
+	kind := schema.GroupKind{Group: "cilium.io", Kind: "CiliumEgressGatewayPolicy"}
+	tests := []struct {
+		name       string
+		err        error
+		want       ErrorClass
+		wantReason string
+	}{
+		{"marked permanent", NewPermanentError("InvalidServiceTemplate", errors.New("unknown class")), ErrorPermanent, "InvalidServiceTemplate"},
+		{"wrapped permanent", fmt.Errorf("rendering: %w", NewPermanentError("IPv6Unsupported", errors.New("IPv6"))), ErrorPermanent, "IPv6Unsupported"},
+		{"conflict", apierrors.NewConflict(resource, "egress", errors.New("modified")), ErrorConflict, ""},
+		{"already exists", apierrors.NewAlreadyExists(resource, "egress"), ErrorConflict, ""},
+		{"forbidden", apierrors.NewForbidden(resource, "egress", errors.New("RBAC")), ErrorPermanent, "Forbidden"},
+		{"unauthorized", apierrors.NewUnauthorized("token expired"), ErrorPermanent, "Forbidden"},
+		{"invalid", apierrors.NewInvalid(kind, "egress", field.ErrorList{field.Required(field.NewPath("spec"), "")}), ErrorPermanent, "Invalid"},
+		{"bad request", apierrors.NewBadRequest("malformed"), ErrorPermanent, "Invalid"},
+		{"kind not served", &meta.NoKindMatchError{GroupKind: kind, SearchedVersions: []string{"v2"}}, ErrorPermanent, "KindNotServed"},
+		{"kube-vip identity", fmt.Errorf("moving: %w", &provider.HolderIdentityError{Lease: "egress/kubevip-egress", Holder: "kube-vip-7f9c"}), ErrorPermanent, "KubeVIPIdentity"},
+		{"method not supported", apierrors.NewMethodNotSupported(resource, "patch"), ErrorPermanent, "PermanentError"},
+		{"timeout", apierrors.NewTimeoutError("slow", 1), ErrorTransient, ""},
+		{"throttled", apierrors.NewTooManyRequests("throttled", 1), ErrorTransient, ""},
+		{"internal error", apierrors.NewInternalError(errors.New("etcd")), ErrorTransient, ""},
+		{"plain error", errors.New("connection refused"), ErrorTransient, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyError(tt.err); got != tt.want {
+				t.Errorf("ClassifyError() = %s, want %s", got, tt.want)
+			}
+			if tt.want == ErrorPermanent {
+				if got := permanentErrorReason(tt.err); got != tt.wantReason {
+					t.Errorf("permanentErrorReason() = %s, want %s", got, tt.wantReason)
+				}
+			}
+		})
+	}
+}
+
+func TestNewPermanentErrorNil(t *testing.T) {
+	if err := NewPermanentError("Reason", nil); err != nil {
+		t.Errorf("NewPermanentError(nil) = %v, want nil", err)
+	}
+}
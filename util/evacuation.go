@@ -0,0 +1,76 @@
+package util
+
+import (
+	"context"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+)
+
+// NodeEgressUsage reports the VIPs announced by a node and the CiliumEgressGatewayPolicies
+// using it as gateway for a policy
+type NodeEgressUsage struct {
+	Policy                      string
+	Services                    []string
+	CiliumEgressGatewayPolicies []string
+}
+
+// Pending returns true if the policy still uses the node
+func (u NodeEgressUsage) Pending() bool {
+	return len(u.Services) > 0 || len(u.CiliumEgressGatewayPolicies) > 0
+}
+
+// NodeEgressUsages returns, per HAEgressGatewayPolicy, the VIPs and the managed
+// CiliumEgressGatewayPolicies on the node, sorted by policy name
+func NodeEgressUsages(ctx context.Context, r client.Reader, vipProvider provider.Provider, nodeName string) ([]NodeEgressUsage, error) {
+	usages := map[string]*NodeEgressUsage{}
+	usage := func(policy string) *NodeEgressUsage {
+		if usages[policy] == nil {
+			usages[policy] = &NodeEgressUsage{Policy: policy, Services: []string{}, CiliumEgressGatewayPolicies: []string{}}
+		}
+		return usages[policy]
+	}
+
+	services := &corev1.ServiceList{}
+	if err := r.List(ctx, services, client.HasLabels{haegressip.HAEgressGatewayPolicyName}); err != nil {
+		return nil, err
+	}
+	for i := range services.Items {
+		service := &services.Items[i]
+		if vipProvider.CurrentNode(service) == nodeName {
+			policy := usage(service.Labels[haegressip.HAEgressGatewayPolicyName])
+			policy.Services = append(policy.Services, service.Namespace+"/"+service.Name)
+		}
+	}
+
+	// Without the CiliumEgressGatewayPolicy CRD only the VIPs are reported
+	ciliumEgressGatewayPolicies := &ciliumv2.CiliumEgressGatewayPolicyList{}
+	if err := r.List(ctx, ciliumEgressGatewayPolicies); err != nil && !meta.IsNoMatchError(err) {
+		return nil, err
+	}
+	for _, ciliumEgressGatewayPolicy := range ciliumEgressGatewayPolicies.Items {
+		owner := metav1.GetControllerOf(&ciliumEgressGatewayPolicy)
+		gateway := ciliumEgressGatewayPolicy.Spec.EgressGateway
+		if owner == nil || owner.Kind != "HAEgressGatewayPolicy" || gateway == nil || gateway.NodeSelector == nil {
+			continue
+		}
+		if string(gateway.NodeSelector.MatchLabels[haegressip.NodeNameAnnotation]) == nodeName {
+			policy := usage(owner.Name)
+			policy.CiliumEgressGatewayPolicies = append(policy.CiliumEgressGatewayPolicies, ciliumEgressGatewayPolicy.Name)
+		}
+	}
+
+	result := []NodeEgressUsage{}
+	for _, u := range usages {
+		result = append(result, *u)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Policy < result[j].Policy
+	})
+	return result, nil
+}
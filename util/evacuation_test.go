@@ -0,0 +1,92 @@
+package util
+
+import (
+	"context"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"testing"
+)
+
+func TestNodeEgressUsages(t *testing.T) {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(ciliumv2.AddToScheme(scheme))
+
+	service := func(name string, node string) *corev1.Service {
+		return &corev1.Service{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "egress-system",
+			Labels:      map[string]string{haegressip.HAEgressGatewayPolicyName: name},
+			Annotations: map[string]string{haegressip.KubeVIPVipHostAnnotation: node},
+		}}
+	}
+	ciliumEgressGatewayPolicy := func(name string, owner string, node string) *ciliumv2.CiliumEgressGatewayPolicy {
+		policy := &ciliumv2.CiliumEgressGatewayPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: ciliumv2.CiliumEgressGatewayPolicySpec{EgressGateway: &ciliumv2.EgressGateway{
+				NodeSelector: &slimv1.LabelSelector{MatchLabels: map[string]slimv1.MatchLabelsValue{haegressip.NodeNameAnnotation: node}},
+			}},
+		}
+		if owner != "" {
+			controller := true
+			policy.OwnerReferences = []metav1.OwnerReference{{
+				APIVersion: "cilium.angeloxx.ch/v2", Kind: "HAEgressGatewayPolicy", Name: owner, Controller: &controller,
+			}}
+		}
+		return policy
+	}
+	// billing is announced by node-b while its CiliumEgressGatewayPolicy still points to
+	// node-a, the unmanaged CiliumEgressGatewayPolicy is ignored
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		service("payments", "node-a"),
+		service("billing", "node-b"),
+		service("orders", "node-c"),
+		ciliumEgressGatewayPolicy("egress-system-payments", "payments", "node-a"),
+		ciliumEgressGatewayPolicy("egress-system-billing", "billing", "node-a"),
+		ciliumEgressGatewayPolicy("egress-system-orders", "orders", "node-c"),
+		ciliumEgressGatewayPolicy("unmanaged", "", "node-a"),
+	).Build()
+
+	tests := []struct {
+		node string
+		want []NodeEgressUsage
+	}{
+		{
+			node: "node-a",
+			want: []NodeEgressUsage{
+				{Policy: "billing", Services: []string{}, CiliumEgressGatewayPolicies: []string{"egress-system-billing"}},
+				{Policy: "payments", Services: []string{"egress-system/payments"}, CiliumEgressGatewayPolicies: []string{"egress-system-payments"}},
+			},
+		},
+		{
+			node: "node-b",
+			want: []NodeEgressUsage{{Policy: "billing", Services: []string{"egress-system/billing"}, CiliumEgressGatewayPolicies: []string{}}},
+		},
+		{node: "node-d", want: []NodeEgressUsage{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.node, func(t *testing.T) {
+			got, err := NodeEgressUsages(context.Background(), c, provider.NewKubeVIP(c), tt.node)
+			if err != nil {
+				t.Fatalf("NodeEgressUsages() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NodeEgressUsages() = %+v, want %+v", got, tt.want)
+			}
+			for _, usage := range got {
+				if !usage.Pending() {
+					t.Errorf("Pending() = false for %+v", usage)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package util
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// HashRing assigns keys to members with consistent hashing: every member is placed on the
+// ring at VirtualNodes points and a key belongs to the member of the first point following
+// its hash, so that a member joining or leaving moves only its share of the keys
+type HashRing struct {
+	points []uint32
+	owners map[uint32]string
+}
+
+// NewHashRing returns the ring of the members
+func NewHashRing(members []string, virtualNodes int) *HashRing {
+	if virtualNodes < 1 {
+		virtualNodes = 1
+	}
+	ring := &HashRing{owners: map[uint32]string{}}
+	for _, member := range members {
+		for i := 0; i < virtualNodes; i++ {
+			point := hashKey(member + "#" + strconv.Itoa(i))
+			// On a collision the smallest member wins, whatever the order of the members
+			if owner, ok := ring.owners[point]; ok && owner < member {
+				continue
+			} else if !ok {
+				ring.points = append(ring.points, point)
+			}
+			ring.owners[point] = member
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool {
+		return ring.points[i] < ring.points[j]
+	})
+	return ring
+}
+
+// Owner returns the member owning the key, empty when the ring has no members
+func (r *HashRing) Owner(key string) string {
+	if r == nil || len(r.points) == 0 {
+		return ""
+	}
+	hash := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i] >= hash
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum32()
+}
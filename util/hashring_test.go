@@ -0,0 +1,66 @@
+package util
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHashRingOwner(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []string
+		want    []string
+	}{
+		{"no members", nil, []string{""}},
+		{"single member", []string{"operator-a"}, []string{"operator-a"}},
+		{"many members", []string{"operator-a", "operator-b", "operator-c"}, []string{"operator-a", "operator-b", "operator-c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ring := NewHashRing(tt.members, 16)
+			for i := 0; i < 100; i++ {
+				owner := ring.Owner(fmt.Sprintf("policy-%d", i))
+				found := false
+				for _, member := range tt.want {
+					found = found || owner == member
+				}
+				if !found {
+					t.Errorf("Owner(policy-%d) = %q, want one of %v", i, owner, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestHashRingNil(t *testing.T) {
+	var ring *HashRing
+	if owner := ring.Owner("policy"); owner != "" {
+		t.Errorf("Owner() = %q, want none", owner)
+	}
+}
+
+func TestHashRingStable(t *testing.T) {
+	members := []string{"operator-a", "operator-b", "operator-c", "operator-d"}
+	tests := []struct {
+		name    string
+		members []string
+	}{
+		{"same members in another order", []string{"operator-d", "operator-c", "operator-b", "operator-a"}},
+		{"member leaving", []string{"operator-a", "operator-b", "operator-c"}},
+		{"member joining", []string{"operator-a", "operator-b", "operator-c", "operator-d", "operator-e"}},
+	}
+	ring := NewHashRing(members, 32)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := NewHashRing(tt.members, 32)
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprintf("policy-%d", i)
+				before, after := ring.Owner(key), changed.Owner(key)
+				// Only the keys of the member leaving, or moving to the member joining, change owner
+				if before != after && before != "operator-d" && after != "operator-e" {
+					t.Errorf("Owner(%s) moved from %q to %q", key, before, after)
+				}
+			}
+		})
+	}
+}
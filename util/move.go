@@ -0,0 +1,110 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+)
+
+// ValidateMoveTarget returns an error explaining why the egress IPs of the policy cannot be
+// moved to the named node: it must exist, match the policy nodeSelector, be eligible and
+// not break the affinity rules
+func ValidateMoveTarget(ctx context.Context, r client.Reader, haEgressGatewayPolicy *v2.HAEgressGatewayPolicy, nodeName string) error {
+	node := &corev1.Node{}
+	if err := r.Get(ctx, types.NamespacedName{Name: nodeName}, node); err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("node %s does not exist", nodeName)
+		}
+		return err
+	}
+	if !PolicyNodeMatches(haEgressGatewayPolicy, node) {
+		return fmt.Errorf("node %s does not match the nodeSelector of the policy", nodeName)
+	}
+	if !NodeReady(node) {
+		return fmt.Errorf("node %s is not ready", nodeName)
+	}
+	if reason := NodeEvacuationReason(node); reason != "" {
+		return fmt.Errorf("node %s is being evacuated (%s)", nodeName, reason)
+	}
+	violations, err := NodePlacementViolations(ctx, r, haEgressGatewayPolicy, nodeName)
+	if err != nil {
+		return err
+	}
+	if len(violations) > 0 {
+		messages := []string{}
+		for _, violation := range violations {
+			messages = append(messages, violation.Message)
+		}
+		return fmt.Errorf("node %s breaks %s", nodeName, strings.Join(messages, ", "))
+	}
+	return nil
+}
+
+// PolicyServices returns the services generated for the shards of the policy
+func PolicyServices(ctx context.Context, r client.Reader, haEgressGatewayPolicy *v2.HAEgressGatewayPolicy, defaultNamespace string) ([]corev1.Service, error) {
+	services := &corev1.ServiceList{}
+	if err := r.List(ctx, services,
+		client.InNamespace(ServiceNamespace(haEgressGatewayPolicy, defaultNamespace)),
+		client.MatchingLabels{haegressip.HAEgressGatewayPolicyName: haEgressGatewayPolicy.Name}); err != nil {
+		return nil, err
+	}
+	return services.Items, nil
+}
+
+// PolicyCiliumEgressGatewayPolicies returns the CiliumEgressGatewayPolicies owned by the policy
+func PolicyCiliumEgressGatewayPolicies(ctx context.Context, r client.Reader, haEgressGatewayPolicy *v2.HAEgressGatewayPolicy) ([]ciliumv2.CiliumEgressGatewayPolicy, error) {
+	ciliumEgressGatewayPolicies := &ciliumv2.CiliumEgressGatewayPolicyList{}
+	if err := r.List(ctx, ciliumEgressGatewayPolicies); err != nil {
+		return nil, err
+	}
+	owned := []ciliumv2.CiliumEgressGatewayPolicy{}
+	for _, ciliumEgressGatewayPolicy := range ciliumEgressGatewayPolicies.Items {
+		owner := metav1.GetControllerOf(&ciliumEgressGatewayPolicy)
+		if owner != nil && owner.Kind == "HAEgressGatewayPolicy" && owner.Name == haEgressGatewayPolicy.Name {
+			owned = append(owned, ciliumEgressGatewayPolicy)
+		}
+	}
+	return owned, nil
+}
+
+// CiliumEgressGatewayPolicyNode returns the gateway node selected by the CiliumEgressGatewayPolicy
+func CiliumEgressGatewayPolicyNode(ciliumEgressGatewayPolicy *ciliumv2.CiliumEgressGatewayPolicy) string {
+	gateway := ciliumEgressGatewayPolicy.Spec.EgressGateway
+	if gateway == nil || gateway.NodeSelector == nil {
+		return ""
+	}
+	return string(gateway.NodeSelector.MatchLabels[haegressip.NodeNameAnnotation])
+}
+
+// PolicyMovedTo returns true when every VIP of the policy is announced by the named node and
+// every CiliumEgressGatewayPolicy of the policy uses it as gateway
+func PolicyMovedTo(ctx context.Context, r client.Reader, vipProvider provider.Provider, haEgressGatewayPolicy *v2.HAEgressGatewayPolicy, defaultNamespace string, nodeName string) (bool, error) {
+	services, err := PolicyServices(ctx, r, haEgressGatewayPolicy, defaultNamespace)
+	if err != nil {
+		return false, err
+	}
+	for i := range services {
+		if vipProvider.CurrentNode(&services[i]) != nodeName {
+			return false, nil
+		}
+	}
+	ciliumEgressGatewayPolicies, err := PolicyCiliumEgressGatewayPolicies(ctx, r, haEgressGatewayPolicy)
+	if err != nil {
+		return false, err
+	}
+	for i := range ciliumEgressGatewayPolicies {
+		if CiliumEgressGatewayPolicyNode(&ciliumEgressGatewayPolicies[i]) != nodeName {
+			return false, nil
+		}
+	}
+	return len(services) > 0, nil
+}
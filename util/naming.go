@@ -0,0 +1,62 @@
+package util
+
+import (
+	"fmt"
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	corev1 "k8s.io/api/core/v1"
+	"net/netip"
+	"strings"
+)
+
+// ServiceNamespace returns the namespace where the services of the policy are created
+func ServiceNamespace(haEgressGatewayPolicy *v2.HAEgressGatewayPolicy, defaultNamespace string) string {
+	if haEgressGatewayPolicy.Annotations[haegressip.HAEgressGatewayPolicyNamespace] != "" {
+		return haEgressGatewayPolicy.Annotations[haegressip.HAEgressGatewayPolicyNamespace]
+	}
+	return defaultNamespace
+}
+
+// CiliumEgressGatewayPolicyName returns the name of the CiliumEgressGatewayPolicy generated
+// for a service
+func CiliumEgressGatewayPolicyName(serviceNamespace string, serviceName string) string {
+	return fmt.Sprintf("%s-%s", serviceNamespace, serviceName)
+}
+
+// ValidatePolicyIPFamilies returns a permanent error when the ip-families or ip-family-policy
+// annotation asks for IPv6 or holds an unknown value. The destinationCIDRs and the egressIP of
+// the CiliumEgressGatewayPolicy are IPv4 only, so the generated Service must be IPv4 single-stack.
+func ValidatePolicyIPFamilies(haEgressGatewayPolicy *v2.HAEgressGatewayPolicy) error {
+	if value, ok := haEgressGatewayPolicy.Annotations[haegressip.IPFamiliesAnnotation]; ok {
+		for _, family := range strings.Split(value, ",") {
+			switch strings.ToLower(strings.TrimSpace(family)) {
+			case "ipv4":
+			case "ipv6":
+				return NewPermanentError("IPv6Unsupported", fmt.Errorf("%s: IPv6 egress is not supported, the CiliumEgressGatewayPolicy accepts IPv4 only", haegressip.IPFamiliesAnnotation))
+			default:
+				return NewPermanentError("InvalidAnnotation", fmt.Errorf("%s: unknown IP family %q, IPv4 is expected", haegressip.IPFamiliesAnnotation, family))
+			}
+		}
+	}
+	if value, ok := haEgressGatewayPolicy.Annotations[haegressip.IPFamilyPolicyAnnotation]; ok {
+		switch corev1.IPFamilyPolicy(strings.TrimSpace(value)) {
+		case corev1.IPFamilyPolicySingleStack:
+		case corev1.IPFamilyPolicyPreferDualStack, corev1.IPFamilyPolicyRequireDualStack:
+			return NewPermanentError("IPv6Unsupported", fmt.Errorf("%s: %s Services are not supported, the CiliumEgressGatewayPolicy accepts IPv4 only", haegressip.IPFamilyPolicyAnnotation, value))
+		default:
+			return NewPermanentError("InvalidAnnotation", fmt.Errorf("%s: unknown IP family policy %q, %s is expected", haegressip.IPFamilyPolicyAnnotation, value, corev1.IPFamilyPolicySingleStack))
+		}
+	}
+	return nil
+}
+
+// LoadBalancerIPv4 returns the first IPv4 address assigned to the service, the egress IP of
+// the CiliumEgressGatewayPolicy must never be an IPv6 address
+func LoadBalancerIPv4(service *corev1.Service) string {
+	for _, ip := range AssignedLoadBalancerIPs(service) {
+		if addr, err := netip.ParseAddr(ip); err == nil && addr.Is4() {
+			return ip
+		}
+	}
+	return ""
+}
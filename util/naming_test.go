@@ -0,0 +1,71 @@
+package util
+
+import (
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func TestValidatePolicyIPFamilies(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		wantReason  string
+	}{
+		{"no annotation", nil, ""},
+		{"IPv4", map[string]string{haegressip.IPFamiliesAnnotation: "IPv4"}, ""},
+		{"lower case IPv4", map[string]string{haegressip.IPFamiliesAnnotation: " ipv4 "}, ""},
+		{"IPv6 only", map[string]string{haegressip.IPFamiliesAnnotation: "IPv6"}, "IPv6Unsupported"},
+		{"dual-stack", map[string]string{haegressip.IPFamiliesAnnotation: "IPv4,IPv6"}, "IPv6Unsupported"},
+		{"unknown family", map[string]string{haegressip.IPFamiliesAnnotation: "IPv5"}, "InvalidAnnotation"},
+		{"empty value", map[string]string{haegressip.IPFamiliesAnnotation: ""}, "InvalidAnnotation"},
+		{"single-stack", map[string]string{haegressip.IPFamilyPolicyAnnotation: "SingleStack"}, ""},
+		{"prefer dual-stack", map[string]string{haegressip.IPFamilyPolicyAnnotation: "PreferDualStack"}, "IPv6Unsupported"},
+		{"require dual-stack", map[string]string{haegressip.IPFamiliesAnnotation: "IPv4", haegressip.IPFamilyPolicyAnnotation: "RequireDualStack"}, "IPv6Unsupported"},
+		{"unknown family policy", map[string]string{haegressip.IPFamilyPolicyAnnotation: "singlestack"}, "InvalidAnnotation"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &v2.HAEgressGatewayPolicy{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations}}
+			err := ValidatePolicyIPFamilies(policy)
+			if tt.wantReason == "" {
+				if err != nil {
+					t.Fatalf("ValidatePolicyIPFamilies() = %v, want nil", err)
+				}
+				return
+			}
+			if ClassifyError(err) != ErrorPermanent || permanentErrorReason(err) != tt.wantReason {
+				t.Errorf("ValidatePolicyIPFamilies() = %v, want a permanent %s error", err, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestLoadBalancerIPv4(t *testing.T) {
+	tests := []struct {
+		name    string
+		ingress []corev1.LoadBalancerIngress
+		want    string
+	}{
+		{"not assigned", nil, ""},
+		{"IPv4", []corev1.LoadBalancerIngress{{IP: "10.0.0.1"}}, "10.0.0.1"},
+		{"IPv6 first", []corev1.LoadBalancerIngress{{Hostname: "lb"}, {IP: "fd00::1"}, {IP: "10.0.0.1"}}, "10.0.0.1"},
+		{"IPv6 only", []corev1.LoadBalancerIngress{{IP: "fd00::1"}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &corev1.Service{Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{Ingress: tt.ingress}}}
+			if got := LoadBalancerIPv4(service); got != tt.want {
+				t.Errorf("LoadBalancerIPv4() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCiliumEgressGatewayPolicyName(t *testing.T) {
+	if got := CiliumEgressGatewayPolicyName("egress-system", "payments"); got != "egress-system-payments" {
+		t.Errorf("CiliumEgressGatewayPolicyName() = %q, want %q", got, "egress-system-payments")
+	}
+}
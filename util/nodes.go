@@ -0,0 +1,114 @@
+package util
+
+import (
+	"context"
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"time"
+)
+
+// NodeReady returns true if the node reports the Ready condition
+func NodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// MaintenanceAnnotation and MaintenanceTaint mark a node going into maintenance, they can be
+// changed from the command line, an empty value disables the check
+var (
+	MaintenanceAnnotation = haegressip.DefaultMaintenanceAnnotation
+	MaintenanceTaint      = haegressip.DefaultMaintenanceTaint
+)
+
+// NodeEvacuationReason returns why the VIPs must be moved off the node, empty if the node
+// can hold VIPs
+func NodeEvacuationReason(node *corev1.Node) string {
+	if node.Annotations[haegressip.EvacuateAnnotation] == "true" {
+		return "Evacuate"
+	}
+	if node.Spec.Unschedulable {
+		return "Unschedulable"
+	}
+	if value, ok := node.Annotations[MaintenanceAnnotation]; MaintenanceAnnotation != "" && ok && value != "false" {
+		return "MaintenanceAnnotation"
+	}
+	for _, taint := range node.Spec.Taints {
+		switch {
+		case MaintenanceTaint != "" && taint.Key == MaintenanceTaint:
+			return "MaintenanceTaint"
+		case taint.Key == haegressip.KarpenterDisruptionTaint || taint.Key == haegressip.KarpenterDisruptedTaint:
+			return "KarpenterDisruption"
+		case taint.Key == haegressip.ClusterAutoscalerToBeDeletedTaint:
+			return "AutoscalerScaleDown"
+		}
+	}
+	return ""
+}
+
+// NodeEligible returns true if a VIP can be assigned to the node: it must be ready and not
+// being evacuated
+func NodeEligible(node *corev1.Node) bool {
+	return NodeReady(node) && NodeEvacuationReason(node) == ""
+}
+
+// PolicyNodeMatches returns true if the node is one of the gateway nodes declared in the
+// policy nodeSelector, every node matches when the policy has no nodeSelector
+func PolicyNodeMatches(haEgressGatewayPolicy *v2.HAEgressGatewayPolicy, node *corev1.Node) bool {
+	if haEgressGatewayPolicy.Spec.EgressGateway == nil || haEgressGatewayPolicy.Spec.EgressGateway.NodeSelector == nil {
+		return true
+	}
+	selector, err := slimv1.LabelSelectorAsSelector(haEgressGatewayPolicy.Spec.EgressGateway.NodeSelector)
+	if err != nil {
+		return false
+	}
+	return selector.Matches(labels.Set(node.Labels))
+}
+
+// EligibleNodes returns the gateway nodes of the policy that can hold its VIP
+func EligibleNodes(ctx context.Context, r client.Reader, haEgressGatewayPolicy *v2.HAEgressGatewayPolicy) ([]corev1.Node, error) {
+	nodes := &corev1.NodeList{}
+	if err := r.List(ctx, nodes); err != nil {
+		return nil, err
+	}
+	eligible := []corev1.Node{}
+	for i := range nodes.Items {
+		if PolicyNodeMatches(haEgressGatewayPolicy, &nodes.Items[i]) && NodeEligible(&nodes.Items[i]) {
+			eligible = append(eligible, nodes.Items[i])
+		}
+	}
+	return eligible, nil
+}
+
+// NodeStableFor returns true if the node is eligible and has been ready for at least the
+// given duration
+func NodeStableFor(node *corev1.Node, stableFor time.Duration, now time.Time) bool {
+	if !NodeEligible(node) {
+		return false
+	}
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return now.Sub(condition.LastTransitionTime.Time) >= stableFor
+		}
+	}
+	return false
+}
+
+// NodeFailed returns true if the node is gone, not ready or being evacuated: the VIPs must
+// leave it and their moves must not be dampened
+func NodeFailed(ctx context.Context, r client.Reader, name string) bool {
+	node := &corev1.Node{}
+	if err := r.Get(ctx, types.NamespacedName{Name: name}, node); err != nil {
+		return apierrors.IsNotFound(err)
+	}
+	return !NodeReady(node) || NodeEvacuationReason(node) != ""
+}
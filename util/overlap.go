@@ -0,0 +1,196 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	k8sConst "github.com/cilium/cilium/pkg/k8s/apis/cilium.io"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"net/netip"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+)
+
+// EgressPolicyRef is an egress gateway policy taking part in the overlap detection, it can
+// be an HAEgressGatewayPolicy or an unmanaged CiliumEgressGatewayPolicy
+type EgressPolicyRef struct {
+	Kind string
+	Name string
+	Spec ciliumv2.CiliumEgressGatewayPolicySpec
+}
+
+func (p EgressPolicyRef) String() string {
+	return fmt.Sprintf("%s/%s", p.Kind, p.Name)
+}
+
+// EgressPolicyOverlap describes the pods and destinations selected by two policies at once
+type EgressPolicyOverlap struct {
+	Policy       EgressPolicyRef
+	Destinations []string
+	Pods         []string
+}
+
+// NamespaceLabels returns the labels of every namespace, used to evaluate the namespace
+// selectors of the egress policies
+func NamespaceLabels(ctx context.Context, r client.Reader) (map[string]labels.Set, error) {
+	namespaceList := &corev1.NamespaceList{}
+	if err := r.List(ctx, namespaceList); err != nil {
+		return nil, err
+	}
+	namespaces := map[string]labels.Set{}
+	for _, namespace := range namespaceList.Items {
+		namespaces[namespace.Name] = namespace.Labels
+	}
+	return namespaces, nil
+}
+
+// PolicySelections returns the namespace/name of the pods selected by each policy, keyed by
+// the policy reference: the pods are evaluated once for all the policies
+func PolicySelections(policies []EgressPolicyRef, pods []corev1.Pod, namespaces map[string]labels.Set) map[string][]string {
+	selections := make(map[string][]string, len(policies))
+	for _, policy := range policies {
+		selections[policy.String()] = SelectedPods(policy.Spec, pods, namespaces)
+	}
+	return selections
+}
+
+// SelectedPods returns the namespace/name of the pods selected by the policy, evaluating
+// the selectors like Cilium does against the pod and namespace labels
+func SelectedPods(spec ciliumv2.CiliumEgressGatewayPolicySpec, pods []corev1.Pod, namespaces map[string]labels.Set) []string {
+	selected := []string{}
+	for i := range pods {
+		if PodSelected(spec, &pods[i], namespaces[pods[i].Namespace]) {
+			selected = append(selected, fmt.Sprintf("%s/%s", pods[i].Namespace, pods[i].Name))
+		}
+	}
+	sort.Strings(selected)
+	return selected
+}
+
+// PodSelected returns true if the running pod is selected by one of the policy selectors
+func PodSelected(spec ciliumv2.CiliumEgressGatewayPolicySpec, pod *corev1.Pod, namespaceLabels labels.Set) bool {
+	if pod.Spec.HostNetwork || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return false
+	}
+	podLabels := labels.Set{}
+	for k, v := range pod.Labels {
+		podLabels[k] = v
+	}
+	podLabels[k8sConst.PodNamespaceLabel] = pod.Namespace
+	podLabels[k8sConst.PolicyLabelServiceAccount] = pod.Spec.ServiceAccountName
+
+	for _, rule := range spec.Selectors {
+		if egressRuleSelects(rule, podLabels, namespaceLabels) {
+			return true
+		}
+	}
+	return false
+}
+
+func egressRuleSelects(rule ciliumv2.EgressRule, podLabels labels.Set, namespaceLabels labels.Set) bool {
+	if rule.NamespaceSelector == nil && rule.PodSelector == nil {
+		return false
+	}
+	for _, check := range []struct {
+		selector *slimv1.LabelSelector
+		labels   labels.Set
+	}{
+		{rule.NamespaceSelector, namespaceLabels},
+		{rule.PodSelector, podLabels},
+	} {
+		if check.selector == nil {
+			continue
+		}
+		selector, err := slimv1.LabelSelectorAsSelector(check.selector)
+		if err != nil || !selector.Matches(check.labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// OverlappingDestinations returns the destination prefixes matched by both policies, once
+// the excluded CIDRs of either policy have been removed
+func OverlappingDestinations(a ciliumv2.CiliumEgressGatewayPolicySpec, b ciliumv2.CiliumEgressGatewayPolicySpec) []string {
+	excluded := append(parsePrefixes(a.ExcludedCIDRs), parsePrefixes(b.ExcludedCIDRs)...)
+	seen := map[string]bool{}
+	overlaps := []string{}
+
+	for _, da := range parsePrefixes(a.DestinationCIDRs) {
+		for _, db := range parsePrefixes(b.DestinationCIDRs) {
+			if !da.Overlaps(db) {
+				continue
+			}
+			narrower := da
+			if db.Bits() > da.Bits() {
+				narrower = db
+			}
+			if prefixExcluded(narrower, excluded) || seen[narrower.String()] {
+				continue
+			}
+			seen[narrower.String()] = true
+			overlaps = append(overlaps, narrower.String())
+		}
+	}
+	sort.Strings(overlaps)
+	return overlaps
+}
+
+// FindOverlaps returns the policies that select at least one pod of the given policy for
+// an overlapping destination, the selected pods are the ones of PolicySelections
+func FindOverlaps(policy EgressPolicyRef, others []EgressPolicyRef, selections map[string][]string) []EgressPolicyOverlap {
+	overlaps := []EgressPolicyOverlap{}
+	selected := selections[policy.String()]
+	if len(selected) == 0 {
+		return overlaps
+	}
+
+	for _, other := range others {
+		if other.Kind == policy.Kind && other.Name == policy.Name {
+			continue
+		}
+		destinations := OverlappingDestinations(policy.Spec, other.Spec)
+		if len(destinations) == 0 {
+			continue
+		}
+		otherSelected := map[string]bool{}
+		for _, pod := range selections[other.String()] {
+			otherSelected[pod] = true
+		}
+		common := []string{}
+		for _, pod := range selected {
+			if otherSelected[pod] {
+				common = append(common, pod)
+			}
+		}
+		if len(common) > 0 {
+			overlaps = append(overlaps, EgressPolicyOverlap{
+				Policy:       other,
+				Destinations: destinations,
+				Pods:         common,
+			})
+		}
+	}
+	return overlaps
+}
+
+func parsePrefixes(cidrs []ciliumv2.IPv4CIDR) []netip.Prefix {
+	prefixes := []netip.Prefix{}
+	for _, cidr := range cidrs {
+		if prefix, err := netip.ParsePrefix(string(cidr)); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+		}
+	}
+	return prefixes
+}
+
+func prefixExcluded(prefix netip.Prefix, excluded []netip.Prefix) bool {
+	for _, e := range excluded {
+		if e.Bits() <= prefix.Bits() && e.Contains(prefix.Addr()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package util
+
+import (
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"reflect"
+	"testing"
+)
+
+func podSelector(matchLabels map[string]slimv1.MatchLabelsValue) ciliumv2.EgressRule {
+	return ciliumv2.EgressRule{PodSelector: &slimv1.LabelSelector{MatchLabels: matchLabels}}
+}
+
+func TestPodSelected(t *testing.T) {
+	pod := func(namespace string, podLabels map[string]string, mutate func(*corev1.Pod)) *corev1.Pod {
+		p := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "pod", Labels: podLabels}}
+		p.Spec.ServiceAccountName = "default"
+		if mutate != nil {
+			mutate(p)
+		}
+		return p
+	}
+	namespaceLabels := labels.Set{"team": "a"}
+	tests := []struct {
+		name      string
+		selectors []ciliumv2.EgressRule
+		pod       *corev1.Pod
+		want      bool
+	}{
+		{name: "pod labels", selectors: []ciliumv2.EgressRule{podSelector(map[string]slimv1.MatchLabelsValue{"app": "web"})}, pod: pod("a", map[string]string{"app": "web"}, nil), want: true},
+		{name: "other labels", selectors: []ciliumv2.EgressRule{podSelector(map[string]slimv1.MatchLabelsValue{"app": "web"})}, pod: pod("a", map[string]string{"app": "db"}, nil), want: false},
+		{name: "pod namespace label", selectors: []ciliumv2.EgressRule{podSelector(map[string]slimv1.MatchLabelsValue{"io.kubernetes.pod.namespace": "a"})}, pod: pod("a", nil, nil), want: true},
+		{
+			name:      "namespace selector",
+			selectors: []ciliumv2.EgressRule{{NamespaceSelector: &slimv1.LabelSelector{MatchLabels: map[string]slimv1.MatchLabelsValue{"team": "a"}}}},
+			pod:       pod("a", nil, nil),
+			want:      true,
+		},
+		{name: "empty rule", selectors: []ciliumv2.EgressRule{{}}, pod: pod("a", nil, nil), want: false},
+		{
+			name:      "host network pod",
+			selectors: []ciliumv2.EgressRule{podSelector(map[string]slimv1.MatchLabelsValue{"app": "web"})},
+			pod:       pod("a", map[string]string{"app": "web"}, func(p *corev1.Pod) { p.Spec.HostNetwork = true }),
+			want:      false,
+		},
+		{
+			name:      "completed pod",
+			selectors: []ciliumv2.EgressRule{podSelector(map[string]slimv1.MatchLabelsValue{"app": "web"})},
+			pod:       pod("a", map[string]string{"app": "web"}, func(p *corev1.Pod) { p.Status.Phase = corev1.PodSucceeded }),
+			want:      false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := ciliumv2.CiliumEgressGatewayPolicySpec{Selectors: tt.selectors}
+			if got := PodSelected(spec, tt.pod, namespaceLabels); got != tt.want {
+				t.Errorf("PodSelected() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOverlappingDestinations(t *testing.T) {
+	tests := []struct {
+		name string
+		a    ciliumv2.CiliumEgressGatewayPolicySpec
+		b    ciliumv2.CiliumEgressGatewayPolicySpec
+		want []string
+	}{
+		{
+			name: "disjoint",
+			a:    ciliumv2.CiliumEgressGatewayPolicySpec{DestinationCIDRs: []ciliumv2.IPv4CIDR{"10.0.0.0/24"}},
+			b:    ciliumv2.CiliumEgressGatewayPolicySpec{DestinationCIDRs: []ciliumv2.IPv4CIDR{"10.0.1.0/24"}},
+			want: []string{},
+		},
+		{
+			name: "narrower prefix reported",
+			a:    ciliumv2.CiliumEgressGatewayPolicySpec{DestinationCIDRs: []ciliumv2.IPv4CIDR{"0.0.0.0/0"}},
+			b:    ciliumv2.CiliumEgressGatewayPolicySpec{DestinationCIDRs: []ciliumv2.IPv4CIDR{"10.0.1.7/24", "192.168.0.0/16"}},
+			want: []string{"10.0.1.0/24", "192.168.0.0/16"},
+		},
+		{
+			name: "excluded by either policy",
+			a:    ciliumv2.CiliumEgressGatewayPolicySpec{DestinationCIDRs: []ciliumv2.IPv4CIDR{"0.0.0.0/0"}, ExcludedCIDRs: []ciliumv2.IPv4CIDR{"10.0.0.0/8"}},
+			b:    ciliumv2.CiliumEgressGatewayPolicySpec{DestinationCIDRs: []ciliumv2.IPv4CIDR{"10.0.1.0/24"}},
+			want: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := OverlappingDestinations(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("OverlappingDestinations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindOverlaps(t *testing.T) {
+	pods := []corev1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "a", Name: "web", Labels: map[string]string{"app": "web", "tier": "front"}}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "a", Name: "db", Labels: map[string]string{"app": "db"}}},
+	}
+	web := EgressPolicyRef{Kind: "HAEgressGatewayPolicy", Name: "web", Spec: ciliumv2.CiliumEgressGatewayPolicySpec{
+		Selectors:        []ciliumv2.EgressRule{podSelector(map[string]slimv1.MatchLabelsValue{"app": "web"})},
+		DestinationCIDRs: []ciliumv2.IPv4CIDR{"10.0.0.0/8"},
+	}}
+	front := EgressPolicyRef{Kind: "CiliumEgressGatewayPolicy", Name: "front", Spec: ciliumv2.CiliumEgressGatewayPolicySpec{
+		Selectors:        []ciliumv2.EgressRule{podSelector(map[string]slimv1.MatchLabelsValue{"tier": "front"})},
+		DestinationCIDRs: []ciliumv2.IPv4CIDR{"10.1.0.0/16"},
+	}}
+	db := EgressPolicyRef{Kind: "HAEgressGatewayPolicy", Name: "db", Spec: ciliumv2.CiliumEgressGatewayPolicySpec{
+		Selectors:        []ciliumv2.EgressRule{podSelector(map[string]slimv1.MatchLabelsValue{"app": "db"})},
+		DestinationCIDRs: []ciliumv2.IPv4CIDR{"10.0.0.0/8"},
+	}}
+	policies := []EgressPolicyRef{web, front, db}
+	selections := PolicySelections(policies, pods, nil)
+
+	tests := []struct {
+		name   string
+		policy EgressPolicyRef
+		want   []EgressPolicyOverlap
+	}{
+		{
+			name:   "same pod and overlapping destination",
+			policy: web,
+			want:   []EgressPolicyOverlap{{Policy: front, Destinations: []string{"10.1.0.0/16"}, Pods: []string{"a/web"}}},
+		},
+		{name: "same destination on other pods", policy: db, want: []EgressPolicyOverlap{}},
+		{
+			name:   "policy without pods",
+			policy: EgressPolicyRef{Kind: "HAEgressGatewayPolicy", Name: "none", Spec: db.Spec},
+			want:   []EgressPolicyOverlap{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FindOverlaps(tt.policy, policies, selections); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FindOverlaps() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package util
+
+import (
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	corev1 "k8s.io/api/core/v1"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PolicyPreferredNodes returns the nodes listed in the preferred-nodes annotation
+func PolicyPreferredNodes(haEgressGatewayPolicy *v2.HAEgressGatewayPolicy) []string {
+	nodes := []string{}
+	for _, node := range strings.Split(haEgressGatewayPolicy.Annotations[haegressip.PreferredNodesAnnotation], ",") {
+		if node = strings.TrimSpace(node); node != "" {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// PolicyZoneAware returns true if the policy places its egress IPs in the zone running most
+// of the selected pods
+func PolicyZoneAware(haEgressGatewayPolicy *v2.HAEgressGatewayPolicy) bool {
+	zoneAware, err := strconv.ParseBool(haEgressGatewayPolicy.Annotations[haegressip.ZoneAwareAnnotation])
+	return err == nil && zoneAware
+}
+
+// ElectPreferredZone returns the zone with a gateway node running most of the pods of a
+// zone-aware policy. The current zone is kept while it has a gateway node, unless the elected
+// one runs more than margin times its pods more; replace is false in this case, when the
+// change has to be confirmed for a while, and true when the current zone can no longer be used.
+func ElectPreferredZone(distribution []v2.HAEgressGatewayPolicyZoneStatus, current string, margin float64) (zone string, replace bool) {
+	var elected *v2.HAEgressGatewayPolicyZoneStatus
+	var kept *v2.HAEgressGatewayPolicyZoneStatus
+	for i := range distribution {
+		status := &distribution[i]
+		if status.GatewayNodes == 0 {
+			continue
+		}
+		if status.Pods > 0 && (elected == nil || status.Pods > elected.Pods) {
+			elected = status
+		}
+		if status.Zone == current {
+			kept = status
+		}
+	}
+	switch {
+	case kept == nil || kept.Pods == 0:
+		// The current zone has no gateway node or no pod anymore
+		if elected == nil {
+			return "", true
+		}
+		return elected.Zone, true
+	case elected == nil || elected.Zone == current || float64(elected.Pods) <= float64(kept.Pods)*(1+margin):
+		return current, true
+	default:
+		return elected.Zone, false
+	}
+}
+
+// PolicyPreferredZone returns the zone declared with the preferred-zone annotation or, for
+// zone-aware policies, the zone running most of the selected pods
+func PolicyPreferredZone(haEgressGatewayPolicy *v2.HAEgressGatewayPolicy) string {
+	if zone := strings.TrimSpace(haEgressGatewayPolicy.Annotations[haegressip.PreferredZoneAnnotation]); zone != "" {
+		return zone
+	}
+	if PolicyZoneAware(haEgressGatewayPolicy) {
+		return haEgressGatewayPolicy.Status.PreferredZone
+	}
+	return ""
+}
+
+// PolicyHasPreference returns true if the policy declares preferred nodes or a preferred zone
+func PolicyHasPreference(haEgressGatewayPolicy *v2.HAEgressGatewayPolicy) bool {
+	return len(PolicyPreferredNodes(haEgressGatewayPolicy)) > 0 || PolicyPreferredZone(haEgressGatewayPolicy) != ""
+}
+
+// NodePreferred returns true if the node is one of the preferred nodes or belongs to the
+// preferred zone of the policy
+func NodePreferred(haEgressGatewayPolicy *v2.HAEgressGatewayPolicy, node *corev1.Node) bool {
+	for _, preferred := range PolicyPreferredNodes(haEgressGatewayPolicy) {
+		if preferred == node.Name {
+			return true
+		}
+	}
+	zone := PolicyPreferredZone(haEgressGatewayPolicy)
+	return zone != "" && node.Labels[corev1.LabelTopologyZone] == zone
+}
+
+// PolicyFailbackStableFor returns how long a preferred node must be ready before the VIP
+// fails back to it
+func PolicyFailbackStableFor(haEgressGatewayPolicy *v2.HAEgressGatewayPolicy) time.Duration {
+	stableFor, err := time.ParseDuration(haEgressGatewayPolicy.Annotations[haegressip.FailbackStableForAnnotation])
+	if err != nil || stableFor < 0 {
+		return haegressip.DefaultFailbackStableFor
+	}
+	return stableFor
+}
+
+// PolicyFailbackWindow returns the daily window where the VIP can fail back, always open
+// when the annotation is absent
+func PolicyFailbackWindow(haEgressGatewayPolicy *v2.HAEgressGatewayPolicy) (TimeWindow, error) {
+	return ParseTimeWindow(haEgressGatewayPolicy.Annotations[haegressip.FailbackWindowAnnotation])
+}
+
+// PolicyHeld returns true if the policy has been moved on request and is kept on its node
+// until the time of the hold-until annotation, the rebalancer and the failback leave it alone
+func PolicyHeld(haEgressGatewayPolicy *v2.HAEgressGatewayPolicy, now time.Time) bool {
+	holdUntil, err := time.Parse(time.RFC3339, haEgressGatewayPolicy.Annotations[haegressip.HoldUntilAnnotation])
+	return err == nil && now.Before(holdUntil)
+}
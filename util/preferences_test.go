@@ -0,0 +1,77 @@
+package util
+
+import (
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+func TestElectPreferredZone(t *testing.T) {
+	distribution := []v2.HAEgressGatewayPolicyZoneStatus{
+		{Zone: "a", Pods: 10, GatewayNodes: 1},
+		{Zone: "b", Pods: 11, GatewayNodes: 2},
+		{Zone: "c", Pods: 30, GatewayNodes: 0},
+		{Zone: "d", Pods: 0, GatewayNodes: 1},
+	}
+	tests := []struct {
+		name         string
+		distribution []v2.HAEgressGatewayPolicyZoneStatus
+		current      string
+		wantZone     string
+		wantReplace  bool
+	}{
+		{name: "no current zone", distribution: distribution, wantZone: "b", wantReplace: true},
+		{name: "current zone kept within the margin", distribution: distribution, current: "a", wantZone: "a", wantReplace: true},
+		{name: "current zone without gateway node", distribution: distribution, current: "c", wantZone: "b", wantReplace: true},
+		{name: "current zone without pods", distribution: distribution, current: "d", wantZone: "b", wantReplace: true},
+		{name: "current zone gone", distribution: distribution, current: "e", wantZone: "b", wantReplace: true},
+		{
+			name: "other zone above the margin",
+			distribution: []v2.HAEgressGatewayPolicyZoneStatus{
+				{Zone: "a", Pods: 10, GatewayNodes: 1},
+				{Zone: "b", Pods: 13, GatewayNodes: 1},
+			},
+			current:     "a",
+			wantZone:    "b",
+			wantReplace: false,
+		},
+		{name: "no zone", current: "a", wantZone: "", wantReplace: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zone, replace := ElectPreferredZone(tt.distribution, tt.current, 0.2)
+			if zone != tt.wantZone || replace != tt.wantReplace {
+				t.Errorf("ElectPreferredZone() = %q, %v, want %q, %v", zone, replace, tt.wantZone, tt.wantReplace)
+			}
+		})
+	}
+}
+
+func TestPolicyHeld(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		holdUntil string
+		want      bool
+	}{
+		{"no annotation", "", false},
+		{"held", "2024-03-01T13:00:00Z", true},
+		{"held with an offset", "2024-03-01T13:30:00+01:00", true},
+		{"expired", "2024-03-01T11:59:59Z", false},
+		{"until now", "2024-03-01T12:00:00Z", false},
+		{"malformed", "in an hour", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &v2.HAEgressGatewayPolicy{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+			if tt.holdUntil != "" {
+				policy.Annotations[haegressip.HoldUntilAnnotation] = tt.holdUntil
+			}
+			if got := PolicyHeld(policy, now); got != tt.want {
+				t.Errorf("PolicyHeld() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
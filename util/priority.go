@@ -0,0 +1,42 @@
+package util
+
+import (
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"strings"
+)
+
+// PriorityClass orders the failovers of the policies when many VIPs move at once
+type PriorityClass string
+
+const (
+	PriorityClassCritical PriorityClass = "critical"
+	PriorityClassHigh     PriorityClass = "high"
+	PriorityClassNormal   PriorityClass = "normal"
+	PriorityClassLow      PriorityClass = "low"
+)
+
+// Rank returns the order of the class, higher ranks are followed first
+func (c PriorityClass) Rank() int {
+	switch c {
+	case PriorityClassCritical:
+		return 3
+	case PriorityClassHigh:
+		return 2
+	case PriorityClassLow:
+		return 0
+	default:
+		return 1
+	}
+}
+
+// PolicyPriorityClass returns the class declared with the priority-class annotation, normal
+// when missing or unknown
+func PolicyPriorityClass(haEgressGatewayPolicy *v2.HAEgressGatewayPolicy) PriorityClass {
+	switch class := PriorityClass(strings.ToLower(strings.TrimSpace(haEgressGatewayPolicy.Annotations[haegressip.PriorityClassAnnotation]))); class {
+	case PriorityClassCritical, PriorityClassHigh, PriorityClassLow:
+		return class
+	default:
+		return PriorityClassNormal
+	}
+}
@@ -0,0 +1,257 @@
+package util
+
+import (
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/config"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RenderOptions are the operator settings used to generate the objects of a policy
+type RenderOptions struct {
+	// EgressNamespace is the default namespace of the services
+	EgressNamespace string
+	// LoadBalancerClass is the class of the services
+	LoadBalancerClass string
+	// Propagation filters the labels and annotations copied from the policy
+	Propagation config.PropagationConfiguration
+	// ServiceTemplate customizes every Service
+	ServiceTemplate v2.ServiceTemplate
+	// ServiceClasses are the Service templates selected by the policies
+	ServiceClasses map[string]v2.ServiceTemplate
+}
+
+// PropagatedMap copies the labels or annotations selected by the rules
+func PropagatedMap(m map[string]string, rules config.FilterRules) map[string]string {
+	copied := make(map[string]string, len(m))
+	for k, v := range m {
+		if rules.Propagated(k) {
+			copied[k] = v
+		}
+	}
+	return copied
+}
+
+// propagateMetadata copies the labels and annotations of the policy selected by the rules, and
+// the ones of the template over them, to the object and records the copied keys in its
+// annotations. The records are set even when empty, an object without them has been generated
+// before the propagation rules.
+func propagateMetadata(object metav1.Object, haEgressGatewayPolicy *v2.HAEgressGatewayPolicy, rules config.PropagationRules, template v2.ServiceTemplateMetadata) {
+	labels := PropagatedMap(haEgressGatewayPolicy.Labels, rules.Labels)
+	annotations := PropagatedMap(haEgressGatewayPolicy.Annotations, rules.Annotations)
+	for k, v := range template.Labels {
+		labels[k] = v
+	}
+	for k, v := range template.Annotations {
+		annotations[k] = v
+	}
+	labelKeys, annotationKeys := sortedKeys(labels), sortedKeys(annotations)
+	annotations[haegressip.PropagatedLabelsAnnotation] = labelKeys
+	annotations[haegressip.PropagatedAnnotationsAnnotation] = annotationKeys
+	object.SetLabels(labels)
+	object.SetAnnotations(annotations)
+}
+
+// SyncPropagatedMetadata applies the labels and annotations of the rendered object to the
+// existing one and removes the ones copied from the policy before and no longer rendered, the
+// keys set by the users or by other controllers are kept. An object generated before the
+// propagation rules has no record of the copied keys, every key shared with the policy was
+// copied then, so the shared keys no longer rendered are removed once. It returns true if the
+// existing object has changed.
+func SyncPropagatedMetadata(existing metav1.Object, rendered metav1.Object, haEgressGatewayPolicy *v2.HAEgressGatewayPolicy) bool {
+	previous := existing.GetAnnotations()
+	labels, labelsChanged := syncPropagatedMap(existing.GetLabels(), rendered.GetLabels(),
+		previousKeys(previous, haegressip.PropagatedLabelsAnnotation, existing.GetLabels(), haEgressGatewayPolicy.Labels))
+	annotations, annotationsChanged := syncPropagatedMap(existing.GetAnnotations(), rendered.GetAnnotations(),
+		previousKeys(previous, haegressip.PropagatedAnnotationsAnnotation, existing.GetAnnotations(), haEgressGatewayPolicy.Annotations))
+	existing.SetLabels(labels)
+	existing.SetAnnotations(annotations)
+	return labelsChanged || annotationsChanged
+}
+
+// previousKeys returns the keys copied to an existing object: the recorded ones or, without
+// record, the keys it shares with the policy
+func previousKeys(annotations map[string]string, record string, existing map[string]string, parent map[string]string) []string {
+	if recorded, ok := annotations[record]; ok {
+		return strings.Split(recorded, ",")
+	}
+	shared := []string{}
+	for key := range existing {
+		if _, ok := parent[key]; ok {
+			shared = append(shared, key)
+		}
+	}
+	return shared
+}
+
+func syncPropagatedMap(existing map[string]string, rendered map[string]string, previous []string) (map[string]string, bool) {
+	synced := CopyStringMap(existing)
+	changed := false
+	for _, key := range previous {
+		if _, ok := rendered[key]; ok {
+			continue
+		}
+		if _, ok := synced[key]; ok {
+			delete(synced, key)
+			changed = true
+		}
+	}
+	for k, v := range rendered {
+		if current, ok := synced[k]; !ok || current != v {
+			synced[k] = v
+			changed = true
+		}
+	}
+	return synced, changed
+}
+
+// sortedKeys returns the comma separated sorted keys of the map
+func sortedKeys(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+// RenderService returns the LoadBalancer service requesting the egress IP of a shard of the
+// policy, the service selects no pod and exists only to get an IP announced by the provider.
+// The Service templates are merged over the defaults, the fields the operator relies on are
+// set after them.
+func RenderService(haEgressGatewayPolicy *v2.HAEgressGatewayPolicy, shard int, options RenderOptions, scheme *runtime.Scheme) (*corev1.Service, error) {
+	serviceNamespace := ServiceNamespace(haEgressGatewayPolicy, options.EgressNamespace)
+	loadBalancerClass := options.LoadBalancerClass
+	template, err := PolicyServiceTemplate(haEgressGatewayPolicy, options)
+	if err != nil {
+		return nil, err
+	}
+	internalTrafficPolicy := corev1.ServiceInternalTrafficPolicyCluster
+	allocateLoadBalancerNodePorts := true
+
+	// Define the service, the labels and annotations of the HAEgressGatewayPolicy instance
+	// selected by the propagation rules are copied below
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ShardServiceName(haEgressGatewayPolicy.Name, shard),
+			Namespace: serviceNamespace,
+		},
+		Spec: corev1.ServiceSpec{
+			LoadBalancerClass: &loadBalancerClass,
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "nope",
+					Protocol:   corev1.ProtocolTCP,
+					Port:       65534,
+					TargetPort: intstr.FromInt32(65534),
+				},
+			},
+			// The defaults of the API server, so that a field removed from the templates is reset
+			ExternalTrafficPolicy:         corev1.ServiceExternalTrafficPolicyCluster,
+			InternalTrafficPolicy:         &internalTrafficPolicy,
+			AllocateLoadBalancerNodePorts: &allocateLoadBalancerNodePorts,
+			Type:                          corev1.ServiceTypeLoadBalancer,
+			// Points nowhere, is a serviceless service used to create the IP object
+			Selector: map[string]string{
+				haegressip.HAEgressGatewayPolicyNamespace: serviceNamespace,
+				haegressip.HAEgressGatewayPolicyName:      haEgressGatewayPolicy.Name,
+			},
+		},
+	}
+
+	propagateMetadata(service, haEgressGatewayPolicy, options.Propagation.Service, template.Metadata)
+	applyServiceTemplateSpec(&service.Spec, template.Spec)
+
+	// The ip-families and ip-family-policy annotations pin the Service to IPv4 single-stack on
+	// dual-stack clusters, ValidatePolicyIPFamilies rejects any other value
+	if haEgressGatewayPolicy.Annotations[haegressip.IPFamiliesAnnotation] != "" {
+		service.Spec.IPFamilies = []corev1.IPFamily{corev1.IPv4Protocol}
+	}
+	if haEgressGatewayPolicy.Annotations[haegressip.IPFamilyPolicyAnnotation] != "" {
+		policy := corev1.IPFamilyPolicySingleStack
+		service.Spec.IPFamilyPolicy = &policy
+	}
+
+	// Each shard requests its own IP when the policy has more replicas
+	if replicas := PolicyReplicas(haEgressGatewayPolicy); replicas > 1 {
+		service.Labels[haegressip.ShardIndexLabel] = strconv.Itoa(shard)
+		if requested, ok := service.Annotations[haegressip.KubeVIPLoadBalancerIPsAnnotation]; ok {
+			if ip := ShardLoadBalancerIPs(requested, shard, replicas); ip != "" {
+				service.Annotations[haegressip.KubeVIPLoadBalancerIPsAnnotation] = ip
+			} else {
+				delete(service.Annotations, haegressip.KubeVIPLoadBalancerIPsAnnotation)
+			}
+		}
+	}
+
+	// Avoid L2 announcement by Cilium
+	service.Labels[haegressip.KubernetesServiceProxyNameAnnotation] = "kubevip-managed-by-cilium-haegess"
+	service.Labels[haegressip.HAEgressGatewayPolicyNamespace] = serviceNamespace
+	service.Labels[haegressip.HAEgressGatewayPolicyName] = haEgressGatewayPolicy.Name
+
+	// Set HAEgressGatewayPolicy instance as the owner and controller
+	if err := controllerutil.SetControllerReference(haEgressGatewayPolicy, service, scheme); err != nil {
+		return nil, err
+	}
+	return service, nil
+}
+
+// RenderCiliumEgressGatewayPolicy returns the CiliumEgressGatewayPolicy of a shard of the
+// policy. The gateway node is the one of the policy nodeSelector, it is patched later to
+// follow the VIP.
+func RenderCiliumEgressGatewayPolicy(haEgressGatewayPolicy *v2.HAEgressGatewayPolicy, shard int, options RenderOptions, scheme *runtime.Scheme) (*ciliumv2.CiliumEgressGatewayPolicy, error) {
+	serviceNamespace := ServiceNamespace(haEgressGatewayPolicy, options.EgressNamespace)
+	serviceName := ShardServiceName(haEgressGatewayPolicy.Name, shard)
+
+	ciliumEgressGatewayPolicy := &ciliumv2.CiliumEgressGatewayPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: CiliumEgressGatewayPolicyName(serviceNamespace, serviceName),
+		},
+	}
+	propagateMetadata(ciliumEgressGatewayPolicy, haEgressGatewayPolicy, options.Propagation.CiliumEgressGatewayPolicy, v2.ServiceTemplateMetadata{})
+
+	spec := *haEgressGatewayPolicy.Spec.CiliumEgressGatewayPolicySpec.DeepCopy()
+	if replicas := PolicyReplicas(haEgressGatewayPolicy); replicas > 1 {
+		spec.Selectors = ShardSelectors(spec.Selectors, haEgressGatewayPolicy.Name, shard, replicas)
+		ciliumEgressGatewayPolicy.Labels[haegressip.ShardIndexLabel] = strconv.Itoa(shard)
+	}
+
+	ciliumEgressGatewayPolicy.Spec = spec
+
+	// Set HAEgressGatewayPolicy instance as the owner and controller
+	if err := controllerutil.SetControllerReference(haEgressGatewayPolicy, ciliumEgressGatewayPolicy, scheme); err != nil {
+		return nil, err
+	}
+	return ciliumEgressGatewayPolicy, nil
+}
+
+// RenderPolicy returns every object generated for the policy: the CiliumEgressGatewayPolicies
+// and the Service of each shard
+func RenderPolicy(haEgressGatewayPolicy *v2.HAEgressGatewayPolicy, options RenderOptions, scheme *runtime.Scheme) ([]client.Object, error) {
+	if err := ValidatePolicyIPFamilies(haEgressGatewayPolicy); err != nil {
+		return nil, err
+	}
+	objects := []client.Object{}
+	for shard := 0; shard < PolicyReplicas(haEgressGatewayPolicy); shard++ {
+		ciliumEgressGatewayPolicy, err := RenderCiliumEgressGatewayPolicy(haEgressGatewayPolicy, shard, options, scheme)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, ciliumEgressGatewayPolicy)
+		service, err := RenderService(haEgressGatewayPolicy, shard, options, scheme)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, service)
+	}
+	return objects, nil
+}
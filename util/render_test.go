@@ -0,0 +1,206 @@
+package util
+
+import (
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"reflect"
+	"testing"
+)
+
+func TestPropagatedMap(t *testing.T) {
+	m := map[string]string{
+		"team":                         "network",
+		"app.kubernetes.io/managed-by": "Helm",
+		"example.com/cost-center":      "42",
+	}
+	tests := []struct {
+		name  string
+		rules config.FilterRules
+		want  map[string]string
+	}{
+		{
+			name: "well-known keys skipped",
+			want: map[string]string{"team": "network", "example.com/cost-center": "42"},
+		},
+		{
+			name:  "include prefix",
+			rules: config.FilterRules{Include: []string{"example.com/"}},
+			want:  map[string]string{"example.com/cost-center": "42"},
+		},
+		{
+			name:  "exclude wins",
+			rules: config.FilterRules{Include: []string{"example.com/"}, Exclude: []string{"example.com/cost-center"}},
+			want:  map[string]string{},
+		},
+		{
+			name:  "well-known key included by exact key",
+			rules: config.FilterRules{Include: []string{"app.kubernetes.io/managed-by"}},
+			want:  map[string]string{"app.kubernetes.io/managed-by": "Helm"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PropagatedMap(m, tt.rules); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PropagatedMap() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncPropagatedMetadata(t *testing.T) {
+	policy := &v2.HAEgressGatewayPolicy{ObjectMeta: metav1.ObjectMeta{
+		Labels:      map[string]string{"team": "network", "argocd.argoproj.io/instance": "egress"},
+		Annotations: map[string]string{"kubectl.kubernetes.io/last-applied-configuration": "{}"},
+	}}
+	rendered := &corev1.Service{ObjectMeta: metav1.ObjectMeta{
+		Labels: map[string]string{"team": "network"},
+		Annotations: map[string]string{
+			haegressip.PropagatedLabelsAnnotation:      "team",
+			haegressip.PropagatedAnnotationsAnnotation: "",
+		},
+	}}
+	tests := []struct {
+		name            string
+		labels          map[string]string
+		annotations     map[string]string
+		wantLabels      map[string]string
+		wantAnnotations map[string]string
+		wantChanged     bool
+	}{
+		{
+			name:            "in sync",
+			labels:          map[string]string{"team": "network"},
+			annotations:     rendered.Annotations,
+			wantLabels:      map[string]string{"team": "network"},
+			wantAnnotations: rendered.Annotations,
+		},
+		{
+			name:   "recorded keys no longer rendered removed, foreign keys kept",
+			labels: map[string]string{"team": "network", "owner": "alice", "other": "x"},
+			annotations: map[string]string{
+				haegressip.PropagatedLabelsAnnotation:      "owner,team",
+				haegressip.PropagatedAnnotationsAnnotation: "",
+				haegressip.KubeVIPVipHostAnnotation:        "node-1",
+			},
+			wantLabels: map[string]string{"team": "network", "other": "x"},
+			wantAnnotations: map[string]string{
+				haegressip.PropagatedLabelsAnnotation:      "team",
+				haegressip.PropagatedAnnotationsAnnotation: "",
+				haegressip.KubeVIPVipHostAnnotation:        "node-1",
+			},
+			wantChanged: true,
+		},
+		{
+			name:   "legacy child without record",
+			labels: map[string]string{"team": "network", "argocd.argoproj.io/instance": "egress", "other": "x"},
+			annotations: map[string]string{
+				"kubectl.kubernetes.io/last-applied-configuration": "{}",
+				haegressip.KubeVIPVipHostAnnotation:                "node-1",
+			},
+			wantLabels: map[string]string{"team": "network", "other": "x"},
+			wantAnnotations: map[string]string{
+				haegressip.PropagatedLabelsAnnotation:      "team",
+				haegressip.PropagatedAnnotationsAnnotation: "",
+				haegressip.KubeVIPVipHostAnnotation:        "node-1",
+			},
+			wantChanged: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			existing := &corev1.Service{ObjectMeta: metav1.ObjectMeta{
+				Labels:      CopyStringMap(tt.labels),
+				Annotations: CopyStringMap(tt.annotations),
+			}}
+			if got := SyncPropagatedMetadata(existing, rendered, policy); got != tt.wantChanged {
+				t.Errorf("SyncPropagatedMetadata() = %v, want %v", got, tt.wantChanged)
+			}
+			if !reflect.DeepEqual(existing.Labels, tt.wantLabels) {
+				t.Errorf("labels = %v, want %v", existing.Labels, tt.wantLabels)
+			}
+			if !reflect.DeepEqual(existing.Annotations, tt.wantAnnotations) {
+				t.Errorf("annotations = %v, want %v", existing.Annotations, tt.wantAnnotations)
+			}
+		})
+	}
+}
+
+func TestRenderService(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := v2.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	options := RenderOptions{EgressNamespace: "egress", LoadBalancerClass: "kube-vip.io/kube-vip-class"}
+	tests := []struct {
+		name            string
+		annotations     map[string]string
+		shard           int
+		wantName        string
+		wantLabels      map[string]string
+		wantAnnotations map[string]string
+	}{
+		{
+			name:        "single replica",
+			annotations: map[string]string{haegressip.KubeVIPLoadBalancerIPsAnnotation: "10.0.0.1"},
+			wantName:    "policy",
+			wantLabels: map[string]string{
+				haegressip.KubernetesServiceProxyNameAnnotation: "kubevip-managed-by-cilium-haegess",
+				haegressip.HAEgressGatewayPolicyNamespace:       "egress",
+				haegressip.HAEgressGatewayPolicyName:            "policy",
+			},
+			wantAnnotations: map[string]string{
+				haegressip.KubeVIPLoadBalancerIPsAnnotation: "10.0.0.1",
+				haegressip.PropagatedLabelsAnnotation:       "",
+				haegressip.PropagatedAnnotationsAnnotation:  haegressip.KubeVIPLoadBalancerIPsAnnotation,
+			},
+		},
+		{
+			name: "second shard",
+			annotations: map[string]string{
+				haegressip.KubeVIPLoadBalancerIPsAnnotation: "10.0.0.1,10.0.0.2",
+				haegressip.ReplicasAnnotation:               "2",
+			},
+			shard:    1,
+			wantName: ShardServiceName("policy", 1),
+			wantLabels: map[string]string{
+				haegressip.KubernetesServiceProxyNameAnnotation: "kubevip-managed-by-cilium-haegess",
+				haegressip.HAEgressGatewayPolicyNamespace:       "egress",
+				haegressip.HAEgressGatewayPolicyName:            "policy",
+				haegressip.ShardIndexLabel:                      "1",
+			},
+			wantAnnotations: map[string]string{
+				haegressip.KubeVIPLoadBalancerIPsAnnotation: "10.0.0.2",
+				haegressip.PropagatedLabelsAnnotation:       "",
+				haegressip.PropagatedAnnotationsAnnotation:  haegressip.KubeVIPLoadBalancerIPsAnnotation,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &v2.HAEgressGatewayPolicy{ObjectMeta: metav1.ObjectMeta{Name: "policy", Annotations: tt.annotations}}
+			service, err := RenderService(policy, tt.shard, options, scheme)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if service.Name != tt.wantName || service.Namespace != "egress" {
+				t.Errorf("service = %s/%s, want egress/%s", service.Namespace, service.Name, tt.wantName)
+			}
+			if !reflect.DeepEqual(service.Labels, tt.wantLabels) {
+				t.Errorf("labels = %v, want %v", service.Labels, tt.wantLabels)
+			}
+			if !reflect.DeepEqual(service.Annotations, tt.wantAnnotations) {
+				t.Errorf("annotations = %v, want %v", service.Annotations, tt.wantAnnotations)
+			}
+			if service.Spec.Type != corev1.ServiceTypeLoadBalancer || *service.Spec.LoadBalancerClass != options.LoadBalancerClass {
+				t.Errorf("spec = %v, want a LoadBalancer of class %s", service.Spec, options.LoadBalancerClass)
+			}
+			if len(service.OwnerReferences) != 1 || service.OwnerReferences[0].Name != "policy" {
+				t.Errorf("ownerReferences = %v, want the policy", service.OwnerReferences)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package util
+
+import (
+	"fmt"
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	"hash/fnv"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"strings"
+)
+
+// DefaultInstanceID is the identity of an operator without scope, it is the historical
+// leader election ID
+const DefaultInstanceID = "cilium-haegress-operator.angeloxx.ch"
+
+// Scope restricts an operator instance to the policies matching a label selector and to the
+// services of some namespaces, so that several instances, for example one per kube-vip
+// class, share a cluster
+type Scope struct {
+	// PolicySelector selects the policies of the instance, nil selects every policy
+	PolicySelector labels.Selector
+	// ServiceNamespaces are the namespaces of the services, empty means every namespace
+	ServiceNamespaces []string
+}
+
+// ParseScope parses the --policy-selector and --service-namespaces options
+func ParseScope(policySelector string, serviceNamespaces string) (Scope, error) {
+	scope := Scope{}
+	if strings.TrimSpace(policySelector) != "" {
+		selector, err := labels.Parse(policySelector)
+		if err != nil {
+			return scope, fmt.Errorf("invalid policy selector %q: %w", policySelector, err)
+		}
+		scope.PolicySelector = selector
+	}
+	for _, namespace := range strings.Split(serviceNamespaces, ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			scope.ServiceNamespaces = append(scope.ServiceNamespaces, namespace)
+		}
+	}
+	sort.Strings(scope.ServiceNamespaces)
+	return scope, nil
+}
+
+// Empty returns true if the instance sees every policy and service
+func (s Scope) Empty() bool {
+	return (s.PolicySelector == nil || s.PolicySelector.Empty()) && len(s.ServiceNamespaces) == 0
+}
+
+// ServiceNamespaceAllowed returns true if the services of the namespace are seen
+func (s Scope) ServiceNamespaceAllowed(namespace string) bool {
+	if len(s.ServiceNamespaces) == 0 {
+		return true
+	}
+	for _, allowed := range s.ServiceNamespaces {
+		if allowed == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// InstanceID identifies the instance, it is derived from the scope so that the instances
+// with different scopes have their own leader election. The instance without scope keeps
+// the DefaultInstanceID.
+func (s Scope) InstanceID() string {
+	if s.Empty() {
+		return DefaultInstanceID
+	}
+	h := fnv.New32a()
+	if s.PolicySelector != nil {
+		_, _ = h.Write([]byte(s.PolicySelector.String()))
+	}
+	_, _ = h.Write([]byte("/" + strings.Join(s.ServiceNamespaces, ",")))
+	return fmt.Sprintf("cilium-haegress-operator-%08x.angeloxx.ch", h.Sum32())
+}
+
+// CacheOptions restricts the manager cache to the policies and services of the instance
+func (s Scope) CacheOptions() cache.Options {
+	byObject := map[client.Object]cache.ByObject{}
+	if s.PolicySelector != nil && !s.PolicySelector.Empty() {
+		byObject[&v2.HAEgressGatewayPolicy{}] = cache.ByObject{Label: s.PolicySelector}
+	}
+	if len(s.ServiceNamespaces) > 0 {
+		namespaces := map[string]cache.Config{}
+		for _, namespace := range s.ServiceNamespaces {
+			namespaces[namespace] = cache.Config{}
+		}
+		byObject[&corev1.Service{}] = cache.ByObject{Namespaces: namespaces}
+	}
+	return cache.Options{ByObject: byObject}
+}
@@ -0,0 +1,92 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseScope(t *testing.T) {
+	tests := []struct {
+		name              string
+		policySelector    string
+		serviceNamespaces string
+		wantSelector      string
+		wantNamespaces    []string
+		wantEmpty         bool
+		wantErr           bool
+	}{
+		{name: "no scope", wantEmpty: true},
+		{name: "policy selector", policySelector: "class=internal", wantSelector: "class=internal"},
+		{name: "service namespaces", serviceNamespaces: " egress-b,egress-a,, ", wantNamespaces: []string{"egress-a", "egress-b"}},
+		{name: "invalid selector", policySelector: "class in (", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseScope(tt.policySelector, tt.serviceNamespaces)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseScope() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			selector := ""
+			if got.PolicySelector != nil {
+				selector = got.PolicySelector.String()
+			}
+			if selector != tt.wantSelector {
+				t.Errorf("ParseScope() selector = %q, want %q", selector, tt.wantSelector)
+			}
+			if !reflect.DeepEqual(got.ServiceNamespaces, tt.wantNamespaces) {
+				t.Errorf("ParseScope() namespaces = %v, want %v", got.ServiceNamespaces, tt.wantNamespaces)
+			}
+			if got.Empty() != tt.wantEmpty {
+				t.Errorf("Empty() = %v, want %v", got.Empty(), tt.wantEmpty)
+			}
+		})
+	}
+}
+
+func TestScopeServiceNamespaceAllowed(t *testing.T) {
+	tests := []struct {
+		name       string
+		namespaces []string
+		namespace  string
+		want       bool
+	}{
+		{name: "every namespace", namespace: "egress-a", want: true},
+		{name: "listed", namespaces: []string{"egress-a", "egress-b"}, namespace: "egress-b", want: true},
+		{name: "not listed", namespaces: []string{"egress-a"}, namespace: "egress-b", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scope := Scope{ServiceNamespaces: tt.namespaces}
+			if got := scope.ServiceNamespaceAllowed(tt.namespace); got != tt.want {
+				t.Errorf("ServiceNamespaceAllowed(%q) = %v, want %v", tt.namespace, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScopeInstanceID(t *testing.T) {
+	scope := func(policySelector string, serviceNamespaces string) Scope {
+		s, err := ParseScope(policySelector, serviceNamespaces)
+		if err != nil {
+			t.Fatalf("ParseScope() error = %v", err)
+		}
+		return s
+	}
+	if got := scope("", "").InstanceID(); got != DefaultInstanceID {
+		t.Errorf("InstanceID() without scope = %q, want %q", got, DefaultInstanceID)
+	}
+
+	// The order of the namespaces does not change the identity, any other scope does
+	internal := scope("class=internal", "egress-a,egress-b")
+	if got, want := scope("class=internal", "egress-b,egress-a").InstanceID(), internal.InstanceID(); got != want {
+		t.Errorf("InstanceID() = %q, want %q for the same scope", got, want)
+	}
+	for _, other := range []Scope{scope("class=external", "egress-a,egress-b"), scope("class=internal", "egress-a"), scope("", "egress-a,egress-b")} {
+		if other.InstanceID() == internal.InstanceID() || other.InstanceID() == DefaultInstanceID {
+			t.Errorf("InstanceID() = %q for scopes %v and %v", other.InstanceID(), other, internal)
+		}
+	}
+}
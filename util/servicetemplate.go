@@ -0,0 +1,143 @@
+package util
+
+import (
+	"fmt"
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"reflect"
+)
+
+// PolicyServiceTemplate returns the Service template of the policy: the template of the
+// operator, the one of the class of the policy and the one of the policy merged in this order.
+// An unknown class or an invalid template is a permanent error.
+func PolicyServiceTemplate(haEgressGatewayPolicy *v2.HAEgressGatewayPolicy, options RenderOptions) (v2.ServiceTemplate, error) {
+	template := *options.ServiceTemplate.DeepCopy()
+	policyTemplate := haEgressGatewayPolicy.Spec.ServiceTemplate
+	if policyTemplate == nil {
+		return template, nil
+	}
+	if policyTemplate.ClassName != "" {
+		class, ok := options.ServiceClasses[policyTemplate.ClassName]
+		if !ok {
+			return template, NewPermanentError("UnknownServiceClass",
+				fmt.Errorf("the service class %q is not configured", policyTemplate.ClassName))
+		}
+		mergeServiceTemplate(&template, class)
+	}
+	if errs := config.ValidateServiceTemplate(&policyTemplate.ServiceTemplate, field.NewPath("spec", "serviceTemplate")); len(errs) > 0 {
+		return template, NewPermanentError("InvalidServiceTemplate", errs.ToAggregate())
+	}
+	mergeServiceTemplate(&template, policyTemplate.ServiceTemplate)
+	return template, nil
+}
+
+// mergeServiceTemplate merges the template over the previous one: the labels and annotations
+// are added, the spec fields set replace the previous ones
+func mergeServiceTemplate(merged *v2.ServiceTemplate, template v2.ServiceTemplate) {
+	template = *template.DeepCopy()
+	if len(template.Metadata.Labels) > 0 {
+		merged.Metadata.Labels = CopyStringMap(merged.Metadata.Labels)
+		for k, v := range template.Metadata.Labels {
+			merged.Metadata.Labels[k] = v
+		}
+	}
+	if len(template.Metadata.Annotations) > 0 {
+		merged.Metadata.Annotations = CopyStringMap(merged.Metadata.Annotations)
+		for k, v := range template.Metadata.Annotations {
+			merged.Metadata.Annotations[k] = v
+		}
+	}
+	if len(template.Spec.Ports) > 0 {
+		merged.Spec.Ports = template.Spec.Ports
+	}
+	if template.Spec.ExternalTrafficPolicy != "" {
+		merged.Spec.ExternalTrafficPolicy = template.Spec.ExternalTrafficPolicy
+	}
+	if template.Spec.InternalTrafficPolicy != nil {
+		merged.Spec.InternalTrafficPolicy = template.Spec.InternalTrafficPolicy
+	}
+	if template.Spec.AllocateLoadBalancerNodePorts != nil {
+		merged.Spec.AllocateLoadBalancerNodePorts = template.Spec.AllocateLoadBalancerNodePorts
+	}
+	if len(template.Spec.LoadBalancerSourceRanges) > 0 {
+		merged.Spec.LoadBalancerSourceRanges = template.Spec.LoadBalancerSourceRanges
+	}
+}
+
+// applyServiceTemplateSpec sets the spec fields of the template on the Service, the ports get
+// the defaults of the API server so that they compare with the existing ones
+func applyServiceTemplateSpec(spec *corev1.ServiceSpec, template v2.ServiceTemplateSpec) {
+	if len(template.Ports) > 0 {
+		spec.Ports = []corev1.ServicePort{}
+		for _, port := range template.Ports {
+			if port.Protocol == "" {
+				port.Protocol = corev1.ProtocolTCP
+			}
+			if port.TargetPort.Type == intstr.Int && port.TargetPort.IntVal == 0 {
+				port.TargetPort = intstr.FromInt32(port.Port)
+			}
+			spec.Ports = append(spec.Ports, port)
+		}
+	}
+	if template.ExternalTrafficPolicy != "" {
+		spec.ExternalTrafficPolicy = template.ExternalTrafficPolicy
+	}
+	if template.InternalTrafficPolicy != nil {
+		internalTrafficPolicy := *template.InternalTrafficPolicy
+		spec.InternalTrafficPolicy = &internalTrafficPolicy
+	}
+	if template.AllocateLoadBalancerNodePorts != nil {
+		allocateLoadBalancerNodePorts := *template.AllocateLoadBalancerNodePorts
+		spec.AllocateLoadBalancerNodePorts = &allocateLoadBalancerNodePorts
+	}
+	if len(template.LoadBalancerSourceRanges) > 0 {
+		spec.LoadBalancerSourceRanges = append([]string{}, template.LoadBalancerSourceRanges...)
+	}
+}
+
+// SyncServiceSpec applies the spec fields set by the operator and the Service templates to
+// the existing Service, the node ports allocated to the existing ports are kept. It returns
+// true if the existing Service has changed.
+func SyncServiceSpec(existing *corev1.Service, rendered *corev1.Service) bool {
+	changed := false
+
+	ports := []corev1.ServicePort{}
+	for _, port := range rendered.Spec.Ports {
+		for _, current := range existing.Spec.Ports {
+			if current.Port == port.Port && current.Protocol == port.Protocol {
+				port.NodePort = current.NodePort
+			}
+		}
+		ports = append(ports, port)
+	}
+	if !reflect.DeepEqual(existing.Spec.Ports, ports) {
+		existing.Spec.Ports = ports
+		changed = true
+	}
+	if !reflect.DeepEqual(existing.Spec.Selector, rendered.Spec.Selector) {
+		existing.Spec.Selector = rendered.Spec.Selector
+		changed = true
+	}
+	if existing.Spec.ExternalTrafficPolicy != rendered.Spec.ExternalTrafficPolicy {
+		existing.Spec.ExternalTrafficPolicy = rendered.Spec.ExternalTrafficPolicy
+		changed = true
+	}
+	if !reflect.DeepEqual(existing.Spec.InternalTrafficPolicy, rendered.Spec.InternalTrafficPolicy) {
+		existing.Spec.InternalTrafficPolicy = rendered.Spec.InternalTrafficPolicy
+		changed = true
+	}
+	if !reflect.DeepEqual(existing.Spec.AllocateLoadBalancerNodePorts, rendered.Spec.AllocateLoadBalancerNodePorts) {
+		existing.Spec.AllocateLoadBalancerNodePorts = rendered.Spec.AllocateLoadBalancerNodePorts
+		changed = true
+	}
+	if len(existing.Spec.LoadBalancerSourceRanges) != 0 || len(rendered.Spec.LoadBalancerSourceRanges) != 0 {
+		if !reflect.DeepEqual(existing.Spec.LoadBalancerSourceRanges, rendered.Spec.LoadBalancerSourceRanges) {
+			existing.Spec.LoadBalancerSourceRanges = rendered.Spec.LoadBalancerSourceRanges
+			changed = true
+		}
+	}
+	return changed
+}
@@ -0,0 +1,134 @@
+package util
+
+import (
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"reflect"
+	"testing"
+)
+
+func TestPolicyServiceTemplate(t *testing.T) {
+	allocate := false
+	options := RenderOptions{
+		ServiceTemplate: v2.ServiceTemplate{
+			Metadata: v2.ServiceTemplateMetadata{Annotations: map[string]string{"a": "operator", "b": "operator"}},
+			Spec:     v2.ServiceTemplateSpec{ExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyCluster},
+		},
+		ServiceClasses: map[string]v2.ServiceTemplate{
+			"dhcp": {
+				Metadata: v2.ServiceTemplateMetadata{Annotations: map[string]string{"b": "class", "c": "class"}},
+				Spec: v2.ServiceTemplateSpec{
+					Ports:                         []corev1.ServicePort{{Name: "dns", Port: 53}},
+					AllocateLoadBalancerNodePorts: &allocate,
+				},
+			},
+		},
+	}
+	tests := []struct {
+		name       string
+		template   *v2.PolicyServiceTemplate
+		want       v2.ServiceTemplate
+		wantReason string
+	}{
+		{name: "operator template only", want: options.ServiceTemplate},
+		{
+			name: "class and policy merged in order",
+			template: &v2.PolicyServiceTemplate{
+				ClassName: "dhcp",
+				ServiceTemplate: v2.ServiceTemplate{
+					Metadata: v2.ServiceTemplateMetadata{Annotations: map[string]string{"c": "policy"}},
+					Spec:     v2.ServiceTemplateSpec{ExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyLocal},
+				},
+			},
+			want: v2.ServiceTemplate{
+				Metadata: v2.ServiceTemplateMetadata{Annotations: map[string]string{"a": "operator", "b": "class", "c": "policy"}},
+				Spec: v2.ServiceTemplateSpec{
+					Ports:                         []corev1.ServicePort{{Name: "dns", Port: 53}},
+					ExternalTrafficPolicy:         corev1.ServiceExternalTrafficPolicyLocal,
+					AllocateLoadBalancerNodePorts: &allocate,
+				},
+			},
+		},
+		{name: "unknown class", template: &v2.PolicyServiceTemplate{ClassName: "nope"}, wantReason: "UnknownServiceClass"},
+		{
+			name: "reserved key",
+			template: &v2.PolicyServiceTemplate{ServiceTemplate: v2.ServiceTemplate{
+				Metadata: v2.ServiceTemplateMetadata{Annotations: map[string]string{"kube-vip.io/vipHost": "node-1"}},
+			}},
+			wantReason: "InvalidServiceTemplate",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &v2.HAEgressGatewayPolicy{Spec: v2.HAEgressGatewayPolicySpec{ServiceTemplate: tt.template}}
+			got, err := PolicyServiceTemplate(policy, options)
+			if tt.wantReason != "" {
+				if ClassifyError(err) != ErrorPermanent || permanentErrorReason(err) != tt.wantReason {
+					t.Fatalf("PolicyServiceTemplate() error = %v, want a permanent %s error", err, tt.wantReason)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("PolicyServiceTemplate() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PolicyServiceTemplate() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	if options.ServiceTemplate.Metadata.Annotations["b"] != "operator" {
+		t.Errorf("the operator template has been modified")
+	}
+}
+
+func TestSyncServiceSpec(t *testing.T) {
+	local := corev1.ServiceInternalTrafficPolicyLocal
+	rendered := &corev1.Service{Spec: corev1.ServiceSpec{
+		Ports:                 []corev1.ServicePort{{Name: "nope", Protocol: corev1.ProtocolTCP, Port: 65534, TargetPort: intstr.FromInt32(65534)}},
+		Selector:              map[string]string{"a": "b"},
+		ExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyCluster,
+	}}
+	tests := []struct {
+		name        string
+		existing    corev1.ServiceSpec
+		wantChanged bool
+		wantPorts   []corev1.ServicePort
+	}{
+		{
+			name: "node port kept",
+			existing: corev1.ServiceSpec{
+				Ports:                 []corev1.ServicePort{{Name: "nope", Protocol: corev1.ProtocolTCP, Port: 65534, TargetPort: intstr.FromInt32(65534), NodePort: 31000}},
+				Selector:              map[string]string{"a": "b"},
+				ExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyCluster,
+			},
+			wantPorts: []corev1.ServicePort{{Name: "nope", Protocol: corev1.ProtocolTCP, Port: 65534, TargetPort: intstr.FromInt32(65534), NodePort: 31000}},
+		},
+		{
+			name: "template fields reset",
+			existing: corev1.ServiceSpec{
+				Ports:                    []corev1.ServicePort{{Name: "dns", Protocol: corev1.ProtocolUDP, Port: 53, NodePort: 31053}},
+				Selector:                 map[string]string{"a": "b"},
+				ExternalTrafficPolicy:    corev1.ServiceExternalTrafficPolicyLocal,
+				InternalTrafficPolicy:    &local,
+				LoadBalancerSourceRanges: []string{"10.0.0.0/8"},
+			},
+			wantChanged: true,
+			wantPorts:   []corev1.ServicePort{{Name: "nope", Protocol: corev1.ProtocolTCP, Port: 65534, TargetPort: intstr.FromInt32(65534)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			existing := &corev1.Service{Spec: tt.existing}
+			if changed := SyncServiceSpec(existing, rendered); changed != tt.wantChanged {
+				t.Errorf("SyncServiceSpec() = %v, want %v", changed, tt.wantChanged)
+			}
+			if !reflect.DeepEqual(existing.Spec.Ports, tt.wantPorts) {
+				t.Errorf("ports = %v, want %v", existing.Spec.Ports, tt.wantPorts)
+			}
+			if SyncServiceSpec(existing, rendered) {
+				t.Errorf("SyncServiceSpec() is not idempotent")
+			}
+		})
+	}
+}
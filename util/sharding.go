@@ -0,0 +1,147 @@
+package util
+
+import (
+	"fmt"
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	k8sConst "github.com/cilium/cilium/pkg/k8s/apis/cilium.io"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	"hash/fnv"
+	corev1 "k8s.io/api/core/v1"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// PolicyReplicas returns the number of egress IPs requested with the replicas annotation
+func PolicyReplicas(haEgressGatewayPolicy *v2.HAEgressGatewayPolicy) int {
+	replicas, err := strconv.Atoi(haEgressGatewayPolicy.Annotations[haegressip.ReplicasAnnotation])
+	if err != nil || replicas < 1 {
+		return 1
+	}
+	if replicas > haegressip.MaxReplicas {
+		return haegressip.MaxReplicas
+	}
+	return replicas
+}
+
+// ShardServiceName returns the name of the service holding the egress IP of a shard, the
+// first shard keeps the name of the policy. The name of a shard can be the name of another
+// policy, for example egress-1, the collisions are detected by the policies controller.
+func ShardServiceName(policyName string, shard int) string {
+	if shard == 0 {
+		return policyName
+	}
+	return fmt.Sprintf("%s-%d", policyName, shard)
+}
+
+// ShardServiceNames returns the names of the services of every shard of the policy
+func ShardServiceNames(policyName string, replicas int) []string {
+	names := []string{}
+	for shard := 0; shard < replicas; shard++ {
+		names = append(names, ShardServiceName(policyName, shard))
+	}
+	return names
+}
+
+// ShardLabel returns the pod label used to bucket the pods selected by the policy
+func ShardLabel(policyName string) string {
+	name := policyName
+	if len(name) > 63 {
+		hash := fnv.New32a()
+		_, _ = hash.Write([]byte(policyName))
+		name = fmt.Sprintf("%s-%08x", strings.TrimRight(name[:54], "-."), hash.Sum32())
+	}
+	return haegressip.ShardLabelPrefix + name
+}
+
+// PodShard deterministically assigns a pod to one of the shards using a hash of its name
+func PodShard(namespace string, name string, replicas int) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(namespace + "/" + name))
+	return int(hash.Sum32() % uint32(replicas))
+}
+
+// ShardSelectors restricts every selector of the policy to the pods bucketed in the shard.
+// The first shard is the catch-all: it selects every pod not bucketed in another shard, so
+// that the pods not labeled yet, or labeled for a removed shard, keep egressing.
+func ShardSelectors(selectors []ciliumv2.EgressRule, policyName string, shard int, replicas int) []ciliumv2.EgressRule {
+	shardLabel := ShardLabel(policyName)
+	sharded := []ciliumv2.EgressRule{}
+	for _, selector := range selectors {
+		rule := *selector.DeepCopy()
+		if rule.PodSelector == nil {
+			rule.PodSelector = &slimv1.LabelSelector{}
+		}
+		if shard > 0 {
+			if rule.PodSelector.MatchLabels == nil {
+				rule.PodSelector.MatchLabels = map[string]slimv1.MatchLabelsValue{}
+			}
+			rule.PodSelector.MatchLabels[shardLabel] = strconv.Itoa(shard)
+		} else {
+			others := []string{}
+			for other := 1; other < replicas; other++ {
+				others = append(others, strconv.Itoa(other))
+			}
+			rule.PodSelector.MatchExpressions = append(rule.PodSelector.MatchExpressions, slimv1.LabelSelectorRequirement{
+				Key:      shardLabel,
+				Operator: slimv1.LabelSelectorOpNotIn,
+				Values:   others,
+			})
+		}
+		sharded = append(sharded, rule)
+	}
+	return sharded
+}
+
+// PolicyNamespaces returns the namespaces of the pods the policy can select, nil when every
+// namespace can be selected: a selector restricts the namespace only with the Cilium
+// namespace label of the pods or the kubernetes.io/metadata.name label of the namespaces
+func PolicyNamespaces(spec ciliumv2.CiliumEgressGatewayPolicySpec) []string {
+	namespaces := []string{}
+	for _, rule := range spec.Selectors {
+		namespace := ""
+		if rule.PodSelector != nil {
+			namespace = rule.PodSelector.MatchLabels[k8sConst.PodNamespaceLabel]
+		}
+		if namespace == "" && rule.NamespaceSelector != nil {
+			namespace = rule.NamespaceSelector.MatchLabels[corev1.LabelMetadataName]
+		}
+		if namespace == "" {
+			return nil
+		}
+		if !slices.Contains(namespaces, namespace) {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
+
+// ShardLoadBalancerIPs returns the kube-vip requested IPs for a shard: when the policy has
+// more replicas, the comma separated IPs are assigned to the shards in order
+func ShardLoadBalancerIPs(requested string, shard int, replicas int) string {
+	if replicas <= 1 {
+		return requested
+	}
+	ips := strings.Split(requested, ",")
+	if shard < len(ips) {
+		return strings.TrimSpace(ips[shard])
+	}
+	return ""
+}
+
+// PolicyExitNode returns the exit node recorded in the policy status for the service, the
+// shards after the first one are reported in the shards status
+func PolicyExitNode(haEgressGatewayPolicy *v2.HAEgressGatewayPolicy, service *corev1.Service) string {
+	shard, err := strconv.Atoi(service.Labels[haegressip.ShardIndexLabel])
+	if err != nil || shard == 0 {
+		return haEgressGatewayPolicy.Status.ExitNode
+	}
+	for _, status := range haEgressGatewayPolicy.Status.Shards {
+		if int(status.Shard) == shard {
+			return status.ExitNode
+		}
+	}
+	return ""
+}
@@ -0,0 +1,198 @@
+package util
+
+import (
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	slimv1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPolicyReplicas(t *testing.T) {
+	tests := []struct {
+		name       string
+		annotation string
+		want       int
+	}{
+		{name: "missing", want: 1},
+		{name: "malformed", annotation: "three", want: 1},
+		{name: "zero", annotation: "0", want: 1},
+		{name: "three", annotation: "3", want: 3},
+		{name: "capped", annotation: "100", want: haegressip.MaxReplicas},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &v2.HAEgressGatewayPolicy{}
+			if tt.annotation != "" {
+				policy.Annotations = map[string]string{haegressip.ReplicasAnnotation: tt.annotation}
+			}
+			if got := PolicyReplicas(policy); got != tt.want {
+				t.Errorf("PolicyReplicas() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShardServiceNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		replicas int
+		want     []string
+	}{
+		{name: "single replica", replicas: 1, want: []string{"egress"}},
+		{name: "three replicas", replicas: 3, want: []string{"egress", "egress-1", "egress-2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ShardServiceNames("egress", tt.replicas); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ShardServiceNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShardLabel(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		want   string
+	}{
+		{name: "short name", policy: "egress", want: haegressip.ShardLabelPrefix + "egress"},
+		{name: "long name hashed", policy: strings.Repeat("a", 70), want: haegressip.ShardLabelPrefix + strings.Repeat("a", 54) + "-"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ShardLabel(tt.policy)
+			if !strings.HasPrefix(got, tt.want) || len(got)-len(haegressip.ShardLabelPrefix) > 63 {
+				t.Errorf("ShardLabel() = %q, want a name of 63 characters at most starting with %q", got, tt.want)
+			}
+			if got != ShardLabel(tt.policy) {
+				t.Errorf("ShardLabel() is not deterministic")
+			}
+		})
+	}
+}
+
+func TestShardSelectors(t *testing.T) {
+	shardLabel := ShardLabel("egress")
+	selectors := []ciliumv2.EgressRule{
+		{PodSelector: &slimv1.LabelSelector{MatchLabels: map[string]slimv1.MatchLabelsValue{"app": "web"}}},
+		{NamespaceSelector: &slimv1.LabelSelector{MatchLabels: map[string]slimv1.MatchLabelsValue{"team": "a"}}},
+	}
+	tests := []struct {
+		name     string
+		shard    int
+		replicas int
+		matches  map[string]bool
+	}{
+		{
+			name:     "first shard is the catch-all",
+			shard:    0,
+			replicas: 3,
+			matches:  map[string]bool{"": true, "0": true, "1": false, "2": false, "5": true},
+		},
+		{
+			name:     "other shard",
+			shard:    2,
+			replicas: 3,
+			matches:  map[string]bool{"": false, "0": false, "1": false, "2": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sharded := ShardSelectors(selectors, "egress", tt.shard, tt.replicas)
+			if len(sharded) != len(selectors) {
+				t.Fatalf("ShardSelectors() returned %d selectors, want %d", len(sharded), len(selectors))
+			}
+			for value, want := range tt.matches {
+				podLabels := labels.Set{"app": "web"}
+				if value != "" {
+					podLabels[shardLabel] = value
+				}
+				for i, rule := range sharded {
+					selector, err := slimv1.LabelSelectorAsSelector(rule.PodSelector)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got := selector.Matches(podLabels); got != want {
+						t.Errorf("selector %d matches shard label %q = %v, want %v", i, value, got, want)
+					}
+				}
+			}
+			if _, ok := selectors[0].PodSelector.MatchLabels[shardLabel]; ok || selectors[1].PodSelector != nil {
+				t.Errorf("ShardSelectors() modified the policy selectors")
+			}
+		})
+	}
+}
+
+func TestPolicyNamespaces(t *testing.T) {
+	podNamespace := func(namespace string) ciliumv2.EgressRule {
+		return ciliumv2.EgressRule{PodSelector: &slimv1.LabelSelector{
+			MatchLabels: map[string]slimv1.MatchLabelsValue{"io.kubernetes.pod.namespace": namespace},
+		}}
+	}
+	tests := []struct {
+		name      string
+		selectors []ciliumv2.EgressRule
+		want      []string
+	}{
+		{name: "no selector", selectors: []ciliumv2.EgressRule{}, want: []string{}},
+		{name: "pod namespace label", selectors: []ciliumv2.EgressRule{podNamespace("a"), podNamespace("b"), podNamespace("a")}, want: []string{"a", "b"}},
+		{
+			name: "namespace name label",
+			selectors: []ciliumv2.EgressRule{{NamespaceSelector: &slimv1.LabelSelector{
+				MatchLabels: map[string]slimv1.MatchLabelsValue{"kubernetes.io/metadata.name": "c"},
+			}}},
+			want: []string{"c"},
+		},
+		{
+			name: "any namespace",
+			selectors: []ciliumv2.EgressRule{podNamespace("a"), {PodSelector: &slimv1.LabelSelector{
+				MatchLabels: map[string]slimv1.MatchLabelsValue{"app": "web"},
+			}}},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := PolicyNamespaces(ciliumv2.CiliumEgressGatewayPolicySpec{Selectors: tt.selectors})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PolicyNamespaces() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShardLoadBalancerIPs(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested string
+		shard     int
+		replicas  int
+		want      string
+	}{
+		{name: "single replica keeps every IP", requested: "10.0.0.1,10.0.0.2", replicas: 1, want: "10.0.0.1,10.0.0.2"},
+		{name: "IP of the shard", requested: "10.0.0.1, 10.0.0.2", shard: 1, replicas: 2, want: "10.0.0.2"},
+		{name: "shard without IP", requested: "10.0.0.1", shard: 2, replicas: 3, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ShardLoadBalancerIPs(tt.requested, tt.shard, tt.replicas); got != tt.want {
+				t.Errorf("ShardLoadBalancerIPs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPodShard(t *testing.T) {
+	for _, name := range []string{"a", "b", "web-5d9f8c7b6-x2k4j"} {
+		shard := PodShard("default", name, 3)
+		if shard < 0 || shard >= 3 || shard != PodShard("default", name, 3) {
+			t.Errorf("PodShard(%q) = %d, want a stable shard below 3", name, shard)
+		}
+	}
+}
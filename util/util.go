@@ -5,17 +5,24 @@ import (
 	"fmt"
 	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
 	haegressip "github.com/angeloxx/cilium-haegress-operator/pkg"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/metrics"
+	"github.com/angeloxx/cilium-haegress-operator/pkg/provider"
 	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
+	"reflect"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func SyncServiceWithCiliumEgressGatewayPolicy(ctx context.Context, r client.Client, logger logr.Logger, recorder record.EventRecorder, service corev1.Service, ciliumEgressGatewayPolicy ciliumv2.CiliumEgressGatewayPolicy) (ctrl.Result, error) {
+func SyncServiceWithCiliumEgressGatewayPolicy(ctx context.Context, r client.Client, logger logr.Logger, recorder record.EventRecorder, service corev1.Service, ciliumEgressGatewayPolicy ciliumv2.CiliumEgressGatewayPolicy, dampening *Dampening, vipProvider provider.Provider) (ctrl.Result, error) {
 
 	// Get the parent HAEgressGatewayPolicy from the ciliumEgressGatewayPolicy
 	haEgressGatewayPolicy := &v2.HAEgressGatewayPolicy{}
@@ -33,19 +40,70 @@ func SyncServiceWithCiliumEgressGatewayPolicy(ctx context.Context, r client.Clie
 	policyHost := string(ciliumEgressGatewayPolicy.Spec.EgressGateway.NodeSelector.MatchLabels[haegressip.NodeNameAnnotation])
 	currentHost := string(service.Annotations[haegressip.KubeVIPVipHostAnnotation])
 
-	if len(service.Status.LoadBalancer.Ingress) > 0 {
-		if ciliumEgressGatewayPolicy.Spec.EgressGateway.EgressIP != service.Status.LoadBalancer.Ingress[0].IP {
-			ciliumEgressGatewayPolicy.Spec.EgressGateway.EgressIP = service.Status.LoadBalancer.Ingress[0].IP
+	// Refuse to use an IP that is requested or held by another LoadBalancer service
+	conflicts, err := LoadBalancerIPConflicts(ctx, r, &service)
+	if err != nil {
+		logger.Error(err, "unable to check LoadBalancerIP conflicts, retry later")
+		return ctrl.Result{}, err
+	}
+	if haEgressGatewayPolicy.Name != "" {
+		status, reason, message := metav1.ConditionFalse, "NoConflict", "LoadBalancerIP is not contested"
+		if len(conflicts) > 0 {
+			status, reason, message = metav1.ConditionTrue, "Contested", fmt.Sprintf("LoadBalancerIP is contested: %s", strings.Join(conflicts, ", "))
+		}
+		changed, err := UpdatePolicyCondition(ctx, r, haEgressGatewayPolicy, haegressip.ConditionIPConflict, status, reason, message)
+		if err != nil {
+			logger.Error(err, "unable to update the HAEgressGatewayPolicy conditions")
+		}
+		if changed && len(conflicts) > 0 {
+			recorder.Event(haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventIPConflictReason, message)
+		}
+	}
+
+	// The policy status reports the first shard, every shard is reported in the shards list
+	shard, shardErr := strconv.Atoi(service.Labels[haegressip.ShardIndexLabel])
+	primaryShard := shardErr != nil || shard == 0
+	if shardErr == nil && haEgressGatewayPolicy.Name != "" {
+		if setShardStatus(haEgressGatewayPolicy, v2.HAEgressGatewayPolicyShardStatus{
+			Shard:     int32(shard),
+			Service:   service.Name,
+			ExitNode:  currentHost,
+			IPAddress: LoadBalancerIPv4(&service),
+		}) {
+			if err := r.Status().Update(ctx, haEgressGatewayPolicy); err != nil {
+				logger.Error(err, "unable to update the HAEgressGatewayPolicy shards status")
+			}
+		}
+	}
+
+	// A contested IP is removed from the CiliumEgressGatewayPolicy and the VIP is not followed,
+	// the traffic must not leave with an IP announced for another service
+	if len(conflicts) > 0 {
+		logger.Info("LoadBalancerIP is contested, CiliumEgressGatewayPolicy will not use it", "conflicts", conflicts)
+		if ciliumEgressGatewayPolicy.Spec.EgressGateway.EgressIP != "" {
+			ciliumEgressGatewayPolicy.Spec.EgressGateway.EgressIP = ""
+			if err := r.Update(ctx, &ciliumEgressGatewayPolicy); err != nil {
+				logger.Error(err, "unable to remove the contested IP from the CiliumEgressGatewayPolicy, retry later")
+				return ctrl.Result{}, err
+			}
+			recorder.Event(&ciliumEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventIPConflictReason,
+				fmt.Sprintf("Egress IP removed, it is contested: %s", strings.Join(conflicts, ", ")))
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// The egress IP is IPv4 only, an IPv6 address of a dual-stack Service is never used
+	if loadBalancerIP := LoadBalancerIPv4(&service); loadBalancerIP != "" {
+		if ciliumEgressGatewayPolicy.Spec.EgressGateway.EgressIP != loadBalancerIP {
+			ciliumEgressGatewayPolicy.Spec.EgressGateway.EgressIP = loadBalancerIP
 			if err := r.Update(ctx, &ciliumEgressGatewayPolicy); err != nil {
 				logger.Error(err, "unable to update the CiliumEgressGatewayPolicy with new assigned IP, retry later")
-				return ctrl.Result{RequeueAfter: haegressip.HAEgressGatewayPolicyChcekRequeueAfter}, nil
+				return ctrl.Result{}, err
 			}
-			logger.Info("Updated CiliumEgressGatewayPolicy with LoadBalancerIP", "LoadBalancerIP", service.Status.LoadBalancer.Ingress[0].IP)
+			logger.Info("Updated CiliumEgressGatewayPolicy with LoadBalancerIP", "LoadBalancerIP", loadBalancerIP)
 
 		}
-		if haEgressGatewayPolicy.Status.IPAddress != service.Status.LoadBalancer.Ingress[0].IP {
-			haEgressGatewayPolicy.Status.IPAddress = service.Status.LoadBalancer.Ingress[0].IP
-			haEgressGatewayPolicy.Status.LastModifiedTime = metav1.Now()
+		if setPolicyAddresses(haEgressGatewayPolicy, primaryShard, AssignedLoadBalancerIPs(&service)) {
 			if err := r.Status().Update(ctx, haEgressGatewayPolicy); err != nil {
 				logger.Error(err, "unable to update the HAEgressGatewayPolicy with new assigned IP")
 			}
@@ -57,7 +115,7 @@ func SyncServiceWithCiliumEgressGatewayPolicy(ctx context.Context, r client.Clie
 		return ctrl.Result{}, nil
 	}
 
-	if haEgressGatewayPolicy.Status.ExitNode != currentHost {
+	if primaryShard && haEgressGatewayPolicy.Status.ExitNode != currentHost {
 		haEgressGatewayPolicy.Status.ExitNode = currentHost
 		haEgressGatewayPolicy.Status.LastModifiedTime = metav1.Now()
 		if err := r.Status().Update(ctx, haEgressGatewayPolicy); err != nil {
@@ -65,9 +123,42 @@ func SyncServiceWithCiliumEgressGatewayPolicy(ctx context.Context, r client.Clie
 		}
 	}
 
+	now := time.Now()
+	dampeningKey := DampeningKey{Policy: haEgressGatewayPolicy.Name, CiliumEgressGatewayPolicy: ciliumEgressGatewayPolicy.Name}
+	dampening.Observe(dampeningKey, currentHost, now)
+
+	// Policies with affinity rules are re-evaluated when the other policies move
+	result := ctrl.Result{}
+	if PolicyHasAffinity(haEgressGatewayPolicy) {
+		result.RequeueAfter = haegressip.AffinityCheckRequeueAfter.Get()
+	}
+
 	if policyHost == currentHost {
+		syncFlappingCondition(ctx, r, logger, recorder, haEgressGatewayPolicy, ciliumEgressGatewayPolicy.Name, dampening, now)
+		enforcePlacement(ctx, r, logger, recorder, vipProvider, haEgressGatewayPolicy, &service, currentHost, true)
 		logger.V(1).Info(fmt.Sprintf("EgressGatewayPolicy already configured as expected with host %s, ignoring.", currentHost))
-		return ctrl.Result{}, nil
+		return result, nil
+	}
+
+	// A node that is gone or not ready is a hard failure, the VIP is followed immediately
+	// and moved again later if it breaks the affinity rules
+	hardFailure := policyHost == "" || NodeFailed(ctx, r, policyHost)
+	// A move requested with the move-to annotation has been validated already and is not dampened
+	if haEgressGatewayPolicy.Annotations[haegressip.MoveToAnnotation] == currentHost {
+		hardFailure = true
+	}
+	if !hardFailure && enforcePlacement(ctx, r, logger, recorder, vipProvider, haEgressGatewayPolicy, &service, currentHost, false) {
+		return ctrl.Result{RequeueAfter: haegressip.LeaseCheckRequeueAfter.Get()}, nil
+	}
+	decision := dampening.Follow(dampeningKey, hardFailure, now)
+	syncFlappingCondition(ctx, r, logger, recorder, haEgressGatewayPolicy, ciliumEgressGatewayPolicy.Name, dampening, now)
+	if decision.Reason != DampeningNone {
+		if decision.FirstDelay {
+			metrics.VIPMovesDampened.WithLabelValues(haEgressGatewayPolicy.Name, ciliumEgressGatewayPolicy.Name, string(decision.Reason)).Inc()
+			logger.Info("VIP move dampened, CiliumEgressGatewayPolicy will follow it later",
+				"from", policyHost, "to", currentHost, "reason", decision.Reason, "retryAfter", decision.RetryAfter.String())
+		}
+		return ctrl.Result{RequeueAfter: decision.RetryAfter}, nil
 	}
 
 	logger.V(0).Info(fmt.Sprintf("EgressGatewayPolicy should be updated from %s to %s.", policyHost, currentHost))
@@ -78,7 +169,7 @@ func SyncServiceWithCiliumEgressGatewayPolicy(ctx context.Context, r client.Clie
 	logger.V(0).Info(fmt.Sprintf("Patching cilium egress gateway policy %s with host %s", ciliumEgressGatewayPolicy.Name, currentHost))
 	if err := r.Patch(ctx, &ciliumEgressGatewayPolicy, client.RawPatch(types.MergePatchType, []byte(patchData))); err != nil {
 		logger.V(0).Info(fmt.Sprintf("Unable to patch cilium egress gateway policy %s", ciliumEgressGatewayPolicy.Name))
-		return ctrl.Result{RequeueAfter: haegressip.LeaseCheckRequeueAfter}, err
+		return ctrl.Result{}, err
 	}
 
 	recorder.Event(&ciliumEgressGatewayPolicy, "Normal",
@@ -92,5 +183,141 @@ func SyncServiceWithCiliumEgressGatewayPolicy(ctx context.Context, r client.Clie
 		fmt.Sprintf("Updated CiliumEgressGatewayPolicy %s with new nodeSelector %s=%s",
 			ciliumEgressGatewayPolicy.Name,
 			haegressip.NodeNameAnnotation, currentHost))
-	return ctrl.Result{}, nil
+	return result, nil
+}
+
+// enforcePlacement moves the VIP, through the provider, away from a node breaking the
+// affinity rules of the policy and sets the AffinityViolated condition. It returns true if
+// the VIP is being moved. Once the VIP is settled only the policy with the highest name
+// among the involved ones moves, so that two policies do not move at once.
+func enforcePlacement(ctx context.Context, r client.Client, logger logr.Logger, recorder record.EventRecorder, vipProvider provider.Provider, haEgressGatewayPolicy *v2.HAEgressGatewayPolicy, service *corev1.Service, currentHost string, settled bool) bool {
+	if haEgressGatewayPolicy.Name == "" || !PolicyHasAffinity(haEgressGatewayPolicy) {
+		return false
+	}
+	violations, err := NodePlacementViolations(ctx, r, haEgressGatewayPolicy, currentHost)
+	if err != nil {
+		logger.Error(err, "unable to evaluate the affinity rules")
+		return false
+	}
+	if len(violations) == 0 {
+		if _, err := UpdatePolicyCondition(ctx, r, haEgressGatewayPolicy, haegressip.ConditionAffinityViolated,
+			metav1.ConditionFalse, "Satisfied", "The egress IPs satisfy the affinity rules"); err != nil {
+			logger.Error(err, "unable to update the HAEgressGatewayPolicy conditions")
+		}
+		return false
+	}
+
+	messages := []string{}
+	for _, violation := range violations {
+		if settled && violation.Policy > haEgressGatewayPolicy.Name {
+			return false
+		}
+		messages = append(messages, violation.Message)
+	}
+
+	target := ""
+	allowed, err := AllowedNodes(ctx, r, haEgressGatewayPolicy)
+	if err != nil {
+		logger.Error(err, "unable to evaluate the allowed nodes")
+		return false
+	}
+	for _, node := range allowed {
+		if node.Name != currentHost && (target == "" || node.Name < target) {
+			target = node.Name
+		}
+	}
+
+	status, reason := metav1.ConditionTrue, "NoAllowedNode"
+	message := fmt.Sprintf("Node %s breaks %s and no other node is allowed", currentHost, strings.Join(messages, ", "))
+	if target != "" && vipProvider != nil {
+		if err := vipProvider.MoveVIP(ctx, service, target); err != nil {
+			logger.Error(err, "unable to move the VIP to satisfy the affinity rules", "node", target)
+			return false
+		}
+		reason = "Moving"
+		message = fmt.Sprintf("Node %s breaks %s, moving the VIP of %s/%s to %s",
+			currentHost, strings.Join(messages, ", "), service.Namespace, service.Name, target)
+		logger.Info("Moving VIP to satisfy the affinity rules", "from", currentHost, "to", target, "violations", messages)
+	}
+	changed, err := UpdatePolicyCondition(ctx, r, haEgressGatewayPolicy, haegressip.ConditionAffinityViolated, status, reason, message)
+	if err != nil {
+		logger.Error(err, "unable to update the HAEgressGatewayPolicy conditions")
+	}
+	if changed || reason == "Moving" {
+		recorder.Event(haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.EventAffinityReason, message)
+	}
+	return reason == "Moving"
+}
+
+// syncFlappingCondition sets the Flapping condition of the policy and the flapping metric of
+// the CiliumEgressGatewayPolicy, only when the dampening is enabled
+func syncFlappingCondition(ctx context.Context, r client.Client, logger logr.Logger, recorder record.EventRecorder, haEgressGatewayPolicy *v2.HAEgressGatewayPolicy, ciliumEgressGatewayPolicyName string, dampening *Dampening, now time.Time) {
+	maxMoves, movesWindow := dampening.Limits()
+	if maxMoves <= 0 || haEgressGatewayPolicy.Name == "" {
+		return
+	}
+	flapping := dampening.Flapping(haEgressGatewayPolicy.Name, now)
+	gauge := 0.0
+	if containsString(flapping, ciliumEgressGatewayPolicyName) {
+		gauge = 1
+	}
+	metrics.VIPFlapping.WithLabelValues(haEgressGatewayPolicy.Name, ciliumEgressGatewayPolicyName).Set(gauge)
+
+	status, reason, message := metav1.ConditionFalse, "Stable", "The VIPs are not flapping"
+	if len(flapping) > 0 {
+		status, reason, message = metav1.ConditionTrue, "TooManyMoves", fmt.Sprintf(
+			"The VIPs of %s moved at least %d times in %s, further moves are not followed unless the node fails",
+			strings.Join(flapping, ", "), maxMoves, movesWindow)
+	}
+	changed, err := UpdatePolicyCondition(ctx, r, haEgressGatewayPolicy, haegressip.ConditionFlapping, status, reason, message)
+	if err != nil {
+		logger.Error(err, "unable to update the HAEgressGatewayPolicy conditions")
+	}
+	if changed && len(flapping) > 0 {
+		recorder.Event(haEgressGatewayPolicy, corev1.EventTypeWarning, haegressip.ConditionFlapping, message)
+	}
+}
+
+// CopyStringMap returns a copy of a labels or annotations map, never nil
+func CopyStringMap(m map[string]string) map[string]string {
+	copied := make(map[string]string, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
+
+// setPolicyAddresses reports the IPs of the primary shard in the policy status, the IPs of
+// the other shards are reported in the shards list only. It returns true if something has
+// been changed.
+func setPolicyAddresses(haEgressGatewayPolicy *v2.HAEgressGatewayPolicy, primaryShard bool, assigned []string) bool {
+	if !primaryShard || len(assigned) == 0 {
+		return false
+	}
+	if haEgressGatewayPolicy.Status.IPAddress == assigned[0] && reflect.DeepEqual(haEgressGatewayPolicy.Status.IPAddresses, assigned) {
+		return false
+	}
+	haEgressGatewayPolicy.Status.IPAddress = assigned[0]
+	haEgressGatewayPolicy.Status.IPAddresses = assigned
+	haEgressGatewayPolicy.Status.LastModifiedTime = metav1.Now()
+	return true
+}
+
+// setShardStatus sets the status of a shard in the policy status, it returns true if something
+// has been changed
+func setShardStatus(haEgressGatewayPolicy *v2.HAEgressGatewayPolicy, shardStatus v2.HAEgressGatewayPolicyShardStatus) bool {
+	for i, existing := range haEgressGatewayPolicy.Status.Shards {
+		if existing.Shard == shardStatus.Shard {
+			if existing == shardStatus {
+				return false
+			}
+			haEgressGatewayPolicy.Status.Shards[i] = shardStatus
+			return true
+		}
+	}
+	haEgressGatewayPolicy.Status.Shards = append(haEgressGatewayPolicy.Status.Shards, shardStatus)
+	sort.Slice(haEgressGatewayPolicy.Status.Shards, func(i, j int) bool {
+		return haEgressGatewayPolicy.Status.Shards[i].Shard < haEgressGatewayPolicy.Status.Shards[j].Shard
+	})
+	return true
 }
@@ -0,0 +1,95 @@
+package util
+
+import (
+	v2 "github.com/angeloxx/cilium-haegress-operator/api/v2"
+	"reflect"
+	"testing"
+)
+
+func TestSetPolicyAddresses(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        v2.HAEgressGatewayPolicyStatus
+		primaryShard  bool
+		assigned      []string
+		wantChanged   bool
+		wantIPAddress string
+		wantAddresses []string
+	}{
+		{
+			name:          "primary shard sets the addresses",
+			primaryShard:  true,
+			assigned:      []string{"10.0.0.1", "fd00::1"},
+			wantChanged:   true,
+			wantIPAddress: "10.0.0.1",
+			wantAddresses: []string{"10.0.0.1", "fd00::1"},
+		},
+		{
+			name:          "primary shard without changes",
+			status:        v2.HAEgressGatewayPolicyStatus{IPAddress: "10.0.0.1", IPAddresses: []string{"10.0.0.1"}},
+			primaryShard:  true,
+			assigned:      []string{"10.0.0.1"},
+			wantIPAddress: "10.0.0.1",
+			wantAddresses: []string{"10.0.0.1"},
+		},
+		{
+			name:          "secondary shard keeps the addresses of the primary one",
+			status:        v2.HAEgressGatewayPolicyStatus{IPAddress: "10.0.0.1", IPAddresses: []string{"10.0.0.1"}},
+			assigned:      []string{"10.0.0.2"},
+			wantIPAddress: "10.0.0.1",
+			wantAddresses: []string{"10.0.0.1"},
+		},
+		{
+			name:          "secondary shard with different addresses list",
+			status:        v2.HAEgressGatewayPolicyStatus{IPAddress: "10.0.0.1"},
+			assigned:      []string{"10.0.0.1"},
+			wantIPAddress: "10.0.0.1",
+		},
+		{
+			name:         "primary shard without assigned IP",
+			primaryShard: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &v2.HAEgressGatewayPolicy{Status: tt.status}
+			if changed := setPolicyAddresses(policy, tt.primaryShard, tt.assigned); changed != tt.wantChanged {
+				t.Errorf("setPolicyAddresses() = %v, want %v", changed, tt.wantChanged)
+			}
+			if policy.Status.IPAddress != tt.wantIPAddress || !reflect.DeepEqual(policy.Status.IPAddresses, tt.wantAddresses) {
+				t.Errorf("status = %s %v, want %s %v", policy.Status.IPAddress, policy.Status.IPAddresses, tt.wantIPAddress, tt.wantAddresses)
+			}
+		})
+	}
+}
+
+func TestSetShardStatus(t *testing.T) {
+	shard0 := v2.HAEgressGatewayPolicyShardStatus{Shard: 0, Service: "p", IPAddress: "10.0.0.1"}
+	shard1 := v2.HAEgressGatewayPolicyShardStatus{Shard: 1, Service: "p-1", IPAddress: "10.0.0.2"}
+	tests := []struct {
+		name        string
+		shards      []v2.HAEgressGatewayPolicyShardStatus
+		set         v2.HAEgressGatewayPolicyShardStatus
+		wantChanged bool
+		want        []v2.HAEgressGatewayPolicyShardStatus
+	}{
+		{"added in order", []v2.HAEgressGatewayPolicyShardStatus{shard1}, shard0, true, []v2.HAEgressGatewayPolicyShardStatus{shard0, shard1}},
+		{"unchanged", []v2.HAEgressGatewayPolicyShardStatus{shard0, shard1}, shard1, false, []v2.HAEgressGatewayPolicyShardStatus{shard0, shard1}},
+		{
+			"updated", []v2.HAEgressGatewayPolicyShardStatus{shard0, shard1},
+			v2.HAEgressGatewayPolicyShardStatus{Shard: 1, Service: "p-1", IPAddress: "10.0.0.3"}, true,
+			[]v2.HAEgressGatewayPolicyShardStatus{shard0, {Shard: 1, Service: "p-1", IPAddress: "10.0.0.3"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &v2.HAEgressGatewayPolicy{Status: v2.HAEgressGatewayPolicyStatus{Shards: tt.shards}}
+			if changed := setShardStatus(policy, tt.set); changed != tt.wantChanged {
+				t.Errorf("setShardStatus() = %v, want %v", changed, tt.wantChanged)
+			}
+			if !reflect.DeepEqual(policy.Status.Shards, tt.want) {
+				t.Errorf("shards = %v, want %v", policy.Status.Shards, tt.want)
+			}
+		})
+	}
+}
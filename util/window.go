@@ -0,0 +1,66 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeWindow is a daily time window such as 02:00-04:30, in the operator time zone. The
+// window can span midnight, the zero value is always open.
+type TimeWindow struct {
+	start time.Duration
+	end   time.Duration
+	set   bool
+}
+
+// ParseTimeWindow parses a HH:MM-HH:MM daily window, an empty string is always open
+func ParseTimeWindow(window string) (TimeWindow, error) {
+	window = strings.TrimSpace(window)
+	if window == "" {
+		return TimeWindow{}, nil
+	}
+	parts := strings.Split(window, "-")
+	if len(parts) != 2 {
+		return TimeWindow{}, fmt.Errorf("invalid time window %q, expected HH:MM-HH:MM", window)
+	}
+	start, err := time.Parse("15:04", strings.TrimSpace(parts[0]))
+	if err != nil {
+		return TimeWindow{}, fmt.Errorf("invalid time window %q: %w", window, err)
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(parts[1]))
+	if err != nil {
+		return TimeWindow{}, fmt.Errorf("invalid time window %q: %w", window, err)
+	}
+	return TimeWindow{
+		start: time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute,
+		end:   time.Duration(end.Hour())*time.Hour + time.Duration(end.Minute())*time.Minute,
+		set:   true,
+	}, nil
+}
+
+// Contains returns true if the time is inside the window
+func (w TimeWindow) Contains(t time.Time) bool {
+	if !w.set {
+		return true
+	}
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if w.start <= w.end {
+		return offset >= w.start && offset < w.end
+	}
+	return offset >= w.start || offset < w.end
+}
+
+func (w TimeWindow) String() string {
+	if !w.set {
+		return "always"
+	}
+	return fmt.Sprintf("%02d:%02d-%02d:%02d",
+		int(w.start.Hours()), int(w.start.Minutes())%60,
+		int(w.end.Hours()), int(w.end.Minutes())%60)
+}
+
+// IsSet returns false for the always open window
+func (w TimeWindow) IsSet() bool {
+	return w.set
+}
@@ -0,0 +1,72 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeWindow(t *testing.T) {
+	tests := []struct {
+		name    string
+		window  string
+		want    string
+		wantErr bool
+	}{
+		{"empty", "", "always", false},
+		{"blank", "  ", "always", false},
+		{"window", "02:00-04:30", "02:00-04:30", false},
+		{"spaces", " 2:00 - 4:30 ", "02:00-04:30", false},
+		{"across midnight", "22:00-02:00", "22:00-02:00", false},
+		{"single time", "02:00", "", true},
+		{"too many parts", "02:00-03:00-04:00", "", true},
+		{"invalid start", "25:00-04:00", "", true},
+		{"invalid end", "02:00-4pm", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			window, err := ParseTimeWindow(tt.window)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTimeWindow() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && window.String() != tt.want {
+				t.Errorf("ParseTimeWindow() = %s, want %s", window, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeWindowContains(t *testing.T) {
+	at := func(hour, minute, second int) time.Time {
+		return time.Date(2024, 3, 1, hour, minute, second, 0, time.UTC)
+	}
+	tests := []struct {
+		name   string
+		window string
+		time   time.Time
+		want   bool
+	}{
+		{"always open", "", at(12, 0, 0), true},
+		{"start included", "02:00-04:00", at(2, 0, 0), true},
+		{"inside", "02:00-04:00", at(3, 59, 59), true},
+		{"end excluded", "02:00-04:00", at(4, 0, 0), false},
+		{"before", "02:00-04:00", at(1, 59, 59), false},
+		{"across midnight before midnight", "22:00-02:00", at(23, 30, 0), true},
+		{"across midnight after midnight", "22:00-02:00", at(1, 0, 0), true},
+		{"across midnight outside", "22:00-02:00", at(12, 0, 0), false},
+		{"across midnight end excluded", "22:00-02:00", at(2, 0, 0), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			window, err := ParseTimeWindow(tt.window)
+			if err != nil {
+				t.Fatalf("ParseTimeWindow() error = %v", err)
+			}
+			if got := window.Contains(tt.time); got != tt.want {
+				t.Errorf("Contains(%s) = %v, want %v", tt.time.Format("15:04:05"), got, tt.want)
+			}
+			if window.IsSet() != (tt.window != "") {
+				t.Errorf("IsSet() = %v, want %v", window.IsSet(), tt.window != "")
+			}
+		})
+	}
+}